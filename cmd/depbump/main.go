@@ -0,0 +1,631 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/vancluever/depbump"
+)
+
+const help = `usage: depbump [OPTIONS] PATH [COMMAND]
+       depbump [OPTIONS] PATH -- COMMAND [ARGS...]
+       depbump [OPTIONS] go [VERSION]
+       depbump [OPTIONS] tidy
+       depbump [OPTIONS] cleanup
+       depbump [OPTIONS] -group PREFIX [COMMAND]
+
+options:
+  -nopush             commit locally, but do not push or create a PR
+  -nopr               push, but do not create a PR
+  -token TOKEN_NAME   environment variable holding the GitHub token
+  -github-app-id ID   authenticate as GitHub App ID, instead of with a token
+  -github-app-installation-id ID
+                      installation of the GitHub App to use
+  -github-app-key-file FILE
+                      private key of the GitHub App
+  -github-app-push    also push over HTTPS as the GitHub App
+  -verbose            log debug messages, and attribute command output
+  -log-format FORMAT  log as text or json (default text)
+  -color MODE         color text logs: always, never, or auto (default auto)
+  -no-actions-output  do not write GitHub Actions outputs to $GITHUB_OUTPUT
+  -summary-file PATH  write a JSON summary of the run to PATH
+  -webhook-url URL    post the result of the run to URL as JSON
+  -webhook-secret SECRET
+                      sign the webhook body with SECRET (HMAC-SHA256)
+  -slack-webhook URL  notify Slack of created PRs and failed runs
+  -wait-for-checks[=DURATION]
+                      wait for the PR's checks, failing if they fail
+                      (default 30m)
+  -version VERSION    update to a specific version, or to the latest
+                      release (latest), patch release (patch), or
+                      upgrade, as when no version is given (upgrade)
+  -from-file LIST     update each module listed in LIST (- for stdin), one
+                      PATH or PATH@VERSION per line
+  -group-pr           update the modules in the -from-file list in one PR
+  -constraint RANGE   update to the newest version matching RANGE, such as
+                      ">=1.4 <1.7" or "^1.2" (exit status 11 if none do)
+  -pre                include pre-releases in -constraint matches
+  -min-age DURATION   only update to versions published at least DURATION
+                      ago, such as 72h or 7d
+  -skip-version [PATH@]VERSION[: REASON]
+                      never update to VERSION (repeatable)
+  -skip-file FILE     never update to the versions listed in FILE
+  -commit SHA         update to a specific commit
+  -toolchain NAME     set the toolchain directive when updating go
+  -recursive          update every module in the repository requiring PATH
+  -ignore-replace     update a module even if go.mod replaces it
+  -bump-replace       also update a version-pinned replacement
+  -allow-retracted    allow updating to a retracted version
+  -fail-if-current    fail if there is nothing to update (exit status 10)
+  -show-diff[=full]   show the go.mod (and with full, go.sum) diff, and ask
+                      to proceed when run in a terminal
+  -yes                do not ask to proceed with -show-diff
+  -ignore-go-version  allow updating to a version needing a newer Go
+  -no-verify-modules  skip running go mod verify after the update
+  -no-rollback        leave the update branch behind if committing fails
+  -supersede          close older open PRs for the same module
+  -delete-branch-on-merge
+                      have GitHub delete update branches once merged
+  -group PREFIX       update every requirement starting with PREFIX
+  -release-url        build release links even for private modules
+  -vulncheck          report the update's effect on known vulnerabilities
+  -vulncheck-fail-on-new
+                      abort if the update introduces vulnerabilities
+  -fail-on-downgrade  abort if the update downgrades other requirements
+  -push-option VALUE  pass -o VALUE to git push (repeatable)
+  -remote-host-alias ALIAS=HOST
+                      treat remote host ALIAS as HOST (repeatable)
+  -pr-template FILE   template file for the PR body
+  -pr-title-template STRING
+                      template for the PR title (default: commit subject)
+  -ca-file PATH       also trust the CA certificates in PATH
+  -insecure-skip-tls-verify
+                      do not verify TLS certificates (insecure)
+  -author IDENT       commit as author IDENT ("Name <email>")
+  -committer IDENT    commit as committer IDENT ("Name <email>")
+  -sign               sign the commit
+  -sign-key KEY       sign the commit with KEY
+  -add PATH           also commit changes to PATH (repeatable)
+  -worktree           update in a temporary worktree, not the checkout
+  -lock-timeout DURATION
+                      wait up to DURATION for another run in the repository
+                      to finish, rather than exiting (exit status 12)
+  -ignore-untracked   allow untracked files, keeping them out of the commit
+  -delete-local-branch
+                      delete the local update branch once pushed (CI default)
+  -keep-local-branch  keep the update branch locally once pushed
+  -base BRANCH        base branch for the PR (default: origin's HEAD)
+  -from-ref REF       make the update on top of REF, fetching it if needed,
+                      with its branch as the PR base
+  -pull               fast-forward the current branch from its upstream first
+  -fresh-base         make the update on top of the base branch on origin,
+                      after fetching it (default when pushing in CI)
+  -no-fresh-base      make the update on top of HEAD, even in CI
+  -remote NAME        open the PR against, and push to, remote NAME instead
+                      of origin (default: upstream, or the only remote, if
+                      there is no origin)
+  -fork-remote NAME   push to remote NAME, and open the PR from it
+  -fetch-depth N      in shallow clones, fetch up to N commits of the base
+  -branch-prefix STR  prefix for the update branch (default "update-")
+  -commit-prefix STR  prefix for the commit subject (default "modules")
+  -pre-cmd COMMAND    run COMMAND before go get (repeatable)
+  -post-cmd COMMAND   run COMMAND after the update (repeatable)
+  -verify-cmd CMD     only commit if CMD passes (repeatable)
+  -hook-pre-commit CMD
+                      run CMD just before committing (repeatable)
+  -hook-post-push CMD run CMD after pushing (repeatable)
+  -hook-post-pr CMD   run CMD once the PR exists (repeatable)
+
+template functions, for commands and the PR template:
+  trimPrefix PREFIX S   S without the leading PREFIX
+  trimSuffix SUFFIX S   S without the trailing SUFFIX
+  replace OLD NEW S     S with every OLD replaced by NEW
+  upper S, lower S      S in upper or lower case
+  shortHash VERSION     the commit hash of a pseudo-version, or ""
+
+  for example: depbump PATH -- echo '{{.Path | replace "/" "-"}}'`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprintln(os.Stderr, help)
+		os.Exit(depbump.ExitError)
+	}
+
+	opts := depbump.Options{
+		Push:              true,
+		PR:                true,
+		CAFile:            os.Getenv("SSL_CERT_FILE"),
+		DeleteLocalBranch: inCI(),
+		HostAliases:       make(map[string]string),
+		LogFormat:         "text",
+		Color:             "auto",
+	}
+
+	var freshBaseSet bool
+
+	// Flags may appear anywhere before the post-command. The
+	// post-command starts either after a literal "--" (in which case
+	// it is taken verbatim), or at the first non-flag argument after
+	// PATH, for compatibility with the original positional form.
+	for i := 1; i < len(os.Args); i++ {
+		arg := os.Args[i]
+
+		// value returns the argument for a flag that takes one.
+		value := func() string {
+			if i+1 >= len(os.Args) {
+				// Not enough arguments
+				usagef("not enough arguments")
+			}
+
+			i++
+			return os.Args[i]
+		}
+
+		if arg == "--" {
+			opts.Command = append(opts.Command, os.Args[i+1:]...)
+			break
+		}
+
+		if strings.HasPrefix(arg, "-") {
+			switch arg {
+			case "-nopush":
+				opts.Push = false
+
+			case "-nopr":
+				opts.PR = false
+
+			case "-token":
+				v := value()
+				if !regexp.MustCompile(`^[a-zA-Z0-9_]+$`).Match([]byte(v)) {
+					// Invalid environment variable
+					usagef("invalid environment variable name %q", v)
+				}
+				opts.TokenName = v
+
+			case "-github-app-id":
+				opts.AppID = value()
+
+			case "-github-app-installation-id":
+				opts.AppInstallationID = value()
+
+			case "-github-app-key-file":
+				opts.AppKeyFile = value()
+
+			case "-verbose":
+				opts.Verbose = true
+			case "-log-format":
+				opts.LogFormat = value()
+			case "-color":
+				opts.Color = value()
+			case "-no-actions-output":
+				opts.NoActionsOutput = true
+			case "-summary-file":
+				opts.SummaryFile = value()
+			case "-webhook-url":
+				opts.WebhookURL = value()
+			case "-webhook-secret":
+				opts.WebhookSecret = value()
+			case "-slack-webhook":
+				opts.SlackWebhook = value()
+
+			case "-github-app-push":
+				opts.AppPush = true
+
+			case "-version":
+				opts.Version = value()
+
+			case "-from-file":
+				opts.FromFile = value()
+
+			case "-group-pr":
+				opts.GroupPR = true
+
+			case "-pr-template":
+				opts.PRTemplateFile = value()
+
+			case "-pr-title-template":
+				opts.PRTitleTemplate = value()
+
+			case "-constraint":
+				opts.Constraint = value()
+
+			case "-pre":
+				opts.Pre = true
+
+			case "-lock-timeout":
+				d := value()
+				var err error
+				opts.LockTimeout, err = time.ParseDuration(d)
+				if err != nil || opts.LockTimeout < 0 {
+					usagef("invalid -lock-timeout duration %q", d)
+				}
+
+			case "-min-age":
+				d := value()
+				var err error
+				opts.MinAge, err = parseAge(d)
+				if err != nil || opts.MinAge <= 0 {
+					usagef("invalid -min-age duration %q", d)
+				}
+
+			case "-skip-version":
+				opts.Skips = append(opts.Skips, value())
+
+			case "-skip-file":
+				opts.SkipFile = value()
+
+			case "-commit":
+				opts.Commit = value()
+
+			case "-toolchain":
+				opts.Toolchain = value()
+
+			case "-recursive":
+				opts.Recursive = true
+
+			case "-ignore-replace":
+				opts.IgnoreReplace = true
+
+			case "-allow-retracted":
+				opts.AllowRetracted = true
+			case "-fail-if-current":
+				opts.FailIfCurrent = true
+			case "-show-diff":
+				opts.ShowDiff = true
+			case "-show-diff=full":
+				opts.FullDiff = true
+			case "-yes":
+				opts.Yes = true
+
+			case "-ignore-go-version":
+				opts.IgnoreGoVersion = true
+
+			case "-no-verify-modules":
+				opts.NoVerifyModules = true
+
+			case "-vulncheck":
+				opts.Vulncheck = true
+
+			case "-release-url":
+				opts.ReleaseURL = true
+
+			case "-remote-host-alias":
+				v := value()
+				parts := strings.SplitN(v, "=", 2)
+				if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+					usagef("invalid remote host alias %q, expected ALIAS=HOST", v)
+				}
+
+				opts.HostAliases[strings.ToLower(parts[0])] = strings.ToLower(parts[1])
+
+			case "-author":
+				opts.Author = value()
+
+			case "-committer":
+				opts.Committer = value()
+
+			case "-sign":
+				opts.Sign = true
+
+			case "-sign-key":
+				opts.SignKey = value()
+
+			case "-add":
+				opts.Add = append(opts.Add, value())
+
+			case "-worktree":
+				opts.Worktree = true
+
+			case "-delete-local-branch":
+				opts.DeleteLocalBranch = true
+
+			case "-keep-local-branch":
+				opts.DeleteLocalBranch = false
+
+			case "-ignore-untracked":
+				opts.IgnoreUntracked = true
+
+			case "-ca-file":
+				opts.CAFile = value()
+
+			case "-insecure-skip-tls-verify":
+				opts.InsecureSkipTLSVerify = true
+
+			case "-base":
+				opts.Base = value()
+
+			case "-from-ref":
+				opts.FromRef = value()
+
+			case "-pull":
+				opts.Pull = true
+
+			case "-fresh-base":
+				opts.FreshBase, freshBaseSet = true, true
+
+			case "-no-fresh-base":
+				opts.FreshBase, freshBaseSet = false, true
+
+			case "-remote":
+				opts.Remote = value()
+
+			case "-fork-remote":
+				opts.ForkRemote = value()
+
+			case "-fetch-depth":
+				n, err := strconv.Atoi(value())
+				if err != nil || n < 1 {
+					usagef("-fetch-depth must be a positive number")
+				}
+
+				opts.FetchDepth = n
+
+			case "-no-rollback":
+				opts.NoRollback = true
+
+			case "-supersede":
+				opts.Supersede = true
+
+			case "-delete-branch-on-merge":
+				opts.DeleteBranchOnMerge = true
+
+			case "-group":
+				opts.Group = strings.TrimSuffix(value(), "/")
+				if opts.Group == "" {
+					usagef("-group prefix is empty")
+				}
+
+			case "-vulncheck-fail-on-new":
+				opts.VulncheckFailOnNew = true
+
+			case "-fail-on-downgrade":
+				opts.FailOnDowngrade = true
+
+			case "-bump-replace":
+				opts.BumpReplace = true
+
+			case "-push-option":
+				opts.PushOptions = append(opts.PushOptions, value())
+
+			case "-branch-prefix":
+				v := value()
+				opts.BranchPrefix = &v
+
+			case "-commit-prefix":
+				v := value()
+				opts.CommitPrefix = &v
+
+			case "-pre-cmd":
+				c, err := splitCommand(value())
+				if err != nil {
+					usagef("invalid pre-update command: %s", err)
+				}
+
+				opts.PreCmds = append(opts.PreCmds, c)
+
+			case "-post-cmd":
+				c, err := splitCommand(value())
+				if err != nil {
+					usagef("invalid post-update command: %s", err)
+				}
+
+				opts.PostCmds = append(opts.PostCmds, c)
+
+			case "-verify-cmd":
+				c, err := splitCommand(value())
+				if err != nil {
+					usagef("invalid verify command: %s", err)
+				}
+
+				opts.VerifyCmds = append(opts.VerifyCmds, c)
+
+			case "-hook-pre-commit", "-hook-post-push", "-hook-post-pr":
+				c, err := splitCommand(value())
+				if err != nil {
+					usagef("invalid %s command: %s", strings.TrimPrefix(arg, "-"), err)
+				}
+
+				switch arg {
+				case "-hook-pre-commit":
+					opts.PreCommitHooks = append(opts.PreCommitHooks, c)
+
+				case "-hook-post-push":
+					opts.PostPushHooks = append(opts.PostPushHooks, c)
+
+				case "-hook-post-pr":
+					opts.PostPRHooks = append(opts.PostPRHooks, c)
+				}
+
+			case "-wait-for-checks":
+				opts.WaitForChecks = depbump.DefaultCheckTimeout
+
+			default:
+				if f, ok := strings.CutPrefix(arg, "-log-format="); ok {
+					opts.LogFormat = f
+					continue
+				}
+
+				if c, ok := strings.CutPrefix(arg, "-color="); ok {
+					opts.Color = c
+					continue
+				}
+
+				// -wait-for-checks takes an optional duration.
+				if d, ok := strings.CutPrefix(arg, "-wait-for-checks="); ok {
+					var err error
+					opts.WaitForChecks, err = time.ParseDuration(d)
+					if err != nil || opts.WaitForChecks <= 0 {
+						usagef("invalid -wait-for-checks duration %q", d)
+					}
+
+					continue
+				}
+
+				usagef("invalid argument %q", arg)
+			}
+
+			continue
+		}
+
+		// There is no PATH when updating a group, so the first
+		// non-flag argument starts the post-command.
+		if opts.Path == "" && opts.Group == "" {
+			opts.Path = arg
+			continue
+		}
+
+		// Positional post-command: everything from here on belongs to
+		// it, including arguments that look like flags.
+		opts.Command = append(opts.Command, os.Args[i:]...)
+		break
+	}
+
+	// With -fresh-base, which is the default when pushing from CI, the
+	// update is made on top of the base branch as it is on the remote,
+	// as the local branch may well be behind it.
+	if !freshBaseSet {
+		opts.FreshBase = inCI() && opts.Push
+	}
+
+	tokenName := opts.TokenName
+	if tokenName == "" {
+		tokenName = depbump.DefaultTokenName
+	}
+
+	opts.Token = os.Getenv(tokenName)
+	opts.AzureToken = os.Getenv(depbump.AzureTokenName)
+
+	res, err := depbump.Run(interruptContext(), opts)
+	var runErr *depbump.Error
+	if errors.As(err, &runErr) && runErr.Usage {
+		fmt.Fprintln(os.Stderr, help)
+	}
+
+	os.Exit(depbump.ExitCode(res, err))
+}
+
+// interruptContext returns a context that is canceled on SIGINT or
+// SIGTERM, with the signal as its cause, so that the run stops and puts
+// the repository back the way it was. Further signals are ignored, so
+// that the repository isn't left half restored.
+func interruptContext() context.Context {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-sigs
+		cancel(errors.New(sig.String()))
+		for range sigs {
+		}
+	}()
+
+	return ctx
+}
+
+// usagef logs an error in the command line, prints the usage, and
+// exits. arguments are the same as fmt.Printf.
+func usagef(format string, a ...interface{}) {
+	slog.New(slog.NewTextHandler(os.Stderr, nil)).Error(fmt.Sprintf(format, a...))
+	fmt.Fprintln(os.Stderr, help)
+	os.Exit(depbump.ExitError)
+}
+
+// splitCommand splits a command string into arguments using
+// shell-style quoting rules. Single quotes preserve everything up to
+// the closing quote, double quotes allow backslash escapes, and
+// unquoted whitespace separates words.
+func splitCommand(s string) ([]string, error) {
+	var args []string
+	var cur strings.Builder
+	var inWord, escaped bool
+	var quote rune
+
+	for _, r := range s {
+		switch {
+		case escaped:
+			cur.WriteRune(r)
+			escaped = false
+
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				cur.WriteRune(r)
+			}
+
+		case quote == '"':
+			switch r {
+			case '"':
+				quote = 0
+			case '\\':
+				escaped = true
+			default:
+				cur.WriteRune(r)
+			}
+
+		case r == '\'' || r == '"':
+			quote = r
+			inWord = true
+
+		case r == '\\':
+			escaped = true
+			inWord = true
+
+		case r == ' ' || r == '\t' || r == '\n':
+			if inWord {
+				args = append(args, cur.String())
+				cur.Reset()
+				inWord = false
+			}
+
+		default:
+			cur.WriteRune(r)
+			inWord = true
+		}
+	}
+
+	if escaped || quote != 0 {
+		return nil, fmt.Errorf("unterminated quote or escape in command %q", s)
+	}
+
+	if inWord {
+		args = append(args, cur.String())
+	}
+
+	if len(args) < 1 {
+		return nil, fmt.Errorf("command %q is empty", s)
+	}
+
+	return args, nil
+}
+
+// parseAge parses a -min-age duration, which is either a Go duration,
+// or a number of days, as in "7d".
+func parseAge(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid number of days %q", days)
+		}
+
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+
+	return time.ParseDuration(s)
+}
+
+// inCI returns true if depbump appears to be running in a CI
+// environment. Most CI systems set CI, and GitHub Actions also sets
+// GITHUB_ACTIONS.
+func inCI() bool {
+	return os.Getenv("CI") == "true" || os.Getenv("GITHUB_ACTIONS") == "true"
+}
@@ -0,0 +1,92 @@
+// Package depbump updates a dependency of a Go module, commits the
+// update on its own branch, and optionally pushes it and opens a pull
+// request for it. It is what the depbump command runs, for tools that
+// drive depbump themselves, such as an updater service running it for
+// many repositories:
+//
+//	var logs bytes.Buffer
+//	res, err := depbump.Run(ctx, depbump.Options{
+//		Dir:    dir,
+//		Path:   "golang.org/x/text",
+//		Push:   true,
+//		PR:     true,
+//		Token:  token,
+//		Stdout: &logs,
+//		Stderr: &logs,
+//	})
+//
+// Run never exits the process: errors are returned, and canceling ctx
+// interrupts the run, putting the repository back the way it was.
+package depbump
+
+import (
+	"context"
+
+	"github.com/vancluever/depbump/internal/bump"
+)
+
+// Exit statuses, as returned by ExitCode, so that the outcome of a run
+// can be told apart without reading its output. See the README for what
+// each means.
+const (
+	ExitUpdated        = bump.ExitUpdated
+	ExitError          = bump.ExitError
+	ExitAlreadyCurrent = bump.ExitAlreadyCurrent
+	ExitExists         = bump.ExitExists
+	ExitPrecondition   = bump.ExitPrecondition
+	ExitUpgradeFailed  = bump.ExitUpgradeFailed
+	ExitGitFailed      = bump.ExitGitFailed
+	ExitPRFailed       = bump.ExitPRFailed
+	ExitVerifyFailed   = bump.ExitVerifyFailed
+	ExitChecksFailed   = bump.ExitChecksFailed
+	ExitCurrentFailed  = bump.ExitCurrentFailed
+	ExitNoMatch        = bump.ExitNoMatch
+	ExitLocked         = bump.ExitLocked
+	ExitInterrupted    = bump.ExitInterrupted
+)
+
+// DefaultBranchPrefix is the prefix of update branches, unless
+// Options.BranchPrefix is set.
+const DefaultBranchPrefix = bump.DefaultBranchPrefix
+
+// DefaultTokenName is the environment variable the command reads the
+// GitHub token from, unless another is given with -token.
+const DefaultTokenName = bump.DefaultTokenName
+
+// AzureTokenName is the environment variable the command reads the
+// Azure DevOps token from.
+const AzureTokenName = bump.AzureTokenName
+
+// DefaultCheckTimeout is how long -wait-for-checks waits, if no
+// duration is given.
+const DefaultCheckTimeout = bump.DefaultCheckTimeout
+
+// Options are the options of a run, one for each of the command's
+// flags, along with the directory to run in and the streams to use in
+// place of the process's own. Nothing is read from the environment
+// that Options has a field for, such as the tokens.
+type Options = bump.Options
+
+// Result is the outcome of a run, as far as it got: the module and its
+// old and new versions, the update branch and commit, and the PR.
+type Result = bump.Result
+
+// Error is the error that ended a run, with the exit status for it in
+// Code. Usage is set for mistakes in the options.
+type Error = bump.Error
+
+// Run runs depbump with opts. The repository is put back the way it
+// was if the run fails, or ctx is canceled, in which case the error
+// has the code ExitInterrupted. Runs that end without an update, such
+// as when the module is already current, mostly end with a nil error,
+// with Result.Status saying why; ExitCode tells these apart from
+// updates.
+func Run(ctx context.Context, opts Options) (Result, error) {
+	return bump.Run(ctx, opts)
+}
+
+// ExitCode returns the exit status the command uses for the result of
+// Run.
+func ExitCode(res Result, err error) int {
+	return bump.ExitCode(res, err)
+}
@@ -0,0 +1,55 @@
+package depbump
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestRunNotARepository(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	res, err := Run(context.Background(), Options{
+		Dir:    t.TempDir(),
+		Path:   "rsc.io/quote",
+		Stdout: &stdout,
+		Stderr: &stderr,
+	})
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+
+	if code := ExitCode(res, err); code != ExitError {
+		t.Fatalf("expected exit status %d, got %d", ExitError, code)
+	}
+
+	if res.Status != "failed" {
+		t.Fatalf("expected status failed, got %q", res.Status)
+	}
+
+	// Both git's output and the run's own logs go to Stderr.
+	for _, want := range []string{"not a git repository", `level=ERROR msg="exit status 128"`} {
+		if !strings.Contains(stderr.String(), want) {
+			t.Fatalf("expected stderr to contain %q, got %q", want, stderr.String())
+		}
+	}
+
+	if stdout.Len() != 0 {
+		t.Fatalf("expected no output, got %q", stdout.String())
+	}
+}
+
+func TestRunCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var stderr bytes.Buffer
+	res, err := Run(ctx, Options{Dir: t.TempDir(), Path: "rsc.io/quote", Stderr: &stderr})
+	if code := ExitCode(res, err); code != ExitInterrupted {
+		t.Fatalf("expected exit status %d, got %d (%v)", ExitInterrupted, code, err)
+	}
+
+	if res.Status != "interrupted" {
+		t.Fatalf("expected status interrupted, got %q", res.Status)
+	}
+}
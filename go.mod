@@ -1,3 +1,5 @@
 module github.com/vancluever/depbump
 
-go 1.12
+go 1.22.0
+
+require golang.org/x/mod v0.22.0
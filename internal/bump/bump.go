@@ -0,0 +1,599 @@
+// Package bump updates a dependency of a Go module, commits the
+// update on its own branch, and optionally pushes it and opens a pull
+// request for it. It is everything depbump does, other than parsing its
+// command line.
+package bump
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/vancluever/depbump/internal/forge"
+	"github.com/vancluever/depbump/internal/gitrepo"
+)
+
+// Exit statuses, so that the outcome of a run can be told apart
+// without reading its output.
+const (
+	// ExitUpdated is used when the update was made, and the PR created
+	// if one was requested.
+	ExitUpdated = 0
+
+	// ExitError is used for any other error.
+	ExitError = 1
+
+	// ExitAlreadyCurrent is used when there is nothing to update.
+	ExitAlreadyCurrent = 2
+
+	// ExitExists is used when the update branch, or a PR for the
+	// update, already exists.
+	ExitExists = 3
+
+	// ExitPrecondition is used when the repository or module can't be
+	// updated as it is, for example because there are uncommitted
+	// changes, or the module isn't required.
+	ExitPrecondition = 4
+
+	// ExitUpgradeFailed is used when updating the module, or the
+	// commands run along with it, fail.
+	ExitUpgradeFailed = 5
+
+	// ExitGitFailed is used when creating, committing, or pushing the
+	// update branch fails.
+	ExitGitFailed = 6
+
+	// ExitPRFailed is used when the PR can't be created.
+	ExitPRFailed = 7
+
+	// ExitVerifyFailed is used when a verify command fails, so that an
+	// incompatible update can be told apart from other errors.
+	ExitVerifyFailed = 8
+
+	// ExitChecksFailed is used when the checks on the PR do not pass
+	// with -wait-for-checks.
+	ExitChecksFailed = 9
+
+	// ExitCurrentFailed is used instead of ExitAlreadyCurrent with
+	// -fail-if-current.
+	ExitCurrentFailed = 10
+
+	// ExitNoMatch is used when no version of the module satisfies
+	// -constraint.
+	ExitNoMatch = 11
+
+	// ExitLocked is used when another run holds the lock on the
+	// repository.
+	ExitLocked = 12
+
+	// ExitInterrupted is used when the run is interrupted, by its
+	// context being canceled, matching the status a shell reports for
+	// SIGINT.
+	ExitInterrupted = 130
+)
+
+// DefaultBranchPrefix is the prefix of update branches, unless
+// Options.BranchPrefix is set.
+const DefaultBranchPrefix = "update-"
+
+// DefaultTokenName is the environment variable that the GitHub token is
+// read from, unless another is given with -token.
+const DefaultTokenName = "GITHUB_TOKEN"
+
+// DefaultCheckTimeout is how long -wait-for-checks waits, if no
+// duration is given.
+const DefaultCheckTimeout = 30 * time.Minute
+
+// Options are the options of a run, one for each of depbump's flags.
+// The zero value of each is the default, other than for the flags
+// whose default the command line works out, such as FreshBase, and
+// the ones that turn something off, such as Push, which are given the
+// other way around. Relative paths are relative to Dir.
+type Options struct {
+	// Path is the module to update, or one of the special paths: "go"
+	// for the go and toolchain directives, "tidy" to commit module
+	// metadata drift, and "cleanup" to delete merged update branches.
+	// It is empty with Group or FromFile.
+	Path string
+
+	// Command is the post-update command given after Path, which runs
+	// before PostCmds. When updating go, a lone Go version here is
+	// taken as the version to update to.
+	Command []string
+
+	// Version and Commit are the version, module query, or commit to
+	// update to, rather than the latest release.
+	Version string
+	Commit  string
+
+	// Group updates every requirement starting with the prefix, and
+	// FromFile every module listed in the file (or stdin, if it is
+	// "-"), one PATH or PATH@VERSION per line, each in its own run
+	// unless GroupPR is set.
+	Group    string
+	FromFile string
+	GroupPR  bool
+
+	// Constraint is the version range to update within, with Pre
+	// including pre-releases in it. MinAge passes over versions
+	// published more recently than that.
+	Constraint string
+	Pre        bool
+	MinAge     time.Duration
+
+	// Skips are the versions never updated to, each
+	// [PATH@]VERSION[: REASON], and SkipFile a file listing more.
+	Skips    []string
+	SkipFile string
+
+	// Toolchain is the toolchain directive to set when updating go.
+	Toolchain string
+
+	Recursive           bool
+	IgnoreReplace       bool
+	BumpReplace         bool
+	AllowRetracted      bool
+	FailIfCurrent       bool
+	ShowDiff            bool
+	FullDiff            bool
+	Yes                 bool
+	IgnoreGoVersion     bool
+	NoVerifyModules     bool
+	Vulncheck           bool
+	VulncheckFailOnNew  bool
+	FailOnDowngrade     bool
+	ReleaseURL          bool
+	Supersede           bool
+	DeleteBranchOnMerge bool
+	NoRollback          bool
+
+	// Push pushes the update branch, and PR opens a pull request for
+	// it, if Push is set too.
+	Push bool
+	PR   bool
+
+	// PushOptions are passed to git push with -o.
+	PushOptions []string
+
+	// Remote is the remote to open the PR against, and push to, if not
+	// origin, and ForkRemote the remote to push to instead. HostAliases
+	// maps the hosts of remote URLs to the hosts they stand for.
+	Remote      string
+	ForkRemote  string
+	HostAliases map[string]string
+
+	// Base is the base branch for the PR, rather than the remote's
+	// HEAD. FromRef is the ref to make the update on top of.
+	Base    string
+	FromRef string
+
+	// Pull fast-forwards the current branch first, and FreshBase makes
+	// the update on top of the base branch on the remote. FetchDepth
+	// is how many commits of the base branch to fetch in a shallow
+	// clone.
+	Pull       bool
+	FreshBase  bool
+	FetchDepth int
+
+	Worktree          bool
+	IgnoreUntracked   bool
+	DeleteLocalBranch bool
+
+	// Add are paths to commit along with the update.
+	Add []string
+
+	// Sign signs the commit, with SignKey if it is set. Author and
+	// Committer are identities, "Name <email>", to commit as.
+	Sign      bool
+	SignKey   string
+	Author    string
+	Committer string
+
+	// BranchPrefix is the prefix of the update branch, and
+	// CommitPrefix the prefix of the commit subject. Either is the
+	// default if nil.
+	BranchPrefix *string
+	CommitPrefix *string
+
+	// PRTemplateFile is the template file for the PR body, and
+	// PRTitleTemplate the template for its title.
+	PRTemplateFile  string
+	PRTitleTemplate string
+
+	// The commands to run during the update, each split into its
+	// arguments.
+	PreCmds        [][]string
+	PostCmds       [][]string
+	VerifyCmds     [][]string
+	PreCommitHooks [][]string
+	PostPushHooks  [][]string
+	PostPRHooks    [][]string
+
+	// LockTimeout is how long to wait for another run in the
+	// repository to finish, and WaitForChecks how long to wait for the
+	// PR's checks, if at all.
+	LockTimeout   time.Duration
+	WaitForChecks time.Duration
+
+	// Token is the GitHub token, and TokenName the environment
+	// variable it came from, for messages. AzureToken is the token for
+	// Azure DevOps.
+	Token      string
+	TokenName  string
+	AzureToken string
+
+	// AppID, AppInstallationID, and AppKeyFile authenticate as a
+	// GitHub App instead of with Token, and AppPush pushes as it too.
+	AppID             string
+	AppInstallationID string
+	AppKeyFile        string
+	AppPush           bool
+
+	// CAFile is more CA certificates to trust, and
+	// InsecureSkipTLSVerify turns off certificate verification.
+	CAFile                string
+	InsecureSkipTLSVerify bool
+
+	// NoActionsOutput turns off the GitHub Actions outputs. The summary
+	// of the run is written to SummaryFile, and posted to WebhookURL,
+	// signed with WebhookSecret, if they are set. SlackWebhook is
+	// notified of created PRs and failed runs.
+	NoActionsOutput bool
+	SummaryFile     string
+	WebhookURL      string
+	WebhookSecret   string
+	SlackWebhook    string
+
+	// Verbose logs debug messages, in LogFormat, text (the default) or
+	// json, colored as Color says: always, never, or auto (the
+	// default).
+	Verbose   bool
+	LogFormat string
+	Color     string
+
+	// Dir is the directory to run in, the current one if empty.
+	Dir string
+
+	// Stdout and Stderr are where the run's output and logs, and the
+	// output of the commands it runs, are written, and Stdin is what
+	// those commands, and a -from-file list of "-", read from. Each is
+	// the process's own if nil.
+	Stdout io.Writer
+	Stderr io.Writer
+	Stdin  io.Reader
+
+	// runner runs commands, and gitHubURL is the root of the GitHub
+	// API, in place of the real ones.
+	runner    gitrepo.Runner
+	gitHubURL string
+}
+
+// Result is the outcome of a run, as far as it got.
+type Result struct {
+	Module     string
+	OldVersion string
+	NewVersion string
+	Branch     string
+	CommitSHA  string
+	RemoteRef  string
+	PRURL      string
+
+	// Status is the outcome, such as "updated", "already-current", or
+	// "failed".
+	Status string
+}
+
+// Error is the error that ended a run, with the exit status for it.
+// Usage is set for mistakes in the options, for which the usage is
+// worth showing.
+type Error struct {
+	Code  int
+	Usage bool
+	Err   error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// fail returns err, to exit with code.
+func fail(code int, err error) error {
+	return &Error{Code: code, Err: err}
+}
+
+// failf returns an error formatted in the same way as fmt.Errorf, to
+// exit with code.
+func failf(code int, format string, a ...interface{}) error {
+	return &Error{Code: code, Err: fmt.Errorf(format, a...)}
+}
+
+// usagef returns an error in the options, formatted in the same way as
+// fmt.Errorf.
+func usagef(format string, a ...interface{}) error {
+	return &Error{Code: ExitError, Usage: true, Err: fmt.Errorf(format, a...)}
+}
+
+// errInterrupted is returned once the run has been interrupted, by
+// anything that would carry on with it.
+var errInterrupted = errors.New("interrupted")
+
+// ExitCode returns the exit status for the result of Run: the code of
+// the error, if there is one, or the status for how far the run got.
+func ExitCode(res Result, err error) int {
+	if err != nil {
+		var e *Error
+		if errors.As(err, &e) {
+			return e.Code
+		}
+
+		return ExitError
+	}
+
+	switch res.Status {
+	case "already-current", "no-changes":
+		return ExitAlreadyCurrent
+
+	case "pr-exists", "branch-exists":
+		return ExitExists
+
+	case "declined":
+		return ExitError
+	}
+
+	return ExitUpdated
+}
+
+// run is the state of a single run.
+type run struct {
+	opts Options
+
+	// stdout, stderr, and stdin are what the run, and the commands it
+	// runs, are connected to.
+	stdout, stderr io.Writer
+	stdin          io.Reader
+
+	// logger is used for everything the run reports itself, as
+	// opposed to the output of the commands it runs.
+	logger *slog.Logger
+
+	// runner runs the commands, in dir. baseDir is the directory the
+	// run started in, which dir differs from in a worktree.
+	runner  gitrepo.Runner
+	dir     string
+	baseDir string
+
+	// client is used for every HTTP request the run makes.
+	client *http.Client
+
+	// remote is the remote pull requests are opened against, and
+	// update branches pushed to: origin, unless -remote is given or
+	// selectRemote picks another. runRepo is the repository being
+	// updated, as HOST/PATH, once its remote is known.
+	remote  string
+	runRepo string
+
+	// outputs holds the values of the outputs set so far, which also
+	// make up the summary of the run. phases are the phases of the run
+	// started so far, in order, and start when it started. runError is
+	// the message of the error that ended it, if any.
+	outputs  map[string]string
+	phases   []*phase
+	start    time.Time
+	runError string
+
+	// keepUntracked lists the untracked files, relative to the top of
+	// the repository, that were present before the update, when they
+	// are allowed with -ignore-untracked. They are never staged or
+	// cleaned.
+	keepUntracked []string
+
+	// rollback, if set, is run when the run fails, to put the
+	// repository back the way it was before the run. restoreCheckout,
+	// if set, puts the checkout back the way it was before the run,
+	// when it is interrupted: on the original branch and commit,
+	// without the changes made by the update. cleanup, if set, is run
+	// however the run ends, to remove anything it set up outside the
+	// repository's own state.
+	rollback        func()
+	restoreCheckout func()
+	cleanup         func()
+
+	// restoring is set once the run is ending, so that the commands
+	// putting the repository back, or cleaning up, still run after it
+	// is interrupted.
+	restoring bool
+
+	// app is the GitHub App the run authenticates as, if any.
+	app *forge.App
+}
+
+// Run runs depbump with opts. The repository is put back the way it
+// was if the run fails, or ctx is canceled, in which case the error
+// has the code ExitInterrupted. Runs that end without an update, such
+// as when the module is already current, mostly end with a nil error,
+// with Status saying why; the rest, such as those where the newer
+// versions are all skipped, end with an *Error whose Code says why.
+// ExitCode gives the exit status for either.
+func Run(ctx context.Context, opts Options) (Result, error) {
+	r := newRun(opts)
+	r.stdout, r.stderr, r.stdin = os.Stdout, os.Stderr, os.Stdin
+	if opts.Stdout != nil {
+		r.stdout = opts.Stdout
+	}
+
+	if opts.Stderr != nil {
+		r.stderr = opts.Stderr
+	}
+
+	if opts.Stdin != nil {
+		r.stdin = opts.Stdin
+	}
+
+	r.logger = slog.New(slog.NewTextHandler(r.stderr, nil))
+	r.client = &http.Client{Transport: http.DefaultTransport}
+	if r.runner == nil {
+		r.runner = gitrepo.Exec{}
+	}
+
+	r.dir = opts.Dir
+	if r.dir == "" {
+		var err error
+		if r.dir, err = os.Getwd(); err != nil {
+			return r.finish(ctx, err)
+		}
+	} else if abs, err := filepath.Abs(r.dir); err == nil {
+		r.dir = abs
+	}
+
+	r.baseDir = r.dir
+	if err := r.setupLogger(opts.LogFormat, opts.Color, opts.Verbose); err != nil {
+		return r.finish(ctx, usagef("%s", err))
+	}
+
+	stop := context.AfterFunc(ctx, func() {
+		r.logger.Warn("interrupted, putting the repository back before exiting", "cause", context.Cause(ctx))
+	})
+	defer stop()
+
+	return r.execute(ctx)
+}
+
+// newRun returns the state for a run with opts, before anything about
+// where it runs is known.
+func newRun(opts Options) *run {
+	return &run{
+		opts:    opts,
+		runner:  opts.runner,
+		remote:  "origin",
+		outputs: make(map[string]string),
+		start:   time.Now(),
+	}
+}
+
+// execute runs the update, or the updates for the entries of the
+// module list, and finishes the run.
+func (r *run) execute(ctx context.Context) (Result, error) {
+	var err error
+	if ctx.Err() != nil {
+		err = errInterrupted
+	} else if r.opts.FromFile != "" && !r.opts.GroupPR {
+		err = r.updateList(ctx)
+	} else {
+		err = r.update(ctx)
+	}
+
+	return r.finish(ctx, err)
+}
+
+// finish ends the run with err: it puts the repository back if the run
+// failed or was interrupted, logs err, runs cleanup, and writes the
+// outputs and the summary of the run, posts the completion webhook
+// and, for failures, the Slack notification.
+func (r *run) finish(ctx context.Context, err error) (Result, error) {
+	r.restoring = true
+	interrupted := ctx.Err() != nil
+	if interrupted {
+		if err == nil || errors.Is(err, errInterrupted) {
+			err = errInterrupted
+		}
+
+		err = fail(ExitInterrupted, err)
+	}
+
+	if err != nil {
+		r.runRollback()
+		r.logError(err.Error())
+	}
+
+	if interrupted {
+		if rc := r.restoreCheckout; rc != nil {
+			r.restoreCheckout = nil
+			rc()
+		}
+
+		r.setOutput("status", "interrupted")
+	}
+
+	if c := r.cleanup; c != nil {
+		r.cleanup = nil
+		c()
+	}
+
+	code := ExitCode(r.result(), err)
+	if _, ok := r.outputs["status"]; !ok {
+		r.outputs["status"] = "failed"
+		if code == ExitUpdated {
+			r.outputs["status"] = "updated"
+		}
+	}
+
+	ctx = context.WithoutCancel(ctx)
+	r.writeActionsOutputs()
+	r.writeSummaryFile()
+	r.postWebhook(ctx)
+	if code != ExitUpdated && code != ExitAlreadyCurrent && code != ExitExists {
+		r.notifySlack(ctx, r.slackFailureMessage())
+	}
+
+	return r.result(), err
+}
+
+// result returns the result of the run so far, from the outputs set.
+func (r *run) result() Result {
+	return Result{
+		Module:     r.outputs["module"],
+		OldVersion: r.outputs["old-version"],
+		NewVersion: r.outputs["new-version"],
+		Branch:     r.outputs["branch"],
+		CommitSHA:  r.outputs["commit-sha"],
+		RemoteRef:  r.outputs["remote-ref"],
+		PRURL:      r.outputs["pr-url"],
+		Status:     r.outputs["status"],
+	}
+}
+
+// runRollback runs rollback, if it is set. It is cleared first, so
+// that a failure during the rollback doesn't try again.
+func (r *run) runRollback() {
+	r.restoring = true
+	if rb := r.rollback; rb != nil {
+		r.rollback = nil
+		rb()
+	}
+}
+
+// logError records msg as the error that ended the run, and logs it
+// with what is known about the run so far.
+func (r *run) logError(msg string) {
+	r.runError = strings.TrimSpace(msg)
+
+	var attrs []interface{}
+	for _, k := range []string{"module", "old-version", "new-version", "branch"} {
+		if v, ok := r.outputs[k]; ok {
+			attrs = append(attrs, strings.ReplaceAll(k, "-", "_"), v)
+		}
+	}
+
+	r.logger.Error(r.runError, attrs...)
+}
+
+// path returns name, relative to the directory the run started in if
+// it isn't absolute.
+func (r *run) path(name string) string {
+	if filepath.IsAbs(name) {
+		return name
+	}
+
+	return filepath.Join(r.baseDir, name)
+}
@@ -0,0 +1,568 @@
+package bump
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/vancluever/depbump/internal/gitrepo"
+)
+
+// exitError is the error of a fake command that exited with a status.
+type exitError int
+
+func (e exitError) Error() string { return fmt.Sprintf("exit status %d", int(e)) }
+func (e exitError) ExitCode() int { return int(e) }
+
+// fakeResult is what a fake command outputs, and the status it exits
+// with.
+type fakeResult struct {
+	out  string
+	code int
+}
+
+// fakeRunner runs commands by looking up their arguments in results,
+// and records the commands it is asked to run. Commands without a
+// result succeed, without output.
+type fakeRunner struct {
+	results map[string]fakeResult
+
+	mu    sync.Mutex
+	calls []string
+}
+
+func (f *fakeRunner) Run(ctx context.Context, c *gitrepo.Command) error {
+	call := strings.Join(append([]string{c.Name}, c.Args...), " ")
+	f.mu.Lock()
+	f.calls = append(f.calls, call)
+	f.mu.Unlock()
+
+	r := f.results[call]
+	if c.Stdout != nil {
+		fmt.Fprint(c.Stdout, r.out)
+	}
+
+	if r.code != 0 {
+		return exitError(r.code)
+	}
+
+	return nil
+}
+
+// ran returns true if call was run.
+func (f *fakeRunner) ran(call string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, c := range f.calls {
+		if c == call {
+			return true
+		}
+	}
+
+	return false
+}
+
+// repoResults returns the results for the commands every run in a
+// clean repository makes, with its git directory in dir, and more added
+// to them.
+func repoResults(dir string, more map[string]fakeResult) map[string]fakeResult {
+	results := map[string]fakeResult{
+		"git remote":                  {out: "origin\n"},
+		"git remote get-url origin":   {out: "https://github.com/owner/repo.git\n"},
+		"git config --get gpg.format": {code: 1},
+		"git rev-parse --path-format=absolute --git-common-dir": {out: dir + "\n"},
+	}
+
+	for k, v := range more {
+		results[k] = v
+	}
+
+	return results
+}
+
+// newTestRun returns a run with opts, in dir, that runs commands with
+// runner, and sends GitHub API requests to api, if it is set. Nothing
+// it logs or outputs is kept.
+func newTestRun(opts Options, dir string, runner gitrepo.Runner, api *httptest.Server) *run {
+	opts.NoActionsOutput = true
+	opts.runner = runner
+	if api != nil {
+		opts.gitHubURL = api.URL
+	}
+
+	r := newRun(opts)
+	r.stdout, r.stderr, r.stdin = io.Discard, io.Discard, strings.NewReader("")
+	r.logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	r.client = &http.Client{Transport: http.DefaultTransport}
+	r.dir, r.baseDir = dir, dir
+	return r
+}
+
+// newTestAPI returns a mocked GitHub API, answering each request with
+// the body registered for its method and path, as JSON.
+func newTestAPI(t *testing.T, responses map[string]string) *httptest.Server {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := responses[r.Method+" "+r.URL.RequestURI()]
+		if !ok {
+			t.Errorf("unexpected request: %s %s", r.Method, r.URL.RequestURI())
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		fmt.Fprint(w, body)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestExitCode(t *testing.T) {
+	cases := []struct {
+		name   string
+		status string
+		err    error
+		want   int
+	}{
+		{name: "updated", status: "updated", want: ExitUpdated},
+		{name: "already current", status: "already-current", want: ExitAlreadyCurrent},
+		{name: "no changes", status: "no-changes", want: ExitAlreadyCurrent},
+		{name: "pr exists", status: "pr-exists", want: ExitExists},
+		{name: "branch exists", status: "branch-exists", want: ExitExists},
+		{name: "declined", status: "declined", want: ExitError},
+		{name: "coded error", err: failf(ExitGitFailed, "push failed"), want: ExitGitFailed},
+		{name: "wrapped coded error", err: fmt.Errorf("wrapped: %w", fail(ExitLocked, errors.New("locked"))), want: ExitLocked},
+		{name: "plain error", err: errors.New("failed"), want: ExitError},
+		{name: "usage error", err: usagef("bad flag"), want: ExitError},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := ExitCode(Result{Status: tc.status}, tc.err); got != tc.want {
+				t.Fatalf("expected %d, got %d", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestRunUncommittedChanges(t *testing.T) {
+	dir := t.TempDir()
+	runner := &fakeRunner{results: repoResults(dir, map[string]fakeResult{
+		"git status --porcelain": {out: " M main.go\n"},
+	})}
+
+	r := newTestRun(Options{Path: "rsc.io/quote"}, dir, runner, nil)
+	res, err := r.execute(context.Background())
+	if got := ExitCode(res, err); got != ExitPrecondition {
+		t.Fatalf("expected exit status %d, got %d (%v)", ExitPrecondition, got, err)
+	}
+
+	if want := "uncommitted changes in repository, please commit or stash before continuing"; err.Error() != want {
+		t.Fatalf("expected %q, got %q", want, err)
+	}
+
+	if res.Status != "failed" {
+		t.Fatalf("expected status failed, got %q", res.Status)
+	}
+
+	// The lock is released once the run ends.
+	if _, err := os.Stat(filepath.Join(dir, lockFileName)); !os.IsNotExist(err) {
+		t.Fatalf("expected lock file to be removed, got %v", err)
+	}
+}
+
+func TestRunOptionErrors(t *testing.T) {
+	cases := []struct {
+		name string
+		opts Options
+		want string
+	}{
+		{
+			name: "constraint",
+			opts: Options{Path: "rsc.io/quote", Constraint: "garbage"},
+			want: `invalid -constraint: invalid constraint "garbage": invalid version "garbage"`,
+		},
+		{
+			name: "commit",
+			opts: Options{Path: "rsc.io/quote", Commit: "xyz"},
+			want: `invalid commit "xyz", expected 7 to 40 hex characters`,
+		},
+		{
+			name: "author",
+			opts: Options{Path: "rsc.io/quote", Author: "nobody"},
+			want: `invalid author "nobody", expected "Name <email>"`,
+		},
+		{
+			name: "group with path",
+			opts: Options{Path: "rsc.io/quote", Group: "rsc.io/"},
+			want: "PATH cannot be given with -group",
+		},
+		{
+			name: "from file with path",
+			opts: Options{Path: "rsc.io/quote", FromFile: "modules.txt"},
+			want: "PATH cannot be given with -from-file; give a post-update command after --",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			runner := &fakeRunner{results: repoResults(dir, nil)}
+			r := newTestRun(tc.opts, dir, runner, nil)
+			_, err := r.execute(context.Background())
+
+			var runErr *Error
+			if !errors.As(err, &runErr) || !runErr.Usage {
+				t.Fatalf("expected usage error, got %v", err)
+			}
+
+			if err.Error() != tc.want {
+				t.Fatalf("expected %q, got %q", tc.want, err)
+			}
+
+			if runner.ran("git status --porcelain") {
+				t.Fatal("expected the repository not to be looked at")
+			}
+		})
+	}
+}
+
+func TestRunFromFile(t *testing.T) {
+	dir := t.TempDir()
+	list := filepath.Join(dir, "modules.txt")
+	if err := ioutil.WriteFile(list, []byte("# updates\nrsc.io/quote@v1.5.2\nnot a module@\nrsc.io/sampler\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	runner := &fakeRunner{results: repoResults(dir, map[string]fakeResult{
+		"git status --porcelain": {out: " M main.go\n"},
+	})}
+
+	r := newTestRun(Options{FromFile: "modules.txt"}, dir, runner, nil)
+	_, err := r.execute(context.Background())
+	if want := "3 of 3 entries in the module list failed"; err == nil || err.Error() != want {
+		t.Fatalf("expected %q, got %v", want, err)
+	}
+
+	if got := ExitCode(Result{}, err); got != ExitError {
+		t.Fatalf("expected exit status %d, got %d", ExitError, got)
+	}
+}
+
+func TestReadList(t *testing.T) {
+	r := newTestRun(Options{}, t.TempDir(), &fakeRunner{}, nil)
+	r.stdin = strings.NewReader("rsc.io/quote@v1.5.2\n\n  # comment\nrsc.io/sampler\nbad@@v1\nrsc.io/x@\n")
+
+	entries, malformed, err := r.readList("-")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []listEntry{{Path: "rsc.io/quote", Version: "v1.5.2"}, {Path: "rsc.io/sampler"}}
+	if !reflect.DeepEqual(entries, want) {
+		t.Fatalf("expected %+v, got %+v", want, entries)
+	}
+
+	if malformed != 2 {
+		t.Fatalf("expected 2 malformed entries, got %d", malformed)
+	}
+}
+
+func TestRunCleanup(t *testing.T) {
+	merged := `"2024-01-01T00:00:00Z"`
+	api := newTestAPI(t, map[string]string{
+		"GET /repos/owner/repo/pulls?state=closed&sort=updated&direction=desc&per_page=100": `[
+			{"number": 1, "merged_at": ` + merged + `, "head": {"ref": "update-quote-v1.5.2", "sha": "aaa", "repo": {"full_name": "owner/repo"}}},
+			{"number": 2, "merged_at": ` + merged + `, "head": {"ref": "update-sampler-v1.3.1", "sha": "bbb", "repo": {"full_name": "owner/repo"}}},
+			{"number": 3, "merged_at": null, "head": {"ref": "update-text-v0.3.0", "sha": "ccc", "repo": {"full_name": "owner/repo"}}},
+			{"number": 4, "merged_at": ` + merged + `, "head": {"ref": "feature", "sha": "ddd", "repo": {"full_name": "owner/repo"}}},
+			{"number": 5, "merged_at": ` + merged + `, "head": {"ref": "update-yaml-v3.0.1", "sha": "eee", "repo": {"full_name": "someone/repo"}}}
+		]`,
+	})
+
+	dir := t.TempDir()
+	runner := &fakeRunner{results: repoResults(dir, map[string]fakeResult{
+		"git ls-remote --heads origin": {out: strings.Join([]string{
+			"aaa\trefs/heads/update-quote-v1.5.2",
+			"fff\trefs/heads/update-sampler-v1.3.1",
+			"ccc\trefs/heads/update-text-v0.3.0",
+			"ddd\trefs/heads/feature",
+			"eee\trefs/heads/update-yaml-v3.0.1",
+		}, "\n")},
+	})}
+
+	r := newTestRun(Options{Path: "cleanup", Push: true, Token: "secret"}, dir, runner, api)
+	res, err := r.execute(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.Status != "cleaned-up" {
+		t.Fatalf("expected status cleaned-up, got %q", res.Status)
+	}
+
+	// Only the merged update branch that hasn't moved since is deleted.
+	var deleted []string
+	for _, c := range runner.calls {
+		if strings.HasPrefix(c, "git push") {
+			deleted = append(deleted, c)
+		}
+	}
+
+	if want := []string{"git push -q origin --delete update-quote-v1.5.2"}; !reflect.DeepEqual(deleted, want) {
+		t.Fatalf("expected %q, got %q", want, deleted)
+	}
+}
+
+func TestRunCleanupWithoutToken(t *testing.T) {
+	dir := t.TempDir()
+	runner := &fakeRunner{results: repoResults(dir, nil)}
+	r := newTestRun(Options{Path: "cleanup", Push: true}, dir, runner, nil)
+	_, err := r.execute(context.Background())
+	if got := ExitCode(Result{}, err); got != ExitPrecondition {
+		t.Fatalf("expected exit status %d, got %d (%v)", ExitPrecondition, got, err)
+	}
+
+	if want := "GITHUB_TOKEN is not set; it is needed to find merged pull requests"; err.Error() != want {
+		t.Fatalf("expected %q, got %q", want, err)
+	}
+}
+
+func TestWaitForChecks(t *testing.T) {
+	const (
+		checkRuns = "GET /repos/owner/repo/commits/abc/check-runs?per_page=100"
+		statuses  = "GET /repos/owner/repo/commits/abc/status?per_page=100"
+		required  = "GET /repos/owner/repo/branches/main/protection/required_status_checks"
+	)
+
+	cases := []struct {
+		name      string
+		responses map[string]string
+		want      bool
+	}{
+		{
+			name: "passed",
+			responses: map[string]string{
+				checkRuns: `{"check_runs": [{"name": "build", "status": "completed", "conclusion": "success"}]}`,
+				statuses:  `{"statuses": [{"context": "ci", "state": "success"}]}`,
+				required:  `{"contexts": ["build"]}`,
+			},
+			want: true,
+		},
+		{
+			name: "required check failed",
+			responses: map[string]string{
+				checkRuns: `{"check_runs": [{"name": "build", "status": "completed", "conclusion": "failure"}]}`,
+				statuses:  `{"statuses": []}`,
+				required:  `{"contexts": ["build"]}`,
+			},
+			want: false,
+		},
+		{
+			name: "optional check failed",
+			responses: map[string]string{
+				checkRuns: `{"check_runs": [{"name": "build", "status": "completed", "conclusion": "success"}, {"name": "lint", "status": "completed", "conclusion": "failure"}]}`,
+				statuses:  `{"statuses": []}`,
+				required:  `{"contexts": ["build"]}`,
+			},
+			want: true,
+		},
+		{
+			name: "required check not reported",
+			responses: map[string]string{
+				checkRuns: `{"check_runs": [{"name": "build", "status": "completed", "conclusion": "success"}]}`,
+				statuses:  `{"statuses": []}`,
+				required:  `{"contexts": ["build", "test"]}`,
+			},
+			want: false,
+		},
+		{
+			name: "every check required without protection",
+			responses: map[string]string{
+				checkRuns: `{"check_runs": [{"name": "lint", "status": "completed", "conclusion": "failure"}]}`,
+				statuses:  `{"statuses": []}`,
+				required:  `{}`,
+			},
+			want: false,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			api := newTestAPI(t, tc.responses)
+			r := newTestRun(Options{Token: "secret"}, t.TempDir(), &fakeRunner{}, api)
+			got, err := r.waitForChecks(context.Background(), "owner", "repo", "main", "abc", time.Minute)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got != tc.want {
+				t.Fatalf("expected %t, got %t", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestRunCurrentBranch(t *testing.T) {
+	const head = "0123456789abcdef0123456789abcdef01234567"
+	cases := []struct {
+		name     string
+		opts     Options
+		results  map[string]fakeResult
+		wantCode int
+		wantErr  string
+		wantCall string
+	}{
+		{
+			name: "symbolic-ref failed",
+			opts: Options{Path: "rsc.io/quote"},
+			results: map[string]fakeResult{
+				"git symbolic-ref -q --short HEAD": {code: 128},
+			},
+			wantCode: ExitError,
+			wantErr:  "error finding the current branch: exit status 128",
+		},
+		{
+			name: "detached without remote HEAD",
+			opts: Options{Path: "rsc.io/quote", Push: true, PR: true, Token: "secret"},
+			results: map[string]fakeResult{
+				"git symbolic-ref -q --short HEAD":   {code: 1},
+				"git rev-parse HEAD":                 {out: head + "\n"},
+				"git ls-remote --symref origin HEAD": {code: 128},
+			},
+			wantCode: ExitPrecondition,
+			wantErr:  "HEAD is detached, and the remote default branch could not be found; use -base to set the base branch for the PR",
+			wantCall: "git rev-parse HEAD",
+		},
+		{
+			name: "detached with empty remote",
+			opts: Options{Path: "rsc.io/quote", Push: true, PR: true, Token: "secret"},
+			results: map[string]fakeResult{
+				"git symbolic-ref -q --short HEAD": {code: 1},
+				"git rev-parse HEAD":               {out: head + "\n"},
+			},
+			wantCode: ExitPrecondition,
+			wantErr:  "HEAD is detached, and the remote default branch could not be found; use -base to set the base branch for the PR",
+			wantCall: "git ls-remote --symref origin HEAD",
+		},
+		{
+			name: "attached without remote HEAD",
+			opts: Options{Path: "rsc.io/quote", Push: true, PR: true, Token: "secret"},
+			results: map[string]fakeResult{
+				"git symbolic-ref -q --short HEAD":   {out: "main\n"},
+				"git ls-remote --symref origin HEAD": {code: 128},
+			},
+			wantCode: ExitError,
+			wantErr:  "error detecting default remote branch: exit status 128",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			gomod := "module example.com/m\n\ngo 1.22\n\nrequire rsc.io/quote v1.5.1\n"
+			if err := ioutil.WriteFile(filepath.Join(dir, "go.mod"), []byte(gomod), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			runner := &fakeRunner{results: repoResults(dir, tc.results)}
+			r := newTestRun(tc.opts, dir, runner, nil)
+			res, err := r.execute(context.Background())
+			if got := ExitCode(res, err); got != tc.wantCode {
+				t.Fatalf("expected exit status %d, got %d (%v)", tc.wantCode, got, err)
+			}
+
+			if err.Error() != tc.wantErr {
+				t.Fatalf("expected %q, got %q", tc.wantErr, err)
+			}
+
+			if tc.wantCall != "" && !runner.ran(tc.wantCall) {
+				t.Fatalf("expected %q to run, got %q", tc.wantCall, runner.calls)
+			}
+
+			// Nothing was changed, so nothing is checked out again.
+			for _, c := range runner.calls {
+				if strings.Contains(c, " checkout ") {
+					t.Fatalf("expected no checkout, got %q", c)
+				}
+			}
+		})
+	}
+}
+
+func TestRunVersionExists(t *testing.T) {
+	const (
+		listVersions = "go list -m -versions -retracted -json rsc.io/quote"
+		resolve      = "go list -m -json rsc.io/quote@v1.2"
+	)
+
+	cases := []struct {
+		name     string
+		version  string
+		wantErr  string
+		wantCall string
+		skipCall string
+	}{
+		{
+			// A partial version is a query, for go get to resolve.
+			name:     "partial",
+			version:  "v1.2",
+			wantCall: resolve,
+			skipCall: listVersions,
+		},
+		{
+			name:     "missing",
+			version:  "v1.9.3",
+			wantErr:  "rsc.io/quote has no version v1.9.3; the closest versions are:\n  v1.5.1\n  v1.5.2\n  v1.9.2",
+			wantCall: listVersions,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			gomod := "module example.com/m\n\ngo 1.22\n\nrequire rsc.io/quote v1.5.1\n"
+			if err := ioutil.WriteFile(filepath.Join(dir, "go.mod"), []byte(gomod), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			runner := &fakeRunner{results: repoResults(dir, map[string]fakeResult{
+				"git symbolic-ref -q --short HEAD": {out: "main\n"},
+				listVersions:                       {out: `{"Path": "rsc.io/quote", "Versions": ["v1.5.1", "v1.5.2", "v1.9.2"]}`},
+				resolve:                            {code: 1},
+			})}
+
+			r := newTestRun(Options{Path: "rsc.io/quote", Version: tc.version}, dir, runner, nil)
+			_, err := r.execute(context.Background())
+			if tc.wantErr != "" {
+				if got := ExitCode(Result{}, err); got != ExitPrecondition {
+					t.Fatalf("expected exit status %d, got %d (%v)", ExitPrecondition, got, err)
+				}
+
+				if err.Error() != tc.wantErr {
+					t.Fatalf("expected %q, got %q", tc.wantErr, err)
+				}
+			}
+
+			if !runner.ran(tc.wantCall) {
+				t.Fatalf("expected %q to run, got %q", tc.wantCall, runner.calls)
+			}
+
+			if tc.skipCall != "" && runner.ran(tc.skipCall) {
+				t.Fatalf("expected %q not to run", tc.skipCall)
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package bump
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/vancluever/depbump/internal/gitrepo"
+)
+
+// Run implements gitrepo.Runner, for every command the run makes. The
+// command is logged at debug level, and run in the run's directory if
+// it has none. Once ctx is canceled, no more commands are started,
+// other than those putting the repository back, and those are run
+// without ctx, so that they aren't killed in their turn.
+func (r *run) Run(ctx context.Context, c *gitrepo.Command) error {
+	if r.restoring {
+		ctx = context.WithoutCancel(ctx)
+	} else if ctx.Err() != nil {
+		return errInterrupted
+	}
+
+	if c.Dir == "" {
+		c.Dir = r.dir
+	}
+
+	r.logger.DebugContext(commandCtx, "running command", "command", strings.Join(append([]string{c.Name}, c.Args...), " "))
+	return r.runner.Run(ctx, c)
+}
+
+// command returns the command name with args, with its dir set, if
+// it is not empty, and env added to its environment.
+func command(dir string, env []string, name string, args ...string) *gitrepo.Command {
+	return &gitrepo.Command{Name: name, Args: args, Dir: dir, Env: env}
+}
+
+// passthrough runs c, connecting both stdout and stderr.
+func (r *run) passthrough(ctx context.Context, c *gitrepo.Command) error {
+	c.Stdout = r.commandOutput(r.stdout, c.Name)
+	c.Stderr = r.commandOutput(r.stderr, c.Name)
+	return r.Run(ctx, c)
+}
+
+// output runs c, connecting stderr, and returns its output.
+func (r *run) output(ctx context.Context, c *gitrepo.Command) ([]byte, error) {
+	c.Stderr = r.commandOutput(r.stderr, c.Name)
+	return gitrepo.Output(ctx, r, c)
+}
+
+// exec runs name with args in the run's directory, connecting both
+// stdout and stderr.
+func (r *run) exec(ctx context.Context, name string, args ...string) error {
+	return r.passthrough(ctx, command("", nil, name, args...))
+}
+
+// execDir runs name with args in dir, connecting both stdout and
+// stderr.
+func (r *run) execDir(ctx context.Context, dir, name string, args ...string) error {
+	return r.passthrough(ctx, command(dir, nil, name, args...))
+}
+
+// git returns the repository being updated, running git through the
+// run.
+func (r *run) git() *gitrepo.Repo {
+	return &gitrepo.Repo{
+		Runner: r,
+		Dir:    r.dir,
+		Stdout: r.commandOutput(r.stdout, "git"),
+		Stderr: r.commandOutput(r.stderr, "git"),
+		Logger: r.logger,
+	}
+}
+
+// sleep waits for d, returning errInterrupted if ctx is canceled first.
+func sleep(ctx context.Context, d time.Duration) error {
+	select {
+	case <-time.After(d):
+		return nil
+	case <-ctx.Done():
+		return errInterrupted
+	}
+}
+
+// commandOutput returns the writer for output from the command cmd
+// written to w. The output is passed through as is, unless debug
+// logging is on, in which case each line is attributed to the
+// command with a prefix.
+func (r *run) commandOutput(w io.Writer, cmd string) io.Writer {
+	if !r.logger.Enabled(context.Background(), slog.LevelDebug) {
+		return w
+	}
+
+	return &prefixWriter{w: w, prefix: []byte("[" + filepath.Base(cmd) + "] ")}
+}
+
+// prefixWriter writes to w, starting each line with prefix.
+type prefixWriter struct {
+	w      io.Writer
+	prefix []byte
+
+	// midLine is set if the last write didn't end a line.
+	midLine bool
+}
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	n := len(b)
+	for len(b) > 0 {
+		if !p.midLine {
+			if _, err := p.w.Write(p.prefix); err != nil {
+				return 0, err
+			}
+		}
+
+		line := b
+		if i := bytes.IndexByte(b, '\n'); i >= 0 {
+			line = b[:i+1]
+		}
+
+		if _, err := p.w.Write(line); err != nil {
+			return 0, err
+		}
+
+		p.midLine = line[len(line)-1] != '\n'
+		b = b[len(line):]
+	}
+
+	return n, nil
+}
@@ -0,0 +1,130 @@
+package bump
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"strings"
+
+	"golang.org/x/mod/module"
+)
+
+// listEntry is a module to update from a -from-file list.
+type listEntry struct {
+	Path    string
+	Version string
+}
+
+// readList reads the -from-file list at name, or the run's stdin if
+// name is "-". Blank lines and lines starting with # are skipped.
+// Malformed lines are logged and skipped, and their count returned.
+func (r *run) readList(name string) ([]listEntry, int, error) {
+	in := r.stdin
+	if name != "-" {
+		f, err := os.Open(r.path(name))
+		if err != nil {
+			return nil, 0, err
+		}
+		defer f.Close()
+
+		in = f
+	}
+
+	var entries []listEntry
+	var malformed int
+	scanner := bufio.NewScanner(in)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		path, version, _ := strings.Cut(line, "@")
+		if err := module.CheckPath(path); err != nil || strings.ContainsAny(version, " \t@") || strings.HasSuffix(line, "@") {
+			r.logger.Warn("skipping malformed entry in module list", "line", n, "entry", line)
+			malformed++
+			continue
+		}
+
+		entries = append(entries, listEntry{Path: path, Version: version})
+	}
+
+	return entries, malformed, scanner.Err()
+}
+
+// child returns a run for opts that shares this run's streams, logger,
+// runner, and HTTP client, and starts from the same directory.
+func (r *run) child(opts Options) *run {
+	c := newRun(opts)
+	c.stdout, c.stderr, c.stdin = r.stdout, r.stderr, r.stdin
+	c.logger, c.runner, c.client = r.logger, r.runner, r.client
+	c.dir, c.baseDir = r.baseDir, r.baseDir
+	return c
+}
+
+// updateList updates each module in the -from-file list, each in its
+// own run with the rest of the options. Each run returns to the
+// original branch, so that the next starts from the same place. Once
+// every entry has run, it returns an error if any entry failed or was
+// malformed.
+func (r *run) updateList(ctx context.Context) error {
+	if err := r.checkListOptions(); err != nil {
+		return err
+	}
+
+	if _, err := r.parseOptions(ctx); err != nil {
+		return err
+	}
+
+	entries, malformed, err := r.readList(r.opts.FromFile)
+	if err != nil {
+		return failf(ExitPrecondition, "error reading module list: %w", err)
+	}
+
+	// The runs for the entries report for themselves.
+	childOpts := r.opts
+	childOpts.FromFile = ""
+	r.opts.NoActionsOutput = true
+	r.opts.SummaryFile, r.opts.WebhookURL, r.opts.SlackWebhook = "", "", ""
+
+	codes := make([]int, len(entries))
+	for i, e := range entries {
+		if ctx.Err() != nil {
+			return errInterrupted
+		}
+
+		r.logger.Info("updating module from list", "module", e.Path, "version", e.Version)
+		opts := childOpts
+		opts.Path, opts.Version = e.Path, e.Version
+		codes[i] = ExitCode(r.child(opts).execute(ctx))
+	}
+
+	failed := malformed
+	for i, e := range entries {
+		var result string
+		switch codes[i] {
+		case ExitUpdated:
+			result = "updated"
+
+		case ExitAlreadyCurrent:
+			result = "already current"
+
+		case ExitExists:
+			result = "branch or pull request exists"
+
+		default:
+			result = "failed"
+			failed++
+		}
+
+		r.logger.Info("module list entry finished", "module", e.Path, "version", e.Version, "result", result, "exit_status", codes[i])
+	}
+
+	if failed > 0 {
+		return failf(ExitError, "%d of %d entries in the module list failed", failed, len(entries)+malformed)
+	}
+
+	r.logger.InfoContext(successCtx, "every module in the list was processed", "entries", len(entries))
+	r.setOutput("status", "updated")
+	return nil
+}
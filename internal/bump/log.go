@@ -0,0 +1,131 @@
+package bump
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+)
+
+// setupLogger replaces the run's logger with one writing to stderr in
+// format, text or json, logging at debug level if verbose is set.
+// Text is colored according to color: always, never, or auto, for
+// when stderr is a terminal and NO_COLOR isn't set. Empty values are
+// the defaults, text and auto.
+func (r *run) setupLogger(format, color string, verbose bool) error {
+	opts := &slog.HandlerOptions{Level: slog.LevelInfo}
+	if verbose {
+		opts.Level = slog.LevelDebug
+	}
+
+	var colored bool
+	switch color {
+	case "always":
+		colored = true
+	case "never":
+	case "auto", "":
+		colored = isTerminal(r.stderr) && os.Getenv("NO_COLOR") == ""
+	default:
+		return fmt.Errorf("invalid color mode %q, expected always, never, or auto", color)
+	}
+
+	switch {
+	case format == "json":
+		r.logger = slog.New(slog.NewJSONHandler(r.stderr, opts))
+	case format != "text" && format != "":
+		return fmt.Errorf("invalid log format %q, expected text or json", format)
+	case colored:
+		r.logger = slog.New(newColorHandler(r.stderr, opts))
+	default:
+		r.logger = slog.New(slog.NewTextHandler(r.stderr, opts))
+	}
+
+	return nil
+}
+
+// isTerminal returns true if w is a terminal.
+func isTerminal(w interface{}) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// ANSI escape sequences for the colors used in terminal output.
+const (
+	ansiRed    = "\x1b[31m"
+	ansiGreen  = "\x1b[32m"
+	ansiYellow = "\x1b[33m"
+	ansiCyan   = "\x1b[36m"
+	ansiReset  = "\x1b[0m"
+)
+
+// colorKey is the context key for the color of a log record, when it
+// shouldn't be colored by its level.
+type colorKey struct{}
+
+// Contexts for logging the final success lines, and the commands run
+// on behalf of the user, in their own colors.
+var (
+	successCtx = context.WithValue(context.Background(), colorKey{}, ansiGreen)
+	commandCtx = context.WithValue(context.Background(), colorKey{}, ansiCyan)
+)
+
+// colorHandler is a text handler that colors each line: errors in red,
+// warnings in yellow, and other lines in the color in their context,
+// if any.
+type colorHandler struct {
+	slog.Handler
+
+	// buf holds the line written by Handler, and mu guards it and w.
+	w   io.Writer
+	buf *bytes.Buffer
+	mu  *sync.Mutex
+}
+
+// newColorHandler returns a colorHandler writing to w, with opts for
+// the text handler.
+func newColorHandler(w io.Writer, opts *slog.HandlerOptions) *colorHandler {
+	buf := new(bytes.Buffer)
+	return &colorHandler{Handler: slog.NewTextHandler(buf, opts), w: w, buf: buf, mu: new(sync.Mutex)}
+}
+
+func (h *colorHandler) Handle(ctx context.Context, r slog.Record) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.buf.Reset()
+	if err := h.Handler.Handle(ctx, r); err != nil {
+		return err
+	}
+
+	color, _ := ctx.Value(colorKey{}).(string)
+	switch {
+	case r.Level >= slog.LevelError:
+		color = ansiRed
+	case r.Level >= slog.LevelWarn:
+		color = ansiYellow
+	}
+
+	line := bytes.TrimSuffix(h.buf.Bytes(), []byte("\n"))
+	if color != "" {
+		line = []byte(color + string(line) + ansiReset)
+	}
+
+	_, err := h.w.Write(append(line, '\n'))
+	return err
+}
+
+func (h *colorHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &colorHandler{Handler: h.Handler.WithAttrs(attrs), w: h.w, buf: h.buf, mu: h.mu}
+}
+
+func (h *colorHandler) WithGroup(name string) slog.Handler {
+	return &colorHandler{Handler: h.Handler.WithGroup(name), w: h.w, buf: h.buf, mu: h.mu}
+}
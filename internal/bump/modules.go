@@ -0,0 +1,348 @@
+package bump
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/vancluever/depbump/internal/modinfo"
+)
+
+// pkgVersion returns the version string of the supplied package, in
+// the module in dir. The special path "go" returns the version in the
+// go directive.
+func pkgVersion(dir, path string) (string, error) {
+	f, err := modinfo.Load(dir)
+	if err != nil {
+		return "", err
+	}
+
+	if path == "go" {
+		return f.GoVersion(), nil
+	}
+
+	v, err := f.Version(path)
+	if err == modinfo.ErrNotFound {
+		return "", failf(ExitPrecondition, "package %q not found in go.mod, cannot get version", path)
+	}
+
+	return v, err
+}
+
+// moduleUpdates returns a moduleUpdate for every module in dirs that
+// requires path, with directories displayed relative to root. The path
+// is canonicalized against the modules' requirements first, and the
+// canonical path is returned along with the updates.
+func (r *run) moduleUpdates(dirs []string, root, path string) (string, []*moduleUpdate, error) {
+	files := make([]*modinfo.File, len(dirs))
+	for i, dir := range dirs {
+		f, err := modinfo.Parse(filepath.Join(dir, "go.mod"))
+		if err != nil {
+			return "", nil, err
+		}
+
+		files[i] = f
+	}
+
+	path, err := r.canonicalPath(files, path)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var result []*moduleUpdate
+	for i, f := range files {
+		v, err := f.Version(path)
+		if err == modinfo.ErrNotFound {
+			continue
+		} else if err != nil {
+			return "", nil, err
+		}
+
+		m := &moduleUpdate{Dir: dirs[i], OldVersion: v, dir: dirs[i]}
+		if rel, err := filepath.Rel(root, dirs[i]); err == nil {
+			m.Dir = rel
+		}
+
+		result = append(result, m)
+	}
+
+	return path, result, nil
+}
+
+// requireVersions returns the required version of every module in
+// the go.mod file of the module in dir, keyed by path.
+func requireVersions(dir string) (map[string]string, error) {
+	f, err := modinfo.Load(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]string)
+	for _, r := range f.Requirements() {
+		result[r.Path] = r.Version
+	}
+
+	return result, nil
+}
+
+// requirementChanges compares two sets of requirements from
+// requireVersions, and returns the changes to every module other than
+// skip, sorted by path.
+func requirementChanges(before, after map[string]string, skip string) []requirementChange {
+	var result []requirementChange
+	for p, v := range before {
+		if p != skip && after[p] != v {
+			result = append(result, requirementChange{Path: p, OldVersion: v, NewVersion: after[p]})
+		}
+	}
+
+	for p, v := range after {
+		if _, ok := before[p]; !ok && p != skip {
+			result = append(result, requirementChange{Path: p, NewVersion: v})
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].Path < result[j].Path })
+	return result
+}
+
+// groupMembers returns the paths of the requirements in f that start
+// with prefix, in file order.
+func groupMembers(f *modinfo.File, prefix string) []string {
+	var result []string
+	for _, r := range f.Requirements() {
+		if strings.HasPrefix(r.Path, prefix) {
+			result = append(result, r.Path)
+		}
+	}
+
+	return result
+}
+
+// inGroup returns true if path is one of members.
+func inGroup(members []string, path string) bool {
+	for _, m := range members {
+		if m == path {
+			return true
+		}
+	}
+
+	return false
+}
+
+// tidyUpdates returns a moduleUpdate for every module in dirs, for
+// "depbump tidy".
+func tidyUpdates(dirs []string, root string) []*moduleUpdate {
+	var result []*moduleUpdate
+	for _, dir := range dirs {
+		m := &moduleUpdate{Dir: dir, dir: dir}
+		if rel, err := filepath.Rel(root, dir); err == nil {
+			m.Dir = rel
+		}
+
+		result = append(result, m)
+	}
+
+	return result
+}
+
+// canonicalPath returns the module path as it is written in the
+// requirements of files. If path isn't required as-is, but matches a
+// requirement when compared case-insensitively, the requirement's path
+// is used instead. If there's no match at all, the error lists similar
+// paths.
+func (r *run) canonicalPath(files []*modinfo.File, path string) (string, error) {
+	var matches, similar []string
+	seen := make(map[string]bool)
+	for _, f := range files {
+		if _, err := f.Version(path); err == nil {
+			return path, nil
+		}
+
+		for _, m := range f.FoldMatches(path) {
+			if !seen[m] {
+				seen[m] = true
+				matches = append(matches, m)
+			}
+		}
+
+		for _, m := range f.Similar(path) {
+			if !seen[m] {
+				seen[m] = true
+				similar = append(similar, m)
+			}
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		msg := fmt.Sprintf("package %q not found in go.mod, cannot get version", path)
+		if len(similar) > 0 {
+			msg += "\n\ndid you mean:\n  " + strings.Join(similar, "\n  ")
+		}
+
+		return "", fail(ExitPrecondition, errors.New(msg))
+
+	case 1:
+		r.logger.Info("using canonical module path", "module", matches[0], "path", path)
+
+	default:
+		return "", failf(ExitPrecondition, "%s matches more than one module path, specify one of:\n  %s", path, strings.Join(matches, "\n  "))
+	}
+
+	return matches[0], nil
+}
+
+// nonMetadataChanges returns the paths in the output of
+// "git status --porcelain" that are not module metadata: go.mod,
+// go.sum, go.work, go.work.sum, or anything in a vendor directory.
+func nonMetadataChanges(porcelain string) []string {
+	var result []string
+	for _, l := range strings.Split(strings.TrimRight(porcelain, "\n"), "\n") {
+		if len(l) < 4 {
+			continue
+		}
+
+		p := l[3:]
+		if i := strings.Index(p, " -> "); i >= 0 {
+			p = p[i+4:]
+		}
+
+		switch filepath.Base(p) {
+		case "go.mod", "go.sum", "go.work", "go.work.sum":
+			continue
+		}
+
+		if strings.HasPrefix(p, "vendor/") || strings.Contains(p, "/vendor/") {
+			continue
+		}
+
+		result = append(result, p)
+	}
+
+	return result
+}
+
+// stagePaths stages the changes to paths, including deletions. Paths
+// that don't exist, and aren't tracked either, are skipped. Relative
+// paths are relative to the run's directory.
+func (r *run) stagePaths(ctx context.Context, paths []string) error {
+	var existing []string
+	for _, p := range paths {
+		abs := p
+		if !filepath.IsAbs(abs) {
+			abs = filepath.Join(r.dir, p)
+		}
+
+		if _, err := os.Stat(abs); err != nil {
+			if !os.IsNotExist(err) {
+				return err
+			}
+
+			out, err := r.git().Output(ctx, "ls-files", "--", p)
+			if err != nil {
+				return err
+			}
+
+			if len(out) < 1 {
+				continue
+			}
+		}
+
+		existing = append(existing, p)
+	}
+
+	if len(existing) < 1 {
+		return nil
+	}
+
+	args := append([]string{"add", "--all", "--"}, existing...)
+	return r.git().Run(ctx, append(args, r.excludeUntracked()...)...)
+}
+
+// trackedChanges returns the output of "git status --porcelain" without
+// the entries for untracked files.
+func trackedChanges(porcelain string) string {
+	var result []string
+	for _, l := range strings.SplitAfter(porcelain, "\n") {
+		if l != "" && !strings.HasPrefix(l, "?? ") {
+			result = append(result, l)
+		}
+	}
+
+	return strings.Join(result, "")
+}
+
+// unstagedChanges returns the paths in the output of
+// "git status --porcelain" that have changes that aren't staged.
+func unstagedChanges(porcelain string) []string {
+	var result []string
+	for _, l := range strings.Split(strings.TrimRight(porcelain, "\n"), "\n") {
+		if len(l) < 4 || l[1] == ' ' {
+			continue
+		}
+
+		result = append(result, l[3:])
+	}
+
+	return result
+}
+
+// identityRe matches a git identity, "Name <email>".
+var identityRe = regexp.MustCompile(`^\s*([^<>]*[^<>\s])\s*<([^<>]+)>\s*$`)
+
+// identityEnv returns the environment variables that set the git
+// identity ident for role, which is "AUTHOR" or "COMMITTER".
+func identityEnv(role, ident string) []string {
+	m := identityRe.FindStringSubmatch(ident)
+	return []string{
+		"GIT_" + role + "_NAME=" + m[1],
+		"GIT_" + role + "_EMAIL=" + m[2],
+	}
+}
+
+// gitHubActionsIdentity is the identity used for commits made in
+// GitHub Actions, when there is no other.
+const gitHubActionsIdentity = "github-actions[bot] <41898282+github-actions[bot]@users.noreply.github.com>"
+
+// remoteBranchHasUpdate returns true if branch on remote requires
+// version of path in the module in dir. If versionInName is set, the
+// branch name identifies the update completely, so the branch is
+// assumed to contain it. env is used for fetching from remote.
+func (r *run) remoteBranchHasUpdate(ctx context.Context, remote, branch, dir, path, version string, versionInName bool, env []string) (bool, error) {
+	if versionInName {
+		return true, nil
+	}
+
+	if err := r.git().RunEnv(ctx, env, "fetch", "-q", remote, "refs/heads/"+branch); err != nil {
+		return false, fmt.Errorf("error fetching remote branch %s: %w", branch, err)
+	}
+
+	root, err := r.git().TopLevel(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	gomod, err := filepath.Rel(root, filepath.Join(dir, "go.mod"))
+	if err != nil {
+		return false, err
+	}
+
+	data, err := r.git().Output(ctx, "show", "FETCH_HEAD:"+filepath.ToSlash(gomod))
+	if err != nil {
+		return false, nil
+	}
+
+	f, err := modinfo.ParseData(gomod, []byte(data))
+	if err != nil {
+		return false, nil
+	}
+
+	v, err := f.Version(path)
+	return err == nil && v == version, nil
+}
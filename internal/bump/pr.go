@@ -0,0 +1,340 @@
+package bump
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/vancluever/depbump/internal/forge"
+	"github.com/vancluever/depbump/internal/modinfo"
+	"github.com/vancluever/depbump/internal/modrepo"
+)
+
+// maxBranchLength is the longest update branch name used. Longer names
+// are shortened with a hash, since some hosts and tools cap the length
+// of refs.
+const maxBranchLength = 100
+
+// checkAppearTimeout is how long to wait for the first check to be
+// reported, before deciding the commit has none.
+const checkAppearTimeout = 2 * time.Minute
+
+// gitHub returns the client for the GitHub API, authenticated with the
+// GitHub App if there is one, or the token otherwise.
+func (r *run) gitHub() *forge.GitHub {
+	return &forge.GitHub{BaseURL: r.opts.gitHubURL, Client: r.client, Token: r.githubToken, Logger: r.logger}
+}
+
+// githubToken returns the token for GitHub API calls: one minted for
+// the GitHub App, if there is one, or the token given.
+func (r *run) githubToken(ctx context.Context) (string, error) {
+	if r.app != nil {
+		return r.app.Token(ctx)
+	}
+
+	return r.opts.Token, nil
+}
+
+// pushEnv returns the environment for git commands that talk to the
+// push remote, which authenticates them as the GitHub App if AppPush is
+// set.
+func (r *run) pushEnv(ctx context.Context) ([]string, error) {
+	if r.app == nil || !r.opts.AppPush {
+		return nil, nil
+	}
+
+	token, err := r.app.Token(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return forge.GitAuthEnv(token), nil
+}
+
+// openPRExists returns true if there is an open pull request in the
+// GitHub repository from head (OWNER:BRANCH). If that can't be
+// determined, it is assumed there is one, so that nothing is replaced
+// by mistake.
+func (r *run) openPRExists(ctx context.Context, owner, repo, head string) bool {
+	prURL, err := r.gitHub().OpenPR(ctx, owner, repo, head)
+	if err != nil {
+		r.logger.Warn("could not check for an open pull request", "head", head, "error", err)
+		return true
+	}
+
+	return prURL != ""
+}
+
+// createPR opens pr in the GitHub repository owner/repo, and returns
+// its URL. If replaced is set, the head branch replaced one with a
+// different update, so a pull request that already exists for it is
+// updated to match pr, and existed set.
+func (r *run) createPR(ctx context.Context, owner, repo string, pr forge.NewPR, replaced bool) (prURL string, existed bool, err error) {
+	prURL, existed, err = r.gitHub().CreatePR(ctx, owner, repo, pr)
+	if err != nil {
+		return "", false, err
+	}
+
+	if existed && replaced {
+		r.updatePR(ctx, owner, repo, prURL, pr.Title, pr.Body)
+	}
+
+	return prURL, existed, nil
+}
+
+// updatePR replaces the title and body of the pull request at prURL in
+// the GitHub repository, after its branch has been replaced with a
+// different update. Failures are reported as warnings, since the
+// branch is already pushed.
+func (r *run) updatePR(ctx context.Context, owner, repo, prURL, title, body string) {
+	r.logger.Info("updating pull request for the new update", "pr_url", prURL)
+	if err := r.gitHub().UpdatePR(ctx, owner, repo, prURL, title, body); err != nil {
+		r.logger.Warn("could not update pull request", "pr_url", prURL, "error", err)
+	}
+}
+
+// branchVersionRe matches the part of an update branch name following
+// the project: a module version, a Go version, or the hash used for
+// tidy and group updates.
+var branchVersionRe = regexp.MustCompile(`^(v[0-9]+\.[0-9]+\.[0-9]+\S*|[0-9]+\.[0-9]+(\.[0-9]+)?\S*|(group-)?[0-9a-f]{12})$`)
+
+// supersedePRs closes the open pull requests in the GitHub repository
+// for earlier updates of project, which the pull request for branch, at
+// prURL, replaces. Only pull requests from update branches in
+// headRepo (OWNER/REPO), the repository depbump pushes to, are
+// considered, and their branches are deleted from remote, with env.
+// Failures are reported as warnings, since the new pull request
+// already exists.
+func (r *run) supersedePRs(ctx context.Context, owner, repo, headRepo, remote, prefix, project, branch, prURL string, env []string) {
+	gh := r.gitHub()
+	prs, err := gh.OpenPRs(ctx, owner, repo)
+	if err != nil {
+		r.logger.Warn("could not list pull requests to supersede", "error", err)
+		return
+	}
+
+	for _, p := range prs {
+		ref := p.Head.Ref
+		if ref == branch || p.Head.Repo.FullName != headRepo {
+			continue
+		}
+
+		if !strings.HasPrefix(ref, prefix+branchPart(project)+"-") || !branchVersionRe.MatchString(strings.TrimPrefix(ref, prefix+branchPart(project)+"-")) {
+			continue
+		}
+
+		r.logger.Info("closing superseded pull request", "pr_url", p.URL)
+		if err := gh.Comment(ctx, owner, repo, p.Number, "Superseded by "+prURL+"."); err != nil {
+			r.logger.Warn("could not comment on pull request", "pr_url", p.URL, "error", err)
+			continue
+		}
+
+		if err := gh.ClosePR(ctx, owner, repo, p.Number); err != nil {
+			r.logger.Warn("could not close pull request", "pr_url", p.URL, "error", err)
+			continue
+		}
+
+		if err := r.git().RunEnv(ctx, env, "push", "-q", remote, "--delete", ref); err != nil {
+			r.logger.Warn("could not delete branch", "branch", ref, "error", err)
+		}
+	}
+}
+
+// branchUnsafeRe matches runs of characters that are left out of
+// update branch names. Some of these are allowed by git, such as the
+// "+" in +incompatible versions, but trip up other tools.
+var branchUnsafeRe = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// branchPart returns s, a project or version, with the characters that
+// are awkward in branch names replaced by dashes, and the sequences git
+// doesn't allow removed.
+func branchPart(s string) string {
+	s = branchUnsafeRe.ReplaceAllString(s, "-")
+	for strings.Contains(s, "..") {
+		s = strings.ReplaceAll(s, "..", ".")
+	}
+
+	return strings.TrimSuffix(strings.Trim(s, "."), ".lock")
+}
+
+// branchName returns the name of the update branch for version of
+// project, with prefix. Names longer than maxBranchLength are cut
+// short, ending in a hash of the full name instead.
+func branchName(prefix, project, version string) string {
+	name := prefix + branchPart(project) + "-" + branchPart(version)
+	if len(name) > maxBranchLength {
+		sum := fmt.Sprintf("%x", sha256.Sum256([]byte(name)))[:12]
+		name = strings.TrimRight(name[:maxBranchLength-len(sum)-1], "-.") + "-" + sum
+	}
+
+	return name
+}
+
+// updateBranch returns true if ref is named like an update branch with
+// prefix: the prefix, the project, and a version, as checked by
+// branchVersionRe. Versions can contain dashes, so every split is
+// tried.
+func updateBranch(ref, prefix string) bool {
+	name, ok := strings.CutPrefix(ref, prefix)
+	if !ok {
+		return false
+	}
+
+	for i := 1; i < len(name)-1; i++ {
+		if name[i] == '-' && branchVersionRe.MatchString(name[i+1:]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// cleanupBranches deletes the update branches, named with prefix, of
+// pull requests that have been merged into the GitHub repository,
+// listing and pushing the deletions to remote with env. Only branches
+// in headRepo (OWNER/REPO), the repository depbump pushes to, are
+// deleted, and only if they haven't changed since being merged. The
+// most recently updated 100 closed pull requests are checked.
+func (r *run) cleanupBranches(ctx context.Context, owner, repo, headRepo, remote, prefix string, env []string) error {
+	prs, err := r.gitHub().ClosedPRs(ctx, owner, repo)
+	if err != nil {
+		return fmt.Errorf("error listing closed pull requests: %w", err)
+	}
+
+	heads, err := r.git().RemoteHeads(ctx, remote, env)
+	if err != nil {
+		return fmt.Errorf("error listing remote branches: %w", err)
+	}
+
+	deleted := 0
+	for _, p := range prs {
+		ref := p.Head.Ref
+		if p.MergedAt == nil || p.Head.Repo.FullName != headRepo || !updateBranch(ref, prefix) {
+			continue
+		}
+
+		if heads[ref] == "" || heads[ref] != p.Head.SHA {
+			continue
+		}
+
+		r.logger.Info("deleting merged branch", "branch", ref, "pr_url", p.URL)
+		if err := r.git().RunEnv(ctx, env, "push", "-q", remote, "--delete", ref); err != nil {
+			if ctx.Err() != nil {
+				return errInterrupted
+			}
+
+			r.logger.Warn("could not delete branch", "branch", ref, "error", err)
+			continue
+		}
+
+		deleted++
+	}
+
+	r.logger.Info("merged update branches deleted", "count", deleted)
+	return nil
+}
+
+// waitForChecks polls the checks on sha until they have all finished,
+// or timeout passes, and logs a summary of them. Polling starts
+// often, and slows down the longer the checks take. It returns false if
+// a required check failed or didn't finish; if the required checks
+// aren't known for base, every check is taken to be required.
+func (r *run) waitForChecks(ctx context.Context, owner, repo, base, sha string, timeout time.Duration) (bool, error) {
+	r.logger.Info("waiting for checks", "commit", sha, "timeout", timeout)
+	gh := r.gitHub()
+	required := gh.RequiredChecks(ctx, owner, repo, base)
+	isRequired := func(c forge.Check) bool { return required == nil || required[c.Name] }
+
+	start := time.Now()
+	interval := 10 * time.Second
+	var checks []forge.Check
+	for {
+		var err error
+		checks, err = gh.CommitChecks(ctx, owner, repo, sha)
+		if err != nil {
+			if ctx.Err() != nil {
+				return false, errInterrupted
+			}
+
+			r.logger.Warn("could not get checks", "error", err)
+		}
+
+		done := err == nil && len(checks) > 0
+		for _, c := range checks {
+			if c.Result == "" {
+				done = false
+			}
+		}
+
+		if done {
+			break
+		}
+
+		if err == nil && len(checks) < 1 && time.Since(start) > checkAppearTimeout {
+			r.logger.Info("no checks were reported for the commit", "commit", sha)
+			return true, nil
+		}
+
+		if time.Since(start)+interval > timeout {
+			break
+		}
+
+		if err := sleep(ctx, interval); err != nil {
+			return false, err
+		}
+
+		interval = min(interval*3/2, time.Minute)
+	}
+
+	ok := true
+	for _, c := range checks {
+		result := c.Result
+		if result == "" {
+			result = "did not finish"
+		}
+
+		if (c.Result == "" || c.Failed()) && isRequired(c) {
+			ok = false
+		}
+
+		r.logger.Info("check", "name", c.Name, "result", result)
+	}
+
+	// Required checks that never reported aren't passing either.
+	for name := range required {
+		found := false
+		for _, c := range checks {
+			found = found || c.Name == name
+		}
+
+		if !found {
+			r.logger.Info("check", "name", name, "result", "not reported")
+			ok = false
+		}
+	}
+
+	return ok, nil
+}
+
+// replacementString formats the target of a replace directive.
+func replacementString(r modinfo.Replacement) string {
+	if r.NewVersion == "" {
+		return r.NewPath
+	}
+
+	return r.NewPath + " " + r.NewVersion
+}
+
+// repoRef returns the ref in repo that a module version refers to.
+// Tags for modules in a subdirectory of the repository carry the
+// subdirectory as a prefix.
+func repoRef(repo modrepo.Repo, version string) string {
+	if tag, ok := releaseTag(version); ok {
+		return repo.TagPrefix() + tag
+	}
+
+	return versionRef(version)
+}
@@ -0,0 +1,134 @@
+package bump
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/vancluever/depbump/internal/forge"
+)
+
+func TestCreatePR(t *testing.T) {
+	const (
+		create  = "POST /repos/owner/repo/pulls"
+		find    = "GET /repos/owner/repo/pulls?state=open&head=owner%3Aupdate-quote-v1.5.3"
+		patch   = "PATCH /repos/owner/repo/pulls/7"
+		comment = "POST /repos/owner/repo/issues/7/comments"
+		exists  = `{"errors": [{"message": "A pull request already exists for owner:update-quote-v1.5.3."}]}`
+		found   = `[{"number": 7, "html_url": "https://github.com/owner/repo/pull/7"}]`
+	)
+
+	cases := []struct {
+		name        string
+		replaced    bool
+		status      int
+		body        string
+		wantExisted bool
+		wantCalls   []string
+	}{
+		{
+			name:      "created",
+			replaced:  true,
+			status:    http.StatusCreated,
+			body:      `{"html_url": "https://github.com/owner/repo/pull/7"}`,
+			wantCalls: []string{create},
+		},
+		{
+			name:        "existed for the replaced branch",
+			replaced:    true,
+			status:      http.StatusUnprocessableEntity,
+			body:        exists,
+			wantExisted: true,
+			wantCalls:   []string{create, find, patch, comment},
+		},
+		{
+			name:        "existed for the same update",
+			status:      http.StatusUnprocessableEntity,
+			body:        exists,
+			wantExisted: true,
+			wantCalls:   []string{create, find},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var calls []string
+			var patched map[string]interface{}
+			responses := map[string]struct {
+				status int
+				body   string
+			}{
+				create:  {tc.status, tc.body},
+				find:    {http.StatusOK, found},
+				patch:   {http.StatusOK, `{}`},
+				comment: {http.StatusCreated, `{}`},
+			}
+
+			s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				call := r.Method + " " + r.URL.RequestURI()
+				mu.Lock()
+				calls = append(calls, call)
+				if call == patch {
+					data, _ := ioutil.ReadAll(r.Body)
+					if err := json.Unmarshal(data, &patched); err != nil {
+						t.Errorf("bad request body for %s: %s", call, err)
+					}
+				}
+				mu.Unlock()
+
+				resp, ok := responses[call]
+				if !ok {
+					t.Errorf("unexpected request: %s", call)
+					http.NotFound(w, r)
+					return
+				}
+
+				w.Header().Set("Content-Type", "application/json; charset=utf-8")
+				w.WriteHeader(resp.status)
+				fmt.Fprint(w, resp.body)
+			}))
+			defer s.Close()
+
+			r := newTestRun(Options{Token: "secret"}, t.TempDir(), &fakeRunner{}, s)
+			pr := forge.NewPR{
+				Title: "modules: upgrade quote to v1.5.3",
+				Body:  "Upgrades quote from v1.5.1 to v1.5.3.",
+				Head:  "owner:update-quote-v1.5.3",
+				Base:  "main",
+			}
+
+			prURL, existed, err := r.createPR(context.Background(), "owner", "repo", pr, tc.replaced)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if want := "https://github.com/owner/repo/pull/7"; prURL != want {
+				t.Fatalf("expected URL %q, got %q", want, prURL)
+			}
+
+			if existed != tc.wantExisted {
+				t.Fatalf("expected existed to be %t, got %t", tc.wantExisted, existed)
+			}
+
+			if !reflect.DeepEqual(calls, tc.wantCalls) {
+				t.Fatalf("expected calls %q, got %q", tc.wantCalls, calls)
+			}
+
+			// The existing pull request gets the title and body of the
+			// update now on its branch.
+			if len(tc.wantCalls) > 2 {
+				want := map[string]interface{}{"title": pr.Title, "body": pr.Body}
+				if !reflect.DeepEqual(patched, want) {
+					t.Fatalf("expected %+v, got %+v", want, patched)
+				}
+			}
+		})
+	}
+}
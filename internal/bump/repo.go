@@ -0,0 +1,409 @@
+package bump
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/vancluever/depbump/internal/gitremote"
+	"github.com/vancluever/depbump/internal/gitrepo"
+)
+
+// fallbackRemote is the remote used in place of origin if there is no
+// origin, as mirrors of a repository usually call it.
+const fallbackRemote = "upstream"
+
+// defaultForkRemote is the remote update branches are pushed to instead
+// of origin, if it exists and -fork-remote isn't given.
+const defaultForkRemote = "fork"
+
+// enterWorktree creates a temporary worktree with HEAD checked out,
+// and moves the run to the directory in it corresponding to the
+// current one. cleanup is set to remove the worktree again.
+func (r *run) enterWorktree(ctx context.Context) error {
+	prefix, err := r.git().Prefix(ctx)
+	if err != nil {
+		return err
+	}
+
+	dir, err := ioutil.TempDir("", "depbump-worktree-")
+	if err != nil {
+		return err
+	}
+
+	if err := r.git().Run(ctx, "worktree", "add", "--detach", dir, "HEAD"); err != nil {
+		os.Remove(dir)
+		return fmt.Errorf("error creating worktree: %w", err)
+	}
+
+	origDir := r.dir
+	next := r.cleanup
+	r.cleanup = func() {
+		r.removeWorktree(ctx, origDir, dir)
+		if next != nil {
+			next()
+		}
+	}
+
+	r.dir = filepath.Join(dir, prefix)
+	r.logger.Info("working in temporary worktree", "dir", dir)
+	return nil
+}
+
+// removeWorktree removes the temporary worktree in dir, running git
+// from origDir.
+func (r *run) removeWorktree(ctx context.Context, origDir, dir string) {
+	r.dir = origDir
+	if err := r.git().Run(ctx, "worktree", "remove", "--force", dir); err != nil {
+		r.logger.Warn("could not remove worktree", "dir", dir, "error", err)
+		os.RemoveAll(dir)
+	}
+
+	if err := r.git().Run(ctx, "worktree", "prune"); err != nil {
+		r.logger.Warn("could not prune worktrees", "error", err)
+	}
+}
+
+// untrackedFiles returns the untracked files in the repository that
+// aren't ignored, relative to its top.
+func (r *run) untrackedFiles(ctx context.Context) ([]string, error) {
+	out, err := r.git().Output(ctx, "ls-files", "-z", "--others", "--exclude-standard", "--full-name", "--", ":/")
+	if err != nil {
+		return nil, err
+	}
+
+	return strings.FieldsFunc(out, func(r rune) bool { return r == 0 }), nil
+}
+
+// excludeUntracked returns pathspecs excluding the files in
+// keepUntracked, to follow the pathspecs given to git add.
+func (r *run) excludeUntracked() []string {
+	var result []string
+	for _, p := range r.keepUntracked {
+		result = append(result, ":(top,exclude,literal)"+p)
+	}
+
+	return result
+}
+
+// cleanArgs returns the arguments to git clean that remove untracked
+// files, except for those in keepUntracked. Pathspecs can't be used for
+// these, since git clean removes untracked directories whole, so they
+// are given as exclude patterns.
+func (r *run) cleanArgs() []string {
+	args := []string{"clean", "-fd"}
+	for _, p := range r.keepUntracked {
+		args = append(args, "-e", "/"+globEscapeRe.ReplaceAllString(p, `\$0`))
+	}
+
+	return args
+}
+
+// globEscapeRe matches the characters that are special in gitignore
+// patterns.
+var globEscapeRe = regexp.MustCompile(`[\\*?\[!# ]`)
+
+// rollbackBranch returns a rollback function that discards everything
+// done on the update branch: it checks out the original branch (or
+// commit, if HEAD was detached), resets it to head, and deletes the
+// update branch, if branch isn't empty.
+func (r *run) rollbackBranch(ctx context.Context, oldBranch, head, branch string) func() {
+	return func() {
+		r.logger.Warn("rolling back", "branch", oldBranch)
+
+		steps := [][]string{
+			{"-c", "advice.detachedHead=false", "checkout", "-f", oldBranch},
+			{"reset", "--hard", head},
+			r.cleanArgs(),
+		}
+
+		if branch != "" {
+			steps = append(steps, []string{"branch", "-D", branch})
+		}
+
+		for _, args := range steps {
+			if err := r.git().Run(ctx, args...); err != nil {
+				r.logger.Warn("rollback failed; repository is in an unclean state, please correct before trying again", "command", "git "+strings.Join(args, " "), "error", err)
+				return
+			}
+		}
+	}
+}
+
+// resetAndFail attempts to revert the working tree back to HEAD, and
+// returns err, or the error resetting if that fails.
+func (r *run) resetAndFail(ctx context.Context, err error) error {
+	if rerr := r.git().Run(ctx, "reset", "--hard", "HEAD"); rerr != nil {
+		return failf(ExitGitFailed, "could not reset repository back to original state: %w", rerr)
+	}
+
+	return err
+}
+
+// lockInfo is the content of the lock file, identifying the run that
+// holds it.
+type lockInfo struct {
+	PID     int       `json:"pid"`
+	Started time.Time `json:"started"`
+}
+
+// lockFileName is the name of the lock file, in the repository's git
+// directory, which is shared by its worktrees.
+const lockFileName = "depbump.lock"
+
+// lockRepository takes the lock on the repository, so that runs in the
+// same repository don't get in each other's way, waiting up to timeout
+// for another run to release it. A lock left behind by a run that is no
+// longer running is taken over. The lock is released by cleanup.
+func (r *run) lockRepository(ctx context.Context, timeout time.Duration) error {
+	dir, err := r.git().CommonDir(ctx)
+	if err != nil {
+		return err
+	}
+
+	name := filepath.Join(dir, lockFileName)
+	content, err := json.Marshal(lockInfo{PID: os.Getpid(), Started: r.start.UTC()})
+	if err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(timeout)
+	waiting := false
+	for {
+		f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if err == nil {
+			_, err = f.Write(content)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+
+			if err != nil {
+				os.Remove(name)
+				return fmt.Errorf("error writing lock file: %w", err)
+			}
+
+			break
+		}
+
+		if !os.IsExist(err) {
+			return fmt.Errorf("error creating lock file: %w", err)
+		}
+
+		var other lockInfo
+		data, err := ioutil.ReadFile(name)
+		if err == nil {
+			err = json.Unmarshal(data, &other)
+		}
+
+		// A lock that can't be read may be being written, so it is
+		// only taken over once its run is known to have gone. A lock
+		// with this run's PID was left by an earlier run, for example
+		// in a container where the PID is always the same.
+		if err == nil && (other.PID == os.Getpid() || !processAlive(other.PID)) {
+			r.logger.Warn("removing lock left behind by a run that is no longer running", "lock_file", name, "pid", other.PID, "started", other.Started)
+			if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("error removing stale lock file: %w", err)
+			}
+
+			continue
+		}
+
+		if time.Now().After(deadline) {
+			return failf(ExitLocked, "another depbump run (pid %d, started %s) is using this repository; its lock is %s", other.PID, other.Started.Format(time.RFC3339), name)
+		}
+
+		if !waiting {
+			r.logger.Info("waiting for another run to finish", "pid", other.PID, "started", other.Started, "lock_file", name)
+			waiting = true
+		}
+
+		if err := sleep(ctx, time.Second); err != nil {
+			return err
+		}
+	}
+
+	next := r.cleanup
+	r.cleanup = func() {
+		if next != nil {
+			next()
+		}
+
+		if err := os.Remove(name); err != nil {
+			r.logger.Warn("could not remove lock file", "lock_file", name, "error", err)
+		}
+	}
+
+	return nil
+}
+
+// processAlive returns true if there is a running process with pid.
+func processAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+
+	// EPERM means the process exists, but belongs to another user, so
+	// only ESRCH (which os reports as ErrProcessDone) means it is gone.
+	err = p.Signal(syscall.Signal(0))
+	return !errors.Is(err, os.ErrProcessDone) && !errors.Is(err, syscall.ESRCH)
+}
+
+// validBranchPrefix checks that prefix produces a legal branch name
+// when a name is appended to it.
+func (r *run) validBranchPrefix(ctx context.Context, prefix string) bool {
+	return prefix == "" || r.git().ValidBranchName(ctx, prefix+"x")
+}
+
+// checkShallowBase makes sure that, in a shallow clone, HEAD can be
+// seen to be on the base branch the pull request is opened against,
+// fetching up to depth commits of it if depth is set. The error says
+// what to fetch otherwise.
+func (r *run) checkShallowBase(ctx context.Context, base string, depth int) error {
+	err := r.git().EnsureOnBase(ctx, r.remote, base, depth)
+
+	var shallow *gitrepo.ShallowError
+	if !errors.As(err, &shallow) {
+		return err
+	}
+
+	if shallow.Missing {
+		return failf(ExitPrecondition, "%w\nor use -fetch-depth", err)
+	}
+
+	return failf(ExitPrecondition, "%w\nor use a larger -fetch-depth", err)
+}
+
+// fromRefCommit returns the commit that ref, given with -from-ref,
+// points to. A ref that can't be found is fetched from the remote
+// first, as CI checkouts often only have the branch being built.
+func (r *run) fromRefCommit(ctx context.Context, ref string) (string, error) {
+	revParse := func(ref string) string {
+		out, err := r.git().Output(ctx, "rev-parse", "-q", "--verify", ref+"^{commit}")
+		if err != nil {
+			return ""
+		}
+
+		return strings.TrimSpace(out)
+	}
+
+	if c := revParse(ref); c != "" {
+		return c, nil
+	}
+
+	name := strings.TrimPrefix(strings.TrimPrefix(ref, "refs/remotes/"), r.remote+"/")
+	r.logger.Info("ref not found, fetching it", "ref", ref, "remote", r.remote)
+	if err := r.git().Run(ctx, "fetch", "-q", r.remote, name); err != nil {
+		if ctx.Err() != nil {
+			return "", errInterrupted
+		}
+
+		r.logger.Warn("could not fetch ref", "ref", name, "error", err)
+	}
+
+	for _, ref := range []string{ref, r.remote + "/" + name, "FETCH_HEAD"} {
+		if c := revParse(ref); c != "" {
+			return c, nil
+		}
+	}
+
+	return "", failf(ExitPrecondition, "ref %s not found, even after fetching it from %s", ref, r.remote)
+}
+
+// refBranch returns the name of the branch that ref, given with
+// -from-ref, refers to on the remote, to use as the PR base, or an
+// empty string if it isn't a branch there.
+func (r *run) refBranch(ctx context.Context, ref string) string {
+	name := strings.TrimPrefix(ref, "refs/heads/")
+	name = strings.TrimPrefix(strings.TrimPrefix(name, "refs/remotes/"), r.remote+"/")
+	if !r.git().RefExists(ctx, "refs/remotes/"+r.remote+"/"+name) {
+		return ""
+	}
+
+	return name
+}
+
+// selectRemote picks the remote to use when there is no origin:
+// upstream if there is one, or otherwise the only remote other than
+// fork, the fork remote. If there are several, one must be chosen with
+// -remote, unless required is false, in which case origin is kept and
+// anything using it fails as it would have.
+func (r *run) selectRemote(ctx context.Context, fork string, required bool) error {
+	remotes, err := r.git().Remotes(ctx)
+	if err != nil {
+		return err
+	}
+
+	var candidates []string
+	for _, rem := range remotes {
+		if rem == r.remote {
+			return nil
+		}
+	}
+
+	for _, rem := range remotes {
+		if rem == fallbackRemote {
+			candidates = []string{rem}
+			break
+		}
+
+		if rem != fork {
+			candidates = append(candidates, rem)
+		}
+	}
+
+	switch len(candidates) {
+	case 0:
+		if required {
+			return failf(ExitPrecondition, "there is no remote named %s, nor any other to use instead", r.remote)
+		}
+
+	case 1:
+		r.logger.Info("there is no remote named "+r.remote+", using another", "remote", candidates[0])
+		r.remote = candidates[0]
+
+	default:
+		if required {
+			return failf(ExitPrecondition, "there is no remote named %s, and several others (%s); choose one with -remote", r.remote, strings.Join(candidates, ", "))
+		}
+	}
+
+	return nil
+}
+
+// gitHubRepo returns the owner and name of the GitHub repository of
+// remote, such as the fork remote, which pull requests are opened from.
+// hostAliases maps remote hosts as for origin.
+func (r *run) gitHubRepo(ctx context.Context, remote string, hostAliases map[string]string) (string, string, error) {
+	out, err := r.git().RemoteURL(ctx, remote)
+	if err != nil {
+		return "", "", err
+	}
+
+	rem, err := gitremote.Parse(out)
+	if err != nil {
+		return "", "", fmt.Errorf("error parsing URL of remote %s: %w", remote, err)
+	}
+
+	if alias, ok := hostAliases[rem.Host]; ok {
+		rem.Host = alias
+	}
+
+	if rem.Host != "github.com" || rem.Owner == "" {
+		return "", "", failf(ExitPrecondition, "remote %s must be a GitHub repository in OWNER/REPO format", remote)
+	}
+
+	return rem.Owner, rem.Repo, nil
+}
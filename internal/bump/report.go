@@ -0,0 +1,369 @@
+package bump
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/vancluever/depbump/internal/forge"
+)
+
+// apiTimeout is the timeout for API requests made while gathering
+// information, which are skipped on failure.
+const apiTimeout = 10 * time.Second
+
+// httpClient returns a copy of the run's client, with timeout, or no
+// timeout if it is zero.
+func (r *run) httpClient(timeout time.Duration) *http.Client {
+	c := *r.client
+	c.Timeout = timeout
+	return &c
+}
+
+// configureTLS sets up the run's client to also trust the PEM
+// certificates in caFile, if set, and to skip verifying certificates
+// entirely if insecure is set.
+func (r *run) configureTLS(caFile string, insecure bool) error {
+	r.client = &http.Client{Transport: http.DefaultTransport}
+	if caFile == "" && !insecure {
+		return nil
+	}
+
+	config := &tls.Config{InsecureSkipVerify: insecure}
+	if caFile != "" {
+		pem, err := ioutil.ReadFile(r.path(caFile))
+		if err != nil {
+			return fmt.Errorf("error reading CA file: %w", err)
+		}
+
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in CA file %s", caFile)
+		}
+
+		config.RootCAs = pool
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = config
+	r.client = &http.Client{Transport: transport}
+	return nil
+}
+
+// actionsOutputKeys are the outputs written for GitHub Actions, in the
+// order they are written.
+var actionsOutputKeys = []string{"pr-url", "pr-number", "branch", "module", "old-version", "new-version", "status"}
+
+// setOutput sets the output key to value.
+func (r *run) setOutput(key, value string) {
+	r.outputs[key] = value
+}
+
+// writeActionsOutputs appends the outputs set during the run to the
+// file named by GITHUB_OUTPUT, if it is set, for later steps in a
+// GitHub Actions job. Only the keys in actionsOutputKeys are written.
+func (r *run) writeActionsOutputs() {
+	name := os.Getenv("GITHUB_OUTPUT")
+	if r.opts.NoActionsOutput || name == "" {
+		return
+	}
+
+	b := new(strings.Builder)
+	for _, k := range actionsOutputKeys {
+		if v, ok := r.outputs[k]; ok {
+			fmt.Fprintf(b, "%s=%s\n", k, v)
+		}
+	}
+
+	f, err := os.OpenFile(name, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err == nil {
+		_, err = f.WriteString(b.String())
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+
+	if err != nil {
+		r.logger.Warn("could not write GitHub Actions outputs", "error", err)
+	}
+}
+
+// runSummary is the result of the run, as written to the summary file
+// and posted to the webhook.
+type runSummary struct {
+	Module     string   `json:"module,omitempty"`
+	OldVersion string   `json:"old_version,omitempty"`
+	NewVersion string   `json:"new_version,omitempty"`
+	Branch     string   `json:"branch,omitempty"`
+	CommitSHA  string   `json:"commit_sha,omitempty"`
+	RemoteRef  string   `json:"remote_ref,omitempty"`
+	PRURL      string   `json:"pr_url,omitempty"`
+	Status     string   `json:"status"`
+	Error      string   `json:"error,omitempty"`
+	Duration   float64  `json:"duration_seconds"`
+	Phases     []*phase `json:"phases,omitempty"`
+}
+
+// phase is a stage of the run, timed for the summary.
+type phase struct {
+	Name     string  `json:"name"`
+	Duration float64 `json:"duration_seconds"`
+
+	start, end time.Time
+}
+
+// startPhase ends the current phase, if there is one, and starts the
+// phase name.
+func (r *run) startPhase(name string) {
+	r.endPhase()
+	r.phases = append(r.phases, &phase{Name: name, start: time.Now()})
+}
+
+// endPhase ends the current phase, if there is one.
+func (r *run) endPhase() {
+	if n := len(r.phases); n > 0 && r.phases[n-1].end.IsZero() {
+		p := r.phases[n-1]
+		p.end = time.Now()
+		p.Duration = p.end.Sub(p.start).Round(time.Millisecond).Seconds()
+	}
+}
+
+// summary returns the summary of the run so far, from the outputs set
+// and the phases started.
+func (r *run) summary() runSummary {
+	r.endPhase()
+	res := r.result()
+	return runSummary{
+		Module:     res.Module,
+		OldVersion: res.OldVersion,
+		NewVersion: res.NewVersion,
+		Branch:     res.Branch,
+		CommitSHA:  res.CommitSHA,
+		RemoteRef:  res.RemoteRef,
+		PRURL:      res.PRURL,
+		Status:     res.Status,
+		Error:      r.runError,
+		Duration:   time.Since(r.start).Round(time.Millisecond).Seconds(),
+		Phases:     r.phases,
+	}
+}
+
+// writeSummaryFile writes the summary of the run to the summary file
+// as JSON, if there is one. As with the other outputs, failures are
+// only warned about.
+func (r *run) writeSummaryFile() {
+	if r.opts.SummaryFile == "" {
+		return
+	}
+
+	b, err := json.MarshalIndent(r.summary(), "", "  ")
+	if err == nil {
+		err = ioutil.WriteFile(r.path(r.opts.SummaryFile), append(b, '\n'), 0o644)
+	}
+
+	if err != nil {
+		r.logger.Warn("could not write summary file", "file", r.opts.SummaryFile, "error", err)
+	}
+}
+
+// postWebhook posts the result of the run to the webhook, if there is
+// one. If a secret is set, the body is signed with HMAC-SHA256 in the
+// X-Depbump-Signature header. Failures are only warned about, since
+// they don't change the result of the run.
+func (r *run) postWebhook(ctx context.Context) {
+	if r.opts.WebhookURL == "" {
+		return
+	}
+
+	body, err := json.Marshal(r.summary())
+	if err != nil {
+		r.logger.Warn("could not post to webhook", "error", err)
+		return
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", r.opts.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		r.logger.Warn("could not post to webhook", "error", withoutURL(err))
+		return
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if r.opts.WebhookSecret != "" {
+		mac := hmac.New(sha256.New, []byte(r.opts.WebhookSecret))
+		mac.Write(body)
+		req.Header.Set("X-Depbump-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := r.httpClient(apiTimeout).Do(req)
+	if err != nil {
+		r.logger.Warn("could not post to webhook", "error", withoutURL(err))
+		return
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		r.logger.Warn("could not post to webhook", "status", resp.Status)
+	}
+}
+
+// Slack attachment colors for created pull requests and failures.
+const (
+	slackColorPR      = "#2eb67d"
+	slackColorFailure = "#e01e5a"
+)
+
+// maxSlackText is the longest text allowed in a Slack section block.
+const maxSlackText = 3000
+
+// slackBlock is a Block Kit block, and slackText a text object.
+type slackBlock struct {
+	Type   string      `json:"type"`
+	Text   *slackText  `json:"text,omitempty"`
+	Fields []slackText `json:"fields,omitempty"`
+}
+
+type slackText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// slackMessage is a Slack message, with its blocks in a colored
+// attachment.
+type slackMessage struct {
+	Text        string            `json:"text"`
+	Attachments []slackAttachment `json:"attachments"`
+}
+
+type slackAttachment struct {
+	Color  string       `json:"color"`
+	Blocks []slackBlock `json:"blocks"`
+}
+
+// newSlackMessage returns a message with fallback text, shown in
+// notifications, and blocks in an attachment of color.
+func newSlackMessage(text, color string, blocks ...slackBlock) *slackMessage {
+	return &slackMessage{
+		Text:        text,
+		Attachments: []slackAttachment{{Color: color, Blocks: blocks}},
+	}
+}
+
+// slackSection returns a section block with mrkdwn text and fields,
+// given as alternating labels and values. Fields with empty values
+// are left out.
+func slackSection(text string, fields ...string) slackBlock {
+	b := slackBlock{Type: "section"}
+	if text != "" {
+		if len(text) > maxSlackText {
+			cut := maxSlackText - 3
+			for cut > 0 && !utf8.RuneStart(text[cut]) {
+				cut--
+			}
+
+			text = text[:cut] + "..."
+		}
+
+		b.Text = &slackText{Type: "mrkdwn", Text: text}
+	}
+
+	for i := 0; i+1 < len(fields); i += 2 {
+		if fields[i+1] != "" {
+			b.Fields = append(b.Fields, slackText{Type: "mrkdwn", Text: "*" + fields[i] + "*\n" + fields[i+1]})
+		}
+	}
+
+	return b
+}
+
+// slackPRMessage returns the message for a created pull request.
+func (r *run) slackPRMessage(prURL string) *slackMessage {
+	module := r.outputs["module"]
+	text := fmt.Sprintf("Pull request opened for %s", module)
+	return newSlackMessage(text, slackColorPR, slackSection(
+		fmt.Sprintf("*<%s|%s>*", prURL, text),
+		"Repository", r.runRepo,
+		"From", r.outputs["old-version"],
+		"To", r.outputs["new-version"],
+	))
+}
+
+// slackFailureMessage returns the message for a failed run, with the
+// first line of the error that ended it as the summary.
+func (r *run) slackFailureMessage() *slackMessage {
+	summary, _, _ := strings.Cut(r.runError, "\n")
+	if summary == "" {
+		summary = r.outputs["status"]
+	}
+
+	text := "depbump failed"
+	if m := r.outputs["module"]; m != "" {
+		text += " updating " + m
+	}
+
+	return newSlackMessage(text, slackColorFailure, slackSection(
+		fmt.Sprintf("*%s*\n```%s```", text, summary),
+		"Repository", r.runRepo,
+		"Branch", r.outputs["branch"],
+	))
+}
+
+// notifySlack posts m to the Slack webhook, if there is one. Failures
+// are only warned about.
+func (r *run) notifySlack(ctx context.Context, m *slackMessage) {
+	if r.opts.SlackWebhook == "" {
+		return
+	}
+
+	body, err := json.Marshal(m)
+	if err != nil {
+		r.logger.Warn("could not notify Slack", "error", err)
+		return
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", r.opts.SlackWebhook, bytes.NewReader(body))
+	if err != nil {
+		r.logger.Warn("could not notify Slack", "error", withoutURL(err))
+		return
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := forge.Do(ctx, r.httpClient(apiTimeout), r.logger, req)
+	if err != nil {
+		r.logger.Warn("could not notify Slack", "error", withoutURL(err))
+		return
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		r.logger.Warn("could not notify Slack", "status", resp.Status)
+	}
+}
+
+// withoutURL returns err without the URL that a *url.Error carries, for
+// logging errors from requests to webhooks, whose URLs are secrets.
+func withoutURL(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return errors.Unwrap(urlErr)
+	}
+
+	return err
+}
@@ -0,0 +1,109 @@
+package bump
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSlackSectionTruncated(t *testing.T) {
+	cases := []struct {
+		name string
+		text string
+	}{
+		{name: "ASCII", text: strings.Repeat("a", maxSlackText+10)},
+		{name: "rune across the cut", text: strings.Repeat("a", maxSlackText-4) + strings.Repeat("é", 10)},
+		{name: "runes only", text: strings.Repeat("日本", maxSlackText)},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := slackSection(tc.text).Text.Text
+			if len(got) > maxSlackText {
+				t.Fatalf("expected at most %d bytes, got %d", maxSlackText, len(got))
+			}
+
+			if !utf8.ValidString(got) {
+				t.Fatalf("expected valid UTF-8, got %q", got[len(got)-10:])
+			}
+
+			if !strings.HasSuffix(got, "...") || !strings.HasPrefix(tc.text, strings.TrimSuffix(got, "...")) {
+				t.Fatalf("expected a prefix of the text followed by ..., got %q", got[len(got)-10:])
+			}
+		})
+	}
+}
+
+// TestWebhookURLsNotLogged checks that the webhook URLs, which are
+// secrets, stay out of the logs when posting to them fails or is
+// retried.
+func TestWebhookURLsNotLogged(t *testing.T) {
+	const secret = "/services/T0000/B0000/XXXXSECRET"
+
+	retried := false
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !retried {
+			retried = true
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer s.Close()
+
+	// Nothing listens on a closed server's address, so requests to it
+	// fail in the transport.
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	cases := []struct {
+		name string
+		opts Options
+		send func(r *run)
+	}{
+		{
+			name: "Slack, retried",
+			opts: Options{SlackWebhook: s.URL + secret},
+			send: func(r *run) { r.notifySlack(context.Background(), r.slackFailureMessage()) },
+		},
+		{
+			name: "Slack, connection failed",
+			opts: Options{SlackWebhook: closed.URL + secret},
+			send: func(r *run) { r.notifySlack(context.Background(), r.slackFailureMessage()) },
+		},
+		{
+			name: "webhook, connection failed",
+			opts: Options{WebhookURL: closed.URL + secret},
+			send: func(r *run) { r.postWebhook(context.Background()) },
+		},
+		{
+			name: "Slack, invalid URL",
+			opts: Options{SlackWebhook: "http://%zz" + secret},
+			send: func(r *run) { r.notifySlack(context.Background(), r.slackFailureMessage()) },
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var logs bytes.Buffer
+			r := newTestRun(tc.opts, t.TempDir(), &fakeRunner{}, nil)
+			r.logger = slog.New(slog.NewTextHandler(&logs, nil))
+			tc.send(r)
+
+			if !strings.Contains(logs.String(), "level=WARN") {
+				t.Fatalf("expected a warning, got %q", logs.String())
+			}
+
+			if strings.Contains(logs.String(), "SECRET") {
+				t.Fatalf("expected the webhook URL not to be logged, got %q", logs.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,633 @@
+package bump
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/vancluever/depbump/internal/modinfo"
+	"golang.org/x/mod/module"
+)
+
+type commitTemplateData struct {
+	Project    string
+	Owner      string
+	Version    string
+	OldVersion string
+	Target     string
+	Path       string
+	URL        string
+	CompareURL string
+	Vendor     bool
+
+	// Commit is the abbreviated commit hash requested with -commit.
+	Commit string
+
+	// Query is the branch or commit given with -version, when it
+	// resolved to a pseudo-version, which is PseudoVersion. Version is
+	// the abbreviated commit hash in that case.
+	Query         string
+	PseudoVersion string
+
+	// FromVersion is OldVersion as shown in the commit message: without
+	// its "v" (or +incompatible) for releases, and as the abbreviated
+	// commit hash for pseudo-versions.
+	FromVersion string
+
+	// PRURL is the URL of the pull request, set only for post-pr hooks.
+	PRURL string
+
+	// Prefix is the prefix of the commit subject, without its colon:
+	// "modules", or "build" when updating Go, unless -commit-prefix is
+	// given. The subject has no prefix if it is empty.
+	Prefix string
+
+	// Date is when the run started, or the time in SOURCE_DATE_EPOCH
+	// if it is set, so that rendering can be reproduced.
+	Date time.Time
+
+	// Branch is the name of the update branch, and BaseBranch the
+	// branch the pull request targets, or the branch checked out
+	// before the update if no pull request is being created.
+	Branch     string
+	BaseBranch string
+
+	// Replacement describes a replace directive for the module, and
+	// ReplacementVersion is the version it was updated to, if it was.
+	Replacement        string
+	ReplacementVersion string
+
+	// Tools lists the tool packages provided by the module, if it is a
+	// tool dependency, and ToolTargets the arguments given to
+	// "go get -tool" to update them.
+	Tools       []string
+	ToolTargets []string
+
+	// GoVersion and Toolchain are the new go and toolchain directives,
+	// when updating the Go version itself. OldToolchain is the
+	// previous toolchain directive.
+	GoVersion    string
+	Toolchain    string
+	OldToolchain string
+
+	// Modules lists the modules that were updated, when updating more
+	// than one module in a single run. Workspace is set when the
+	// modules are part of a go.work workspace.
+	Modules   []*moduleUpdate
+	Workspace bool
+
+	// Downgrades lists the other requirements that the update moved to
+	// an older version, each with Path, OldVersion, and NewVersion
+	// fields. These are left out of SideEffects.
+	Downgrades []requirementChange
+
+	// Skipped lists the newer versions that were not updated to as
+	// they were given with -skip-version, each with Path, Version, and
+	// Reason fields, newest first.
+	Skipped []versionSkip
+
+	// SideEffects lists the other requirements that were changed by
+	// the update, sorted by path.
+	SideEffects []requirementChange
+
+	// ReleaseNotes is the body of the GitHub release for the new
+	// version, or the message of its tag if there is no release. It is
+	// only fetched when a pull request is being created.
+	ReleaseNotes string
+
+	// Deprecated is the deprecation message of the module, if it is
+	// deprecated, and DeprecatedReplacement the module it suggests
+	// using instead, if one could be found in the message.
+	Deprecated            string
+	DeprecatedReplacement string
+
+	// GoRequirement is the go directive in the new version's go.mod,
+	// which is the minimum Go version it needs.
+	GoRequirement string
+
+	// Verified is set if "go mod verify" was run, and passed.
+	Verified bool
+
+	// VulnChecked is set if govulncheck was run before and after the
+	// update. VulnFixed and VulnIntroduced list the IDs of the
+	// vulnerabilities that the update fixed, and introduced.
+	VulnChecked    bool
+	VulnFixed      []string
+	VulnIntroduced []string
+
+	// OldLicense and NewLicense describe the module's license before
+	// and after the update. They are only set if the license changed.
+	OldLicense string
+	NewLicense string
+
+	// Group lists the version changes of each module in the group,
+	// when updating a group of modules with -group. Path is the group
+	// prefix in this case.
+	Group []requirementChange
+}
+
+// requirementChange is a change to a requirement other than the one
+// being updated. OldVersion is empty if the requirement was added, and
+// NewVersion is empty if it was removed. Tool is set for members of a
+// group that are tool dependencies.
+type requirementChange struct {
+	Path       string
+	OldVersion string
+	NewVersion string
+	Tool       bool
+}
+
+// maxCommitSideEffects is the number of side effect changes above
+// which the commit message only reports a count. The PR body always
+// lists them in full.
+const maxCommitSideEffects = 50
+
+// moduleUpdate tracks the update of the target path in a single
+// module.
+type moduleUpdate struct {
+	Dir        string
+	OldVersion string
+	NewVersion string
+
+	// dir is the directory to run commands in, and replace is the
+	// replace directive being bumped alongside the module, if any.
+	// tools lists the tool packages the module provides here, and
+	// requires and goVersion the module's requirements and go
+	// directive before the update.
+	dir       string
+	replace   *modinfo.Replacement
+	tools     []string
+	requires  map[string]string
+	goVersion string
+}
+
+// templateFuncs are the functions available to every template, for
+// small transformations of the template data. Each takes the value to
+// transform last, so that it can be piped in.
+var templateFuncs = template.FuncMap{
+	"trimPrefix": func(prefix, s string) string { return strings.TrimPrefix(s, prefix) },
+	"trimSuffix": func(suffix, s string) string { return strings.TrimSuffix(s, suffix) },
+	"replace":    func(old, new, s string) string { return strings.ReplaceAll(s, old, new) },
+	"upper":      strings.ToUpper,
+	"lower":      strings.ToLower,
+	"shortHash":  shortHash,
+}
+
+// shortHash returns the 12 character commit hash of a pseudo-version,
+// or an empty string if version isn't one. Pseudo-versions are
+// recognized by their canonical form (vX.Y.Z-yyyymmddhhmmss-abcdefabcdef,
+// and the variants for pre-release bases), not just by having a dash.
+func shortHash(version string) string {
+	if !module.IsPseudoVersion(version) {
+		return ""
+	}
+
+	rev, err := module.PseudoVersionRev(version)
+	if err != nil {
+		return ""
+	}
+
+	return rev
+}
+
+var commitTemplate = template.Must(
+	template.New("commit-template").Funcs(templateFuncs).Parse(strings.TrimSpace(`
+{{with .Prefix}}{{.}}: {{end}}upgrade {{.Project}} to {{.Version}}
+
+This updates:
+  {{.Path}}
+
+From version {{.FromVersion}} to version {{.Version}}{{if .Commit}} (commit {{.Commit}}){{end}}
+{{- with .Query}}, tracking {{.}} (pseudo-version {{$.PseudoVersion}}){{end}}.
+{{if .Tools}}
+This is a build tool dependency, rather than a library. It provides the
+following tools:
+{{range .Tools}}  {{.}}
+{{end}}{{end}}
+{{- if .Modules}}
+In the following modules:
+{{range .Modules}}  {{.Dir}} ({{.OldVersion}} -> {{.NewVersion}})
+{{end}}{{end}}
+{{- if .Downgrades}}
+WARNING: downgraded as a side effect:
+{{range .Downgrades}}  {{.Path}} {{.OldVersion}} -> {{.NewVersion}}
+{{end}}{{end}}
+{{- if .SideEffects}}
+Also updated as a side effect:
+{{if gt (len .SideEffects) ` + fmt.Sprint(maxCommitSideEffects) + `}}  {{len .SideEffects}} other requirements changed, see go.mod for details.
+{{else}}{{range .SideEffects}}  {{.Path}} {{if .OldVersion}}{{.OldVersion}}{{else}}(none){{end}} -> {{if .NewVersion}}{{.NewVersion}}{{else}}(removed){{end}}
+{{end}}{{end}}{{end}}
+{{- if .Replacement}}
+WARNING: {{.Path}} is replaced in go.mod by {{.Replacement}}.
+{{- if .ReplacementVersion}}
+The replacement has also been updated to {{.ReplacementVersion}}.
+{{- else}}
+The replacement was not changed, so builds will continue to use it.
+{{- end}}
+{{end}}
+Executed via:
+
+{{if .ToolTargets}}{{range .ToolTargets}}  go get -tool {{.}}
+{{end}}{{else}}  go get {{.Target}}
+{{end}}  go mod tidy
+{{if .Workspace}}  go work sync
+{{end}}
+{{- if .Vendor}}  go {{if .Workspace}}work{{else}}mod{{end}} vendor
+{{end}}
+For details on changes, see the project's release page.
+{{if .URL }}  {{.URL}}{{- end}}
+{{if .CompareURL}}
+To compare against the previous version, see:
+  {{.CompareURL}}
+{{end}}
+This commit message was auto-generated.
+`),
+	))
+
+// prBodyTemplate is the default template for the pull request body.
+// It carries the same information as the commit message body, but
+// formatted for display as markdown.
+var prBodyTemplate = template.Must(
+	template.New("pr-body-template").Funcs(templateFuncs).Parse(strings.TrimSpace(`
+This updates ` + "`{{.Path}}`" + ` from version {{.OldVersion}} to version {{.Version}}{{if .Commit}} (commit {{.Commit}}){{end}}
+{{- with .Query}}, tracking ` + "`{{.}}`" + ` (pseudo-version {{$.PseudoVersion}}){{end}}.
+
+{{if .Tools}}This is a build tool dependency, rather than a library. It provides:
+
+{{range .Tools}}* ` + "`{{.}}`" + `
+{{end}}
+{{end}}{{if .Modules}}In the following modules:
+
+{{range .Modules}}* ` + "`{{.Dir}}`" + ` ({{.OldVersion}} → {{.NewVersion}})
+{{end}}
+{{end}}{{if .NewLicense}}**Warning: license change detected.** The license of ` + "`{{.Path}}`" + ` changed from {{.OldLicense}} to {{.NewLicense}}.
+
+{{end}}{{if .Deprecated}}**Warning:** ` + "`{{.Path}}`" + ` is deprecated: {{.Deprecated}}
+{{- if .DeprecatedReplacement}} The suggested replacement is ` + "`{{.DeprecatedReplacement}}`" + `.{{end}}
+
+{{end}}{{if .GoRequirement}}The new version requires Go {{.GoRequirement}} or later.
+
+{{end}}{{if .VulnChecked}}Vulnerability impact:
+
+{{range .VulnFixed}}* Fixes [{{.}}](https://pkg.go.dev/vuln/{{.}})
+{{end}}{{range .VulnIntroduced}}* **Introduces** [{{.}}](https://pkg.go.dev/vuln/{{.}})
+{{end}}{{if not (or .VulnFixed .VulnIntroduced)}}* No change in known vulnerabilities.
+{{end}}
+{{end}}{{if .Skipped}}Newer versions were deliberately skipped:
+
+{{range .Skipped}}* {{.Version}}{{with .Reason}}: {{.}}{{end}}
+{{end}}
+{{end}}{{if .Downgrades}}**Downgraded** as a side effect:
+
+{{range .Downgrades}}* ` + "`{{.Path}}`" + ` {{.OldVersion}} → {{.NewVersion}}
+{{end}}
+{{end}}{{if .SideEffects}}Also updated as a side effect:
+
+{{range .SideEffects}}* ` + "`{{.Path}}`" + ` {{if .OldVersion}}{{.OldVersion}}{{else}}(none){{end}} → {{if .NewVersion}}{{.NewVersion}}{{else}}(removed){{end}}
+{{end}}
+{{end}}{{if .Replacement}}**Warning:** ` + "`{{.Path}}`" + ` is replaced in go.mod by ` + "`{{.Replacement}}`" + `.
+{{- if .ReplacementVersion}} The replacement has also been updated to {{.ReplacementVersion}}.
+{{- else}} The replacement was not changed, so builds will continue to use it.
+{{- end}}
+
+{{end}}Executed via:
+
+` + "```" + `
+{{if .ToolTargets}}{{range .ToolTargets}}go get -tool {{.}}
+{{end}}{{else}}go get {{.Target}}
+{{end}}go mod tidy
+{{if .Workspace}}go work sync
+{{end}}{{if .Vendor}}go {{if .Workspace}}work{{else}}mod{{end}} vendor
+{{end}}` + "```" + `
+
+{{if .Verified}}All modules verified against go.sum.
+
+{{end}}{{if .URL}}For details on changes, see the project's [release page]({{.URL}}).
+
+{{end}}{{if .CompareURL}}[Compare changes against the previous version.]({{.CompareURL}})
+
+{{end}}{{if .ReleaseNotes}}<details>
+<summary>Release notes</summary>
+
+{{.ReleaseNotes}}
+
+</details>
+
+{{end}}This pull request was auto-generated.
+`),
+	))
+
+// goCommitTemplate is the commit template used when updating the go
+// and toolchain directives, rather than a module.
+var goCommitTemplate = template.Must(
+	template.New("go-commit-template").Funcs(templateFuncs).Parse(strings.TrimSpace(`
+{{with .Prefix}}{{.}}: {{end}}update Go toolchain to {{.Version}}
+
+This updates the Go version requirements in go.mod to:
+  go {{.GoVersion}}
+{{if .Toolchain}}  toolchain {{.Toolchain}}
+{{end}}
+Previously:
+  go {{.OldVersion}}
+{{if .OldToolchain}}  toolchain {{.OldToolchain}}
+{{end}}
+Executed via:
+
+  go mod edit {{.Target}}
+  go mod tidy
+{{if .Vendor}}  go mod vendor
+{{end}}
+For details on changes, see the release notes.
+  {{.URL}}
+
+This commit message was auto-generated.
+`),
+	))
+
+// goPRBodyTemplate is the default PR body template used when updating
+// the go and toolchain directives.
+var goPRBodyTemplate = template.Must(
+	template.New("go-pr-body-template").Funcs(templateFuncs).Parse(strings.TrimSpace(`
+This updates the Go version requirements in go.mod:
+
+* ` + "`go`" + `: {{.OldVersion}} → {{.GoVersion}}
+{{if .Toolchain}}* ` + "`toolchain`" + `: {{if .OldToolchain}}{{.OldToolchain}}{{else}}(none){{end}} → {{.Toolchain}}
+{{end}}
+Executed via:
+
+` + "```" + `
+go mod edit {{.Target}}
+go mod tidy
+{{if .Vendor}}go mod vendor
+{{end}}` + "```" + `
+
+{{if .Verified}}All modules verified against go.sum.
+
+{{end}}For details on changes, see the [release notes]({{.URL}}).
+
+This pull request was auto-generated.
+`),
+	))
+
+// tidyCommitTemplate is the commit template used when committing
+// module metadata drift with "depbump tidy".
+var tidyCommitTemplate = template.Must(
+	template.New("tidy-commit-template").Funcs(templateFuncs).Parse(strings.TrimSpace(`
+{{with .Prefix}}{{.}}: {{end}}tidy module metadata
+
+This updates go.mod and go.sum{{if .Vendor}}, and the vendor directory,{{end}} to
+match the current dependency graph, without changing any requirements.
+{{if .Modules}}
+In the following modules:
+{{range .Modules}}  {{.Dir}}
+{{end}}{{end}}
+Executed via:
+
+  go mod tidy
+{{if .Workspace}}  go work sync
+{{end}}
+{{- if .Vendor}}  go {{if .Workspace}}work{{else}}mod{{end}} vendor
+{{end}}
+This commit message was auto-generated.
+`),
+	))
+
+// tidyPRBodyTemplate is the default PR body template used with
+// "depbump tidy".
+var tidyPRBodyTemplate = template.Must(
+	template.New("tidy-pr-body-template").Funcs(templateFuncs).Parse(strings.TrimSpace(`
+This updates go.mod and go.sum{{if .Vendor}}, and the vendor directory,{{end}} to match the current dependency graph, without changing any requirements.
+
+{{if .Modules}}In the following modules:
+
+{{range .Modules}}* ` + "`{{.Dir}}`" + `
+{{end}}
+{{end}}Executed via:
+
+` + "```" + `
+go mod tidy
+{{if .Workspace}}go work sync
+{{end}}{{if .Vendor}}go {{if .Workspace}}work{{else}}mod{{end}} vendor
+{{end}}` + "```" + `
+
+{{if .Verified}}All modules verified against go.sum.
+
+{{end}}This pull request was auto-generated.
+`),
+	))
+
+// groupCommitTemplate is the commit template used when updating a
+// group of modules with -group.
+var groupCommitTemplate = template.Must(
+	template.New("group-commit-template").Funcs(templateFuncs).Parse(strings.TrimSpace(`
+{{with .Prefix}}{{.}}: {{end}}upgrade {{.Project}} module group
+
+This updates the modules matching:
+  {{.Path}}
+
+To their latest versions:
+{{range .Group}}  {{.Path}}{{if .Tool}} (tool){{end}} {{if .OldVersion}}{{.OldVersion}}{{else}}(none){{end}} -> {{if .NewVersion}}{{.NewVersion}}{{else}}(removed){{end}}
+{{end}}
+{{- if .Downgrades}}
+WARNING: downgraded as a side effect:
+{{range .Downgrades}}  {{.Path}} {{.OldVersion}} -> {{.NewVersion}}
+{{end}}{{end}}
+{{- if .SideEffects}}
+Also updated as a side effect:
+{{if gt (len .SideEffects) ` + fmt.Sprint(maxCommitSideEffects) + `}}  {{len .SideEffects}} other requirements changed, see go.mod for details.
+{{else}}{{range .SideEffects}}  {{.Path}} {{if .OldVersion}}{{.OldVersion}}{{else}}(none){{end}} -> {{if .NewVersion}}{{.NewVersion}}{{else}}(removed){{end}}
+{{end}}{{end}}{{end}}
+Executed via:
+
+  go get {{.Target}}
+  go mod tidy
+{{if .Vendor}}  go mod vendor
+{{end}}
+This commit message was auto-generated.
+`),
+	))
+
+// groupPRBodyTemplate is the default PR body template used when
+// updating a group of modules with -group.
+var groupPRBodyTemplate = template.Must(
+	template.New("group-pr-body-template").Funcs(templateFuncs).Parse(strings.TrimSpace(`
+This updates the modules matching ` + "`{{.Path}}`" + ` to their latest versions:
+
+{{range .Group}}* ` + "`{{.Path}}`" + `{{if .Tool}} (tool){{end}} {{if .OldVersion}}{{.OldVersion}}{{else}}(none){{end}} → {{if .NewVersion}}{{.NewVersion}}{{else}}(removed){{end}}
+{{end}}
+{{if .Downgrades}}**Downgraded** as a side effect:
+
+{{range .Downgrades}}* ` + "`{{.Path}}`" + ` {{.OldVersion}} → {{.NewVersion}}
+{{end}}
+{{end}}{{if .SideEffects}}Also updated as a side effect:
+
+{{range .SideEffects}}* ` + "`{{.Path}}`" + ` {{if .OldVersion}}{{.OldVersion}}{{else}}(none){{end}} → {{if .NewVersion}}{{.NewVersion}}{{else}}(removed){{end}}
+{{end}}
+{{end}}Executed via:
+
+` + "```" + `
+go get {{.Target}}
+go mod tidy
+{{if .Vendor}}go mod vendor
+{{end}}` + "```" + `
+
+{{if .Verified}}All modules verified against go.sum.
+
+{{end}}This pull request was auto-generated.
+`),
+	))
+
+// listCommitTemplate is the commit template used when updating the
+// modules in a -from-file list together, with -group-pr.
+var listCommitTemplate = template.Must(
+	template.New("list-commit-template").Funcs(templateFuncs).Parse(strings.TrimSpace(`
+{{with .Prefix}}{{.}}: {{end}}upgrade {{len .Group}} {{if eq (len .Group) 1}}dependency{{else}}dependencies{{end}}
+
+This updates:
+{{range .Group}}  {{.Path}}{{if .Tool}} (tool){{end}} {{if .OldVersion}}{{.OldVersion}}{{else}}(none){{end}} -> {{if .NewVersion}}{{.NewVersion}}{{else}}(removed){{end}}
+{{end}}
+{{- if .Downgrades}}
+WARNING: downgraded as a side effect:
+{{range .Downgrades}}  {{.Path}} {{.OldVersion}} -> {{.NewVersion}}
+{{end}}{{end}}
+{{- if .SideEffects}}
+Also updated as a side effect:
+{{if gt (len .SideEffects) ` + fmt.Sprint(maxCommitSideEffects) + `}}  {{len .SideEffects}} other requirements changed, see go.mod for details.
+{{else}}{{range .SideEffects}}  {{.Path}} {{if .OldVersion}}{{.OldVersion}}{{else}}(none){{end}} -> {{if .NewVersion}}{{.NewVersion}}{{else}}(removed){{end}}
+{{end}}{{end}}{{end}}
+Executed via:
+
+  go get {{.Target}}
+  go mod tidy
+{{if .Vendor}}  go mod vendor
+{{end}}
+This commit message was auto-generated.
+`),
+	))
+
+// listPRBodyTemplate is the default PR body template used when
+// updating the modules in a -from-file list together, with -group-pr.
+var listPRBodyTemplate = template.Must(
+	template.New("list-pr-body-template").Funcs(templateFuncs).Parse(strings.TrimSpace(`
+This updates:
+
+{{range .Group}}* ` + "`{{.Path}}`" + `{{if .Tool}} (tool){{end}} {{if .OldVersion}}{{.OldVersion}}{{else}}(none){{end}} → {{if .NewVersion}}{{.NewVersion}}{{else}}(removed){{end}}
+{{end}}
+{{if .Downgrades}}**Downgraded** as a side effect:
+
+{{range .Downgrades}}* ` + "`{{.Path}}`" + ` {{.OldVersion}} → {{.NewVersion}}
+{{end}}
+{{end}}{{if .SideEffects}}Also updated as a side effect:
+
+{{range .SideEffects}}* ` + "`{{.Path}}`" + ` {{if .OldVersion}}{{.OldVersion}}{{else}}(none){{end}} → {{if .NewVersion}}{{.NewVersion}}{{else}}(removed){{end}}
+{{end}}
+{{end}}Executed via:
+
+` + "```" + `
+go get {{.Target}}
+go mod tidy
+{{if .Vendor}}go mod vendor
+{{end}}` + "```" + `
+
+{{if .Verified}}All modules verified against go.sum.
+
+{{end}}This pull request was auto-generated.
+`),
+	))
+
+// renderPRTitle renders the PR title template t against data, checking
+// that the result is a single line, and not empty.
+func renderPRTitle(t *template.Template, data commitTemplateData) (string, error) {
+	b := new(strings.Builder)
+	if err := t.Execute(b, data); err != nil {
+		return "", err
+	}
+
+	title := strings.TrimSpace(b.String())
+	switch {
+	case title == "":
+		return "", fmt.Errorf("rendered title is empty")
+
+	case strings.ContainsAny(title, "\r\n"):
+		return "", fmt.Errorf("rendered title %q is more than one line", title)
+	}
+
+	return title, nil
+}
+
+// renderCommand templates each argument of the supplied command
+// against data. kind is used to describe the command in errors.
+func renderCommand(kind string, raw []string, data commitTemplateData) ([]string, error) {
+	cmd := make([]string, len(raw))
+	for i, c := range raw {
+		s := new(strings.Builder)
+		t, err := template.New("cmd").Funcs(templateFuncs).Parse(c)
+		if err != nil {
+			return nil, fmt.Errorf("error building %s command: %w", kind, err)
+		}
+
+		if err := t.Execute(s, data); err != nil {
+			return nil, fmt.Errorf("error building %s command: %w", kind, err)
+		}
+
+		cmd[i] = s.String()
+	}
+
+	return cmd, nil
+}
+
+// commandEnv returns the DEPBUMP_* environment variables for pre-,
+// post-update, and verify commands, with the same values the command
+// templates render for data.
+func commandEnv(data commitTemplateData) []string {
+	return []string{
+		"DEPBUMP_MODULE=" + data.Path,
+		"DEPBUMP_OLD_VERSION=" + data.OldVersion,
+		"DEPBUMP_NEW_VERSION=" + data.Version,
+		"DEPBUMP_BRANCH=" + data.Branch,
+		"DEPBUMP_TARGET=" + data.Target,
+		"DEPBUMP_VENDOR=" + strconv.FormatBool(data.Vendor),
+		"DEPBUMP_PR_URL=" + data.PRURL,
+	}
+}
+
+// runCommand runs cmd, one of the commands given in the options, with
+// the environment for data, in dir, or the run's directory if it is
+// empty.
+func (r *run) runCommand(ctx context.Context, cmd []string, data commitTemplateData, dir string) error {
+	return r.passthrough(ctx, command(dir, commandEnv(data), cmd[0], cmd[1:]...))
+}
+
+// runHooks runs the commands given for the hook named kind, in order,
+// stopping at the first that fails. They are templated, and get the
+// same environment, as post-update commands.
+func (r *run) runHooks(ctx context.Context, kind string, cmds [][]string, data commitTemplateData) error {
+	for _, raw := range cmds {
+		hookCmd, err := renderCommand(kind+" hook", raw, data)
+		if err != nil {
+			return err
+		}
+
+		r.logger.InfoContext(commandCtx, "running "+kind+" hook", "command", strings.Join(hookCmd, " "))
+		if err := r.runCommand(ctx, hookCmd, data, ""); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// versionRef returns the git ref that a module version refers to: the
+// tag for a release, or the commit hash for a pseudo-version. An empty
+// string is returned if the ref cannot be determined.
+func versionRef(version string) string {
+	if rev := shortHash(version); rev != "" {
+		return rev
+	}
+
+	tag, _ := releaseTag(version)
+	return tag
+}
@@ -0,0 +1,475 @@
+package bump
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/vancluever/depbump/internal/modrepo"
+)
+
+// testTemplateData returns template data with every field set, as for
+// an update of rsc.io/quote from v1.5.1 to v1.5.2.
+func testTemplateData() commitTemplateData {
+	return commitTemplateData{
+		Project:               "quote",
+		Owner:                 "rsc",
+		Version:               "1.5.2",
+		OldVersion:            "v1.5.1",
+		Target:                "rsc.io/quote@v1.5.2",
+		Path:                  "rsc.io/quote",
+		URL:                   "https://github.com/rsc/quote/tree/v1.5.2",
+		CompareURL:            "https://github.com/rsc/quote/compare/v1.5.1...v1.5.2",
+		Vendor:                true,
+		Commit:                "0123abc",
+		Query:                 "master",
+		PseudoVersion:         "v1.5.3-0.20240101000000-0123456789ab",
+		FromVersion:           "1.5.1",
+		PRURL:                 "https://github.com/owner/repo/pull/7",
+		Prefix:                "modules",
+		Date:                  time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		Branch:                "update-quote-v1.5.2",
+		BaseBranch:            "main",
+		Replacement:           "../quote",
+		ReplacementVersion:    "v1.5.2",
+		Tools:                 []string{"rsc.io/quote/cmd/quote"},
+		ToolTargets:           []string{"rsc.io/quote/cmd/quote@v1.5.2"},
+		GoVersion:             "1.22.0",
+		Toolchain:             "go1.22.5",
+		OldToolchain:          "go1.22.1",
+		Modules:               []*moduleUpdate{{Dir: "tools", OldVersion: "v1.5.0", NewVersion: "v1.5.2"}},
+		Workspace:             true,
+		Downgrades:            []requirementChange{{Path: "rsc.io/sampler", OldVersion: "v1.3.1", NewVersion: "v1.3.0"}},
+		Skipped:               []versionSkip{{Path: "rsc.io/quote", Version: "v1.5.3", Reason: "breaks the build"}},
+		SideEffects:           []requirementChange{{Path: "golang.org/x/text", OldVersion: "v0.3.0", NewVersion: "v0.3.7"}},
+		ReleaseNotes:          "Fixes the quote.",
+		Deprecated:            "use rsc.io/quote/v3",
+		DeprecatedReplacement: "rsc.io/quote/v3",
+		GoRequirement:         "1.21",
+		Verified:              true,
+		VulnChecked:           true,
+		VulnFixed:             []string{"GO-2024-0001"},
+		VulnIntroduced:        []string{"GO-2024-0002"},
+		OldLicense:            "MIT",
+		NewLicense:            "Apache-2.0",
+		Group:                 []requirementChange{{Path: "rsc.io/sampler", OldVersion: "v1.3.0", NewVersion: "v1.3.1", Tool: true}},
+	}
+}
+
+// TestTemplateFields renders each field of the template data, as a
+// post-update command would, so that each documented field is known to
+// be available under its name.
+func TestTemplateFields(t *testing.T) {
+	cases := []struct {
+		field    string
+		template string
+		want     string
+	}{
+		{field: "Project", template: "{{.Project}}", want: "quote"},
+		{field: "Owner", template: "{{.Owner}}", want: "rsc"},
+		{field: "Version", template: "{{.Version}}", want: "1.5.2"},
+		{field: "OldVersion", template: "{{.OldVersion}}", want: "v1.5.1"},
+		{field: "Target", template: "{{.Target}}", want: "rsc.io/quote@v1.5.2"},
+		{field: "Path", template: "{{.Path}}", want: "rsc.io/quote"},
+		{field: "URL", template: "{{.URL}}", want: "https://github.com/rsc/quote/tree/v1.5.2"},
+		{field: "CompareURL", template: "{{.CompareURL}}", want: "https://github.com/rsc/quote/compare/v1.5.1...v1.5.2"},
+		{field: "Vendor", template: "{{.Vendor}}", want: "true"},
+		{field: "Commit", template: "{{.Commit}}", want: "0123abc"},
+		{field: "Query", template: "{{.Query}}", want: "master"},
+		{field: "PseudoVersion", template: "{{.PseudoVersion}}", want: "v1.5.3-0.20240101000000-0123456789ab"},
+		{field: "FromVersion", template: "{{.FromVersion}}", want: "1.5.1"},
+		{field: "PRURL", template: "{{.PRURL}}", want: "https://github.com/owner/repo/pull/7"},
+		{field: "Prefix", template: "{{.Prefix}}", want: "modules"},
+		{field: "Date", template: `{{.Date.Format "2006-01-02"}}`, want: "2024-01-02"},
+		{field: "Branch", template: "{{.Branch}}", want: "update-quote-v1.5.2"},
+		{field: "BaseBranch", template: "{{.BaseBranch}}", want: "main"},
+		{field: "Replacement", template: "{{.Replacement}}", want: "../quote"},
+		{field: "ReplacementVersion", template: "{{.ReplacementVersion}}", want: "v1.5.2"},
+		{field: "Tools", template: "{{range .Tools}}{{.}}{{end}}", want: "rsc.io/quote/cmd/quote"},
+		{field: "ToolTargets", template: "{{range .ToolTargets}}{{.}}{{end}}", want: "rsc.io/quote/cmd/quote@v1.5.2"},
+		{field: "GoVersion", template: "{{.GoVersion}}", want: "1.22.0"},
+		{field: "Toolchain", template: "{{.Toolchain}}", want: "go1.22.5"},
+		{field: "OldToolchain", template: "{{.OldToolchain}}", want: "go1.22.1"},
+		{field: "Modules", template: "{{range .Modules}}{{.Dir}} {{.OldVersion}} {{.NewVersion}}{{end}}", want: "tools v1.5.0 v1.5.2"},
+		{field: "Workspace", template: "{{.Workspace}}", want: "true"},
+		{field: "Downgrades", template: "{{range .Downgrades}}{{.Path}} {{.OldVersion}} {{.NewVersion}}{{end}}", want: "rsc.io/sampler v1.3.1 v1.3.0"},
+		{field: "Skipped", template: "{{range .Skipped}}{{.Path}} {{.Version}} {{.Reason}}{{end}}", want: "rsc.io/quote v1.5.3 breaks the build"},
+		{field: "SideEffects", template: "{{range .SideEffects}}{{.Path}} {{.OldVersion}} {{.NewVersion}}{{end}}", want: "golang.org/x/text v0.3.0 v0.3.7"},
+		{field: "ReleaseNotes", template: "{{.ReleaseNotes}}", want: "Fixes the quote."},
+		{field: "Deprecated", template: "{{.Deprecated}}", want: "use rsc.io/quote/v3"},
+		{field: "DeprecatedReplacement", template: "{{.DeprecatedReplacement}}", want: "rsc.io/quote/v3"},
+		{field: "GoRequirement", template: "{{.GoRequirement}}", want: "1.21"},
+		{field: "Verified", template: "{{.Verified}}", want: "true"},
+		{field: "VulnChecked", template: "{{.VulnChecked}}", want: "true"},
+		{field: "VulnFixed", template: "{{range .VulnFixed}}{{.}}{{end}}", want: "GO-2024-0001"},
+		{field: "VulnIntroduced", template: "{{range .VulnIntroduced}}{{.}}{{end}}", want: "GO-2024-0002"},
+		{field: "OldLicense", template: "{{.OldLicense}}", want: "MIT"},
+		{field: "NewLicense", template: "{{.NewLicense}}", want: "Apache-2.0"},
+		{field: "Group", template: "{{range .Group}}{{.Path}} {{.OldVersion}} {{.NewVersion}} {{.Tool}}{{end}}", want: "rsc.io/sampler v1.3.0 v1.3.1 true"},
+	}
+
+	data := testTemplateData()
+	tested := make(map[string]bool)
+	for _, tc := range cases {
+		tested[tc.field] = true
+		t.Run(tc.field, func(t *testing.T) {
+			got, err := renderCommand("post-update", []string{"echo", tc.template}, data)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if want := []string{"echo", tc.want}; !reflect.DeepEqual(got, want) {
+				t.Fatalf("expected %q, got %q", want, got)
+			}
+		})
+	}
+
+	typ := reflect.TypeOf(data)
+	for i := 0; i < typ.NumField(); i++ {
+		if f := typ.Field(i); f.IsExported() && !tested[f.Name] {
+			t.Errorf("field %s is not tested", f.Name)
+		}
+	}
+}
+
+// TestCommitTemplateFields renders the default commit template with
+// every field set, checking that each field it shows is rendered, and
+// that the branch fields, which are for commands, are not.
+func TestCommitTemplateFields(t *testing.T) {
+	b := new(strings.Builder)
+	if err := commitTemplate.Execute(b, testTemplateData()); err != nil {
+		t.Fatal(err)
+	}
+
+	got := b.String()
+	for _, want := range []string{
+		"modules: upgrade quote to 1.5.2\n",
+		"This updates:\n  rsc.io/quote\n",
+		"From version 1.5.1 to version 1.5.2 (commit 0123abc), tracking master (pseudo-version v1.5.3-0.20240101000000-0123456789ab).\n",
+		"following tools:\n  rsc.io/quote/cmd/quote\n",
+		"In the following modules:\n  tools (v1.5.0 -> v1.5.2)\n",
+		"WARNING: downgraded as a side effect:\n  rsc.io/sampler v1.3.1 -> v1.3.0\n",
+		"Also updated as a side effect:\n  golang.org/x/text v0.3.0 -> v0.3.7\n",
+		"WARNING: rsc.io/quote is replaced in go.mod by ../quote.\nThe replacement has also been updated to v1.5.2.\n",
+		"  go get -tool rsc.io/quote/cmd/quote@v1.5.2\n  go mod tidy\n  go work sync\n  go work vendor\n",
+		"\n  https://github.com/rsc/quote/tree/v1.5.2\n",
+		"To compare against the previous version, see:\n  https://github.com/rsc/quote/compare/v1.5.1...v1.5.2\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("expected commit message to contain %q, got:\n%s", want, got)
+		}
+	}
+
+	for _, unwanted := range []string{"update-quote-v1.5.2", "main"} {
+		if strings.Contains(got, unwanted) {
+			t.Errorf("expected commit message not to contain %q, got:\n%s", unwanted, got)
+		}
+	}
+}
+
+func TestTemplateFuncs(t *testing.T) {
+	cases := []struct {
+		name     string
+		function string
+		template string
+		want     string
+	}{
+		{name: "trimPrefix", function: "trimPrefix", template: `{{trimPrefix "v" .OldVersion}}`, want: "1.5.1"},
+		{name: "trimPrefix piped", function: "trimPrefix", template: `{{.OldVersion | trimPrefix "v"}}`, want: "1.5.1"},
+		{name: "trimPrefix missing", function: "trimPrefix", template: `{{trimPrefix "x" .OldVersion}}`, want: "v1.5.1"},
+		{name: "trimSuffix", function: "trimSuffix", template: `{{trimSuffix "/v3" .DeprecatedReplacement}}`, want: "rsc.io/quote"},
+		{name: "replace", function: "replace", template: `{{.Path | replace "/" "-"}}`, want: "rsc.io-quote"},
+		{name: "upper", function: "upper", template: `{{upper .Project}}`, want: "QUOTE"},
+		{name: "lower", function: "lower", template: `{{.Target | upper | lower}}`, want: "rsc.io/quote@v1.5.2"},
+		{name: "shortHash", function: "shortHash", template: `{{shortHash .PseudoVersion}}`, want: "0123456789ab"},
+		{name: "shortHash release", function: "shortHash", template: `{{shortHash .OldVersion}}`, want: ""},
+		{name: "shortHash pre-release", function: "shortHash", template: `{{shortHash "v1.5.2-rc.1"}}`, want: ""},
+		{name: "shortHash pre-release base", function: "shortHash", template: `{{shortHash "v1.5.2-rc.1.0.20240101000000-0123456789ab"}}`, want: "0123456789ab"},
+	}
+
+	data := testTemplateData()
+	tested := make(map[string]bool)
+	for _, tc := range cases {
+		tested[tc.function] = true
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := renderCommand("post-update", []string{tc.template}, data)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got[0] != tc.want {
+				t.Fatalf("expected %q, got %q", tc.want, got[0])
+			}
+		})
+	}
+
+	for name := range templateFuncs {
+		if !tested[name] {
+			t.Errorf("function %s is not tested", name)
+		}
+	}
+}
+
+// TestTemplateFuncsEverywhere checks that the functions are available
+// in each kind of template: commands, and the PR title and body.
+func TestTemplateFuncsEverywhere(t *testing.T) {
+	const tmpl = `{{.Path | replace "/" "-" | upper}}`
+	const want = "RSC.IO-QUOTE"
+	data := testTemplateData()
+
+	cmd, err := renderCommand("post-update", []string{tmpl}, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cmd[0] != want {
+		t.Fatalf("expected command %q, got %q", want, cmd[0])
+	}
+
+	// The PR templates are parsed along with the rest of the options.
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "pr.tmpl"), []byte(tmpl), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	r := newTestRun(Options{PRTemplateFile: "pr.tmpl", PRTitleTemplate: tmpl}, dir, &fakeRunner{results: repoResults(dir, nil)}, nil)
+	parsed, err := r.parseOptions(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := renderPRTitle(parsed.prTitleTemplate, data); err != nil || got != want {
+		t.Fatalf("expected title %q, got %q (%v)", want, got, err)
+	}
+
+	b := new(strings.Builder)
+	if err := parsed.prTemplate.Execute(b, data); err != nil {
+		t.Fatal(err)
+	}
+
+	if b.String() != want {
+		t.Fatalf("expected body %q, got %q", want, b.String())
+	}
+}
+
+// TestTemplateEscaping checks that nothing is escaped for HTML, which
+// would mangle module paths, versions, and URLs with &, +, or <.
+func TestTemplateEscaping(t *testing.T) {
+	const (
+		path    = "example.com/a&b+c<d"
+		version = "2.0.0+incompatible<&"
+		url     = "https://example.com/a&b+c<d/tree/v2.0.0?x=1&y=2"
+	)
+
+	data := testTemplateData()
+	data.Path, data.Project, data.Version, data.OldVersion = path, "a&b+c<d", version, "v1.0.0+incompatible<&"
+	data.Target, data.ToolTargets = path+"@v"+version, nil
+	data.URL = url
+	data.Group = []requirementChange{{Path: path, OldVersion: data.OldVersion, NewVersion: "v" + version}}
+
+	render := func(t *testing.T, tmpl *template.Template) string {
+		b := new(strings.Builder)
+		if err := tmpl.Execute(b, data); err != nil {
+			t.Fatal(err)
+		}
+
+		return b.String()
+	}
+
+	cases := []struct {
+		name string
+		got  func(t *testing.T) string
+		want []string
+	}{
+		{
+			name: "commit",
+			got:  func(t *testing.T) string { return render(t, commitTemplate) },
+			want: []string{"upgrade a&b+c<d to " + version, "  " + path + "\n", "go get " + data.Target, "  " + url},
+		},
+		{
+			name: "PR body",
+			got:  func(t *testing.T) string { return render(t, prBodyTemplate) },
+			want: []string{"`" + path + "`", "to version " + version, "go get " + data.Target, "(" + url + ")"},
+		},
+		{
+			name: "group commit",
+			got:  func(t *testing.T) string { return render(t, groupCommitTemplate) },
+			want: []string{"  " + path + " v1.0.0+incompatible<& -> v" + version},
+		},
+		{
+			name: "command",
+			got: func(t *testing.T) string {
+				cmd, err := renderCommand("post-update", []string{"{{.Path}} {{.Version}} {{.URL}}"}, data)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				return cmd[0]
+			},
+			want: []string{path + " " + version + " " + url},
+		},
+		{
+			name: "PR title",
+			got: func(t *testing.T) string {
+				title, err := renderPRTitle(template.Must(template.New("title").Parse("upgrade {{.Path}} to {{.Version}}")), data)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				return title
+			},
+			want: []string{"upgrade " + path + " to " + version},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := tc.got(t)
+			for _, want := range tc.want {
+				if !strings.Contains(got, want) {
+					t.Errorf("expected %q in:\n%s", want, got)
+				}
+			}
+
+			for _, escaped := range []string{"&amp;", "&#43;", "&lt;", `\u0026`, `\u003c`} {
+				if strings.Contains(got, escaped) {
+					t.Errorf("expected no %q in:\n%s", escaped, got)
+				}
+			}
+		})
+	}
+}
+
+// TestCommitBody pins the whole commit message of the default
+// templates, for an update to a +incompatible version and for a group.
+func TestCommitBody(t *testing.T) {
+	repo := modrepo.Repo{Host: "github.com", Owner: "go-yaml", Name: "yaml"}
+	oldVersion, newVersion := "v2.0.4+incompatible", "v2.0.5+incompatible"
+	cases := []struct {
+		name string
+		tmpl *template.Template
+		data commitTemplateData
+		want string
+	}{
+		{
+			name: "single",
+			tmpl: commitTemplate,
+			data: commitTemplateData{
+				Project:     "yaml",
+				Owner:       "go-yaml",
+				Path:        "github.com/go-yaml/yaml",
+				Version:     displayVersion(newVersion, false),
+				OldVersion:  oldVersion,
+				FromVersion: fromVersion(oldVersion),
+				Target:      "github.com/go-yaml/yaml@" + newVersion,
+				URL:         repo.TreeURL(repoRef(repo, newVersion)),
+				CompareURL:  repo.CompareURL(repoRef(repo, oldVersion), repoRef(repo, newVersion)),
+				Prefix:      "modules",
+				SideEffects: []requirementChange{{Path: "gopkg.in/check.v1", OldVersion: "v1.0.0-20180628173108-788fd7840127", NewVersion: "v1.0.0-20201130134442-10cb98267c6c"}},
+			},
+			want: `modules: upgrade yaml to 2.0.5
+
+This updates:
+  github.com/go-yaml/yaml
+
+From version 2.0.4 to version 2.0.5.
+
+Also updated as a side effect:
+  gopkg.in/check.v1 v1.0.0-20180628173108-788fd7840127 -> v1.0.0-20201130134442-10cb98267c6c
+
+Executed via:
+
+  go get github.com/go-yaml/yaml@v2.0.5+incompatible
+  go mod tidy
+
+For details on changes, see the project's release page.
+  https://github.com/go-yaml/yaml/tree/v2.0.5
+
+To compare against the previous version, see:
+  https://github.com/go-yaml/yaml/compare/v2.0.4...v2.0.5
+
+This commit message was auto-generated.`,
+		},
+		{
+			name: "group",
+			tmpl: groupCommitTemplate,
+			data: commitTemplateData{
+				Project: "x",
+				Path:    "golang.org/x/",
+				Target:  "golang.org/x/net@latest golang.org/x/text@latest golang.org/x/tools",
+				Prefix:  "modules",
+				Vendor:  true,
+				Group: []requirementChange{
+					{Path: "golang.org/x/net", OldVersion: "v0.20.0", NewVersion: "v0.21.0"},
+					{Path: "golang.org/x/sys", OldVersion: "", NewVersion: "v0.17.0"},
+					{Path: "golang.org/x/text", OldVersion: "v0.14.0", NewVersion: "v0.15.0"},
+					{Path: "golang.org/x/tools", OldVersion: "v0.18.0", NewVersion: "v0.19.0", Tool: true},
+				},
+				Downgrades: []requirementChange{{Path: "example.com/pinned", OldVersion: "v1.2.0", NewVersion: "v1.1.0"}},
+			},
+			want: `modules: upgrade x module group
+
+This updates the modules matching:
+  golang.org/x/
+
+To their latest versions:
+  golang.org/x/net v0.20.0 -> v0.21.0
+  golang.org/x/sys (none) -> v0.17.0
+  golang.org/x/text v0.14.0 -> v0.15.0
+  golang.org/x/tools (tool) v0.18.0 -> v0.19.0
+
+WARNING: downgraded as a side effect:
+  example.com/pinned v1.2.0 -> v1.1.0
+
+Executed via:
+
+  go get golang.org/x/net@latest golang.org/x/text@latest golang.org/x/tools
+  go mod tidy
+  go mod vendor
+
+This commit message was auto-generated.`,
+		},
+		{
+			name: "list",
+			tmpl: listCommitTemplate,
+			data: commitTemplateData{
+				Target: "rsc.io/quote@v1.5.2 golang.org/x/tools",
+				Prefix: "modules",
+				Group: []requirementChange{
+					{Path: "rsc.io/quote", OldVersion: "v1.5.1", NewVersion: "v1.5.2"},
+					{Path: "golang.org/x/tools", OldVersion: "v0.18.0", NewVersion: "v0.19.0", Tool: true},
+				},
+			},
+			want: `modules: upgrade 2 dependencies
+
+This updates:
+  rsc.io/quote v1.5.1 -> v1.5.2
+  golang.org/x/tools (tool) v0.18.0 -> v0.19.0
+
+Executed via:
+
+  go get rsc.io/quote@v1.5.2 golang.org/x/tools
+  go mod tidy
+
+This commit message was auto-generated.`,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			b := new(strings.Builder)
+			if err := tc.tmpl.Execute(b, tc.data); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := b.String(); got != tc.want {
+				t.Fatalf("expected:\n%s\ngot:\n%s", tc.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,2444 @@
+package bump
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/vancluever/depbump/internal/constraint"
+	"github.com/vancluever/depbump/internal/forge"
+	"github.com/vancluever/depbump/internal/gitremote"
+	"github.com/vancluever/depbump/internal/gitrepo"
+	"github.com/vancluever/depbump/internal/modinfo"
+	"github.com/vancluever/depbump/internal/modrepo"
+	"github.com/vancluever/depbump/internal/trailer"
+	goversion "go/version"
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+)
+
+// AzureTokenName is the environment variable the command line reads
+// the personal access token for Azure DevOps pull requests from.
+const AzureTokenName = "AZURE_DEVOPS_TOKEN"
+
+// commitRe matches the commit hashes accepted for Options.Commit.
+var commitRe = regexp.MustCompile(`^[0-9a-fA-F]{7,40}$`)
+
+// checkListOptions checks that the options given along with FromFile
+// make sense, as the list names the modules and their versions itself.
+func (r *run) checkListOptions() error {
+	switch {
+	case r.opts.Path != "":
+		return usagef("PATH cannot be given with -from-file; give a post-update command after --")
+
+	case r.opts.Group != "" || r.opts.Version != "" || r.opts.Commit != "":
+		return usagef("-group, -version, and -commit cannot be used with -from-file")
+	}
+
+	return nil
+}
+
+// parsedOptions are the options of a run that are given as text, such
+// as templates and constraints, once they have been parsed.
+type parsedOptions struct {
+	prTemplate, prTitleTemplate *template.Template
+	constraint                  *constraint.Constraint
+	skips                       []versionSkip
+	branchPrefix, commitPrefix  string
+}
+
+// parseOptions parses and checks the options of the run that can be
+// told apart from the rest, so that mistakes in them are reported
+// before anything is done.
+func (r *run) parseOptions(ctx context.Context) (*parsedOptions, error) {
+	opts := r.opts
+	p := &parsedOptions{branchPrefix: DefaultBranchPrefix}
+	if opts.BranchPrefix != nil {
+		p.branchPrefix = *opts.BranchPrefix
+	}
+
+	if opts.PRTemplateFile != "" {
+		content, err := ioutil.ReadFile(r.path(opts.PRTemplateFile))
+		if err != nil {
+			return nil, fmt.Errorf("error reading PR template: %w", err)
+		}
+
+		if p.prTemplate, err = template.New(opts.PRTemplateFile).Funcs(templateFuncs).Parse(string(content)); err != nil {
+			return nil, fmt.Errorf("error parsing PR template: %w", err)
+		}
+	}
+
+	if opts.PRTitleTemplate != "" {
+		var err error
+		if p.prTitleTemplate, err = template.New("pr-title-template").Funcs(templateFuncs).Parse(opts.PRTitleTemplate); err != nil {
+			return nil, usagef("invalid -pr-title-template: %s", err)
+		}
+	}
+
+	if opts.Constraint != "" {
+		var err error
+		if p.constraint, err = constraint.Parse(opts.Constraint); err != nil {
+			return nil, usagef("invalid -constraint: %s", err)
+		}
+	}
+
+	for _, s := range opts.Skips {
+		skip, err := parseSkip(s)
+		if err != nil {
+			return nil, usagef("invalid -skip-version: %s", err)
+		}
+
+		p.skips = append(p.skips, skip)
+	}
+
+	if opts.SkipFile != "" {
+		s, err := readSkipFile(r.path(opts.SkipFile))
+		if err != nil {
+			return nil, failf(ExitPrecondition, "error reading skip file: %w", err)
+		}
+
+		p.skips = append(p.skips, s...)
+	}
+
+	if opts.Commit != "" && !commitRe.MatchString(opts.Commit) {
+		return nil, usagef("invalid commit %q, expected 7 to 40 hex characters", opts.Commit)
+	}
+
+	if opts.Toolchain != "" && (!goVersionRe.MatchString(strings.TrimPrefix(opts.Toolchain, "go")) || !strings.HasPrefix(opts.Toolchain, "go")) {
+		return nil, usagef("invalid toolchain %q, expected a name like go1.22.4", opts.Toolchain)
+	}
+
+	for _, id := range []struct{ flag, value string }{{"author", opts.Author}, {"committer", opts.Committer}} {
+		if id.value != "" && !identityRe.MatchString(id.value) {
+			return nil, usagef("invalid %s %q, expected \"Name <email>\"", id.flag, id.value)
+		}
+	}
+
+	if !r.validBranchPrefix(ctx, p.branchPrefix) {
+		return nil, usagef("invalid branch prefix %q", p.branchPrefix)
+	}
+
+	if opts.CommitPrefix != nil {
+		p.commitPrefix = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(*opts.CommitPrefix), ":"))
+		if strings.ContainsAny(p.commitPrefix, "\r\n") {
+			return nil, usagef("invalid commit prefix %q", p.commitPrefix)
+		}
+	}
+
+	for _, rem := range []string{opts.Remote, opts.ForkRemote} {
+		if rem == "" {
+			continue
+		}
+
+		ok, err := r.git().HasRemote(ctx, rem)
+		if err != nil {
+			return nil, err
+		}
+
+		if !ok {
+			return nil, fmt.Errorf("no remote named %q", rem)
+		}
+	}
+
+	return p, nil
+}
+
+// updateTarget is what a run updates, as worked out from the options: a
+// module, a group of modules, the go directive, or just the module
+// metadata.
+type updateTarget struct {
+	// path is the module being updated, or the group prefix. version is
+	// the version to update to, if one was given or picked: a version, a
+	// query such as "latest", or the commit, when commit is set.
+	path, version, commit string
+
+	// group is the prefix of the modules updated together with -group,
+	// or "dependencies" for the modules in listed, the -from-file list,
+	// when they are updated together.
+	group  string
+	listed []listEntry
+
+	// goDirective is set when updating Go itself, along with toolchain
+	// if -toolchain is given, and tidyOnly when only tidying.
+	goDirective, tidyOnly bool
+	toolchain             string
+
+	// prefix is the commit subject prefix, postCmds the post-update
+	// commands, in order, and date the time given to templates.
+	prefix   string
+	postCmds [][]string
+	date     time.Time
+
+	// gowork is the go.work file of the workspace being updated, if
+	// any. replacement describes the replace directive for the module,
+	// and skipped the newer versions passed over when picking version.
+	gowork      string
+	replacement string
+	skipped     []versionSkip
+
+	parsed *parsedOptions
+}
+
+// project returns the project the update is named for, in the commit
+// subject and the branch.
+func (t *updateTarget) project() string {
+	return moduleProject(t.path)
+}
+
+// checkout is the state of the repository before the update, which is
+// returned to afterwards.
+type checkout struct {
+	// oldBranch is the branch checked out before the update, or the
+	// commit if HEAD was detached, and oldHead the commit it was at.
+	oldBranch, oldHead string
+	detached           bool
+
+	// base is the branch the update is made on, if it is known, and
+	// sign is set if the commit is signed.
+	base string
+	sign bool
+}
+
+// prTarget is where the update branch is pushed, and the pull request
+// for it opened, if one is.
+type prTarget struct {
+	// pushRemote is the remote the update branch is pushed to.
+	pushRemote string
+
+	// pr is set if a GitHub pull request is opened, against
+	// remoteOwner/remoteRepo from the branch in headOwner/headRepo.
+	pr                      bool
+	remoteOwner, remoteRepo string
+	headOwner, headRepo     string
+
+	// azurePR is set if an Azure DevOps pull request is opened instead,
+	// against azureTarget.
+	azurePR     bool
+	azureTarget gitremote.AzureRepo
+
+	// defaultBranch is the branch the pull request is opened against,
+	// if it could be found.
+	defaultBranch string
+}
+
+// upgradePlan is how the modules are upgraded: the target given to go
+// get, or the flags to "go mod edit" when updating Go, along with the
+// members of a group and their targets.
+type upgradePlan struct {
+	target                 string
+	members, memberTargets []string
+	oldToolchain           string
+}
+
+// upgradeResult is the outcome of upgradeModules: the modules that were
+// updated, and what changed for them other than their requirements.
+type upgradeResult struct {
+	plan    *upgradePlan
+	modules []*moduleUpdate
+
+	// toolTargets are the arguments given to "go get -tool", for a tool
+	// dependency, and newToolchain the toolchain directive after the
+	// update, when updating Go.
+	toolTargets  []string
+	newToolchain string
+
+	// goRequirement is the go directive of the new version's go.mod,
+	// and replacementVersion the version its replacement was updated
+	// to, with -bump-replace.
+	goRequirement      string
+	replacementVersion string
+
+	// vulnsBefore are the vulnerabilities known before the update, if
+	// they were checked.
+	vulnsBefore map[string]bool
+}
+
+// changeReport is what else the update changed, as found by
+// reportChanges.
+type changeReport struct {
+	sideEffects, downgrades, group []requirementChange
+
+	// vendorDirs are the directories vendoring and verifying was done
+	// for, and vendored those that are vendored.
+	vendorDirs, vendored []string
+
+	// vulnChecked is set if vulnerabilities were checked before and
+	// after the update.
+	vulnChecked                 bool
+	vulnsFixed, vulnsIntroduced []string
+}
+
+// updateDescription is how the update is described: the data for the
+// templates, the templates to render, and the commit trailers.
+// newVersion is the version reported for the update, which for Go, tidy
+// and group updates is what the branch is named for.
+type updateDescription struct {
+	data               commitTemplateData
+	commitTmpl, prTmpl *template.Template
+	trailers           trailer.Trailers
+	newVersion         string
+}
+
+// commitResult is the commit made for the update, and what's needed to
+// push it: the environment for git, and the commit of the remote branch
+// being replaced, if any. title and prBody are the rendered pull
+// request title and body.
+type commitResult struct {
+	sha             string
+	env             []string
+	remoteBranchSHA string
+	title, prBody   string
+}
+
+// update updates a single module, or group of modules, as the options
+// say, from start to finish. Each phase returns the state the later ones
+// need; a phase returning nil for it found there is nothing more to do.
+func (r *run) update(ctx context.Context) error {
+	parsed, err := r.parseOptions(ctx)
+	if err != nil {
+		return err
+	}
+
+	if r.opts.Remote != "" {
+		r.remote = r.opts.Remote
+	}
+
+	r.startPhase("preflight")
+	t, err := r.resolveTarget(parsed)
+	if err != nil {
+		return err
+	}
+
+	haveToken, err := r.setupClients()
+	if err != nil {
+		return err
+	}
+
+	// Without origin, another remote may do, but it has to be clear
+	// which, as the pull request goes to it.
+	fork := r.opts.ForkRemote
+	if fork == "" {
+		fork = defaultForkRemote
+	}
+
+	if err := r.selectRemote(ctx, fork, r.opts.Push || t.path == "cleanup"); err != nil {
+		return err
+	}
+
+	// Cleaning up merged update branches only involves the remote, so
+	// none of the checks done for an update are needed.
+	if t.path == "cleanup" {
+		return r.cleanupMerged(ctx, t, haveToken)
+	}
+
+	co, err := r.prepareCheckout(ctx)
+	if err != nil {
+		return err
+	}
+
+	if t.gowork, err = r.goWorkFile(ctx); err != nil {
+		return err
+	}
+
+	var modules []*moduleUpdate
+	if t.path, modules, err = r.findModules(ctx, t); err != nil {
+		return err
+	}
+
+	oldVersion := modules[0].OldVersion
+	r.setOutput("module", t.path)
+	r.setOutput("old-version", oldVersion)
+
+	if !t.goDirective && !t.tidyOnly && t.group == "" {
+		choice, err := selectVersion(r.logger, versionRequest{
+			path:       t.path,
+			current:    oldVersion,
+			version:    t.version,
+			constraint: parsed.constraint,
+			pre:        r.opts.Pre,
+			skips:      parsed.skips,
+			minAge:     r.opts.MinAge,
+			now:        time.Now(),
+		}, r.versionLookup(ctx, t.path))
+		if err != nil {
+			return err
+		}
+
+		if choice.current != "" {
+			r.setOutput("status", "already-current")
+			return fail(r.currentCode(), errors.New(choice.current))
+		}
+
+		t.version, t.skipped = choice.version, choice.skipped
+	}
+
+	if t.version != "" && sameVersion(oldVersion, t.version) {
+		r.setOutput("status", "already-current")
+		return failf(r.currentCode(), "package %s is already at version %s", t.path, t.version)
+	}
+
+	if t.replacement, err = r.checkReplacements(t.path, modules); err != nil {
+		return err
+	}
+
+	prt, err := r.preflightPR(ctx, t, co, haveToken)
+	if err != nil {
+		return err
+	}
+
+	plan, err := r.planUpgrade(ctx, t, modules)
+	if err != nil {
+		return err
+	}
+
+	if err := r.checkVersion(ctx, t); err != nil {
+		return err
+	}
+
+	open, err := r.findUpdatePR(ctx, t, prt, oldVersion)
+	if err != nil || open {
+		return err
+	}
+
+	up, err := r.upgradeModules(ctx, t, plan, modules)
+	if err != nil || up == nil {
+		return err
+	}
+
+	changes, err := r.reportChanges(ctx, t, up)
+	if err != nil || changes == nil {
+		return err
+	}
+
+	d, err := r.describeUpdate(ctx, t, co, prt, up, changes)
+	if err != nil {
+		return err
+	}
+
+	proceed, err := r.reviewUpdate(ctx, t, d, up.modules)
+	if err != nil || !proceed {
+		return err
+	}
+
+	c, err := r.commitUpdate(ctx, t, co, prt, d, up, changes)
+	if err != nil || c == nil {
+		return err
+	}
+
+	return r.pushAndOpenPR(ctx, t, co, prt, d, c)
+}
+
+// resolveTarget works out what the run updates from the options,
+// checking that the options given along with it make sense.
+func (r *run) resolveTarget(parsed *parsedOptions) (*updateTarget, error) {
+	opts := r.opts
+	path := opts.Path
+	version := opts.Version
+	commit := opts.Commit
+	group := strings.TrimSuffix(opts.Group, "/")
+	toolchain := opts.Toolchain
+	postCmdRaw := opts.Command
+	postCmds := opts.PostCmds
+
+	date, err := r.templateDate()
+	if err != nil {
+		return nil, fail(ExitPrecondition, err)
+	}
+
+	var listed []listEntry
+	if opts.FromFile != "" {
+		if err := r.checkListOptions(); err != nil {
+			return nil, err
+		}
+
+		// The listed modules are updated together, as a group that is
+		// named for them, rather than matching a prefix.
+		entries, _, err := r.readList(opts.FromFile)
+		if err != nil {
+			return nil, failf(ExitPrecondition, "error reading module list: %w", err)
+		}
+
+		if len(entries) < 1 {
+			return nil, failf(ExitPrecondition, "no modules in the module list")
+		}
+
+		listed = entries
+		group = "dependencies"
+	} else if opts.GroupPR {
+		return nil, usagef("-group-pr can only be used with -from-file")
+	}
+
+	if group != "" {
+		if path != "" {
+			return nil, usagef("PATH cannot be given with -group")
+		}
+
+		if version != "" || commit != "" {
+			return nil, usagef("-version and -commit cannot be used with -group, each module is updated to its latest version")
+		}
+
+		path = group
+	}
+
+	if parsed.constraint != nil {
+		switch {
+		case version != "" || commit != "":
+			return nil, usagef("-constraint cannot be used with -version or -commit")
+
+		case group != "":
+			return nil, usagef("-constraint cannot be used with -group or -group-pr, each module is updated to its latest version")
+
+		case path == "go" || path == "tidy":
+			return nil, usagef("-constraint cannot be used with %s", path)
+		}
+	} else if opts.Pre {
+		return nil, usagef("-pre can only be used with -constraint")
+	}
+
+	if path == "" {
+		return nil, usagef("path is empty")
+	}
+
+	if !opts.Push && len(opts.PushOptions) > 0 {
+		r.logger.Warn("-push-option has no effect when -nopush is set")
+	}
+
+	if commit != "" {
+		if version != "" {
+			return nil, usagef("-commit and -version cannot be used together")
+		}
+
+		// Go resolves the commit to a pseudo-version (or the tag
+		// pointing at it).
+		version = strings.ToLower(commit)
+	}
+
+	// Updating the go directive: "depbump go 1.22.4" is accepted as
+	// shorthand for "depbump -version 1.22.4 go".
+	goDirective := path == "go"
+	tidyOnly := path == "tidy"
+
+	prefix := "modules"
+	switch {
+	case opts.CommitPrefix != nil:
+		prefix = parsed.commitPrefix
+
+	case goDirective:
+		prefix = "build"
+	}
+
+	if tidyOnly && (version != "" || commit != "") {
+		return nil, usagef("-version and -commit cannot be used with tidy")
+	}
+
+	if goDirective {
+		if version == "" && len(postCmdRaw) == 1 && goVersionRe.MatchString(postCmdRaw[0]) {
+			version, postCmdRaw = postCmdRaw[0], nil
+		}
+
+		version = strings.TrimPrefix(strings.TrimPrefix(version, "go"), "v")
+		if version != "" && !goVersionRe.MatchString(version) {
+			return nil, usagef("invalid Go version %q", version)
+		}
+	} else if toolchain != "" {
+		return nil, usagef("-toolchain can only be used when updating go")
+	}
+
+	// go.mod records versions with their "v", so one given without it
+	// gets one, making it comparable with the current version.
+	if !goDirective && commit == "" && version != "" {
+		normalized, ok := normalizeVersion(version)
+		if !ok {
+			return nil, usagef("invalid version %q", version)
+		}
+
+		version = normalized
+	}
+
+	// The positional command, if any, runs before the ones supplied
+	// with -post-cmd.
+	if len(postCmdRaw) > 0 {
+		postCmds = append([][]string{postCmdRaw}, postCmds...)
+	}
+
+	return &updateTarget{
+		path:        path,
+		version:     version,
+		commit:      commit,
+		group:       group,
+		listed:      listed,
+		goDirective: goDirective,
+		tidyOnly:    tidyOnly,
+		toolchain:   toolchain,
+		prefix:      prefix,
+		postCmds:    postCmds,
+		date:        date,
+		parsed:      parsed,
+	}, nil
+}
+
+// setupClients sets up TLS for the run's connections, and the GitHub
+// App it authenticates as, if any. It returns whether there is a token
+// for GitHub API calls: the one given, or one minted for the App when
+// it's first needed.
+func (r *run) setupClients() (bool, error) {
+	opts := r.opts
+	if opts.InsecureSkipTLSVerify {
+		r.logger.Warn("TLS certificate verification is disabled; connections to GitHub and module hosts can be intercepted")
+	}
+
+	if err := r.configureTLS(opts.CAFile, opts.InsecureSkipTLSVerify); err != nil {
+		return false, err
+	}
+
+	haveToken := opts.Token != ""
+	if opts.AppID != "" || opts.AppInstallationID != "" || opts.AppKeyFile != "" {
+		if opts.AppID == "" || opts.AppInstallationID == "" || opts.AppKeyFile == "" {
+			return false, usagef("-github-app-id, -github-app-installation-id, and -github-app-key-file must be given together")
+		}
+
+		app, err := forge.LoadApp(opts.AppID, opts.AppInstallationID, r.path(opts.AppKeyFile))
+		if err != nil {
+			return false, err
+		}
+
+		app.Client, app.Logger = r.client, r.logger
+		r.app = app
+		haveToken = true
+	} else if opts.AppPush {
+		return false, usagef("-github-app-push requires a GitHub App")
+	}
+
+	return haveToken, nil
+}
+
+// tokenName returns the environment variable the GitHub token is read
+// from, for messages about it being missing.
+func (r *run) tokenName() string {
+	if r.opts.TokenName == "" {
+		return DefaultTokenName
+	}
+
+	return r.opts.TokenName
+}
+
+// currentCode returns the exit status for a module that is already
+// current, which is an error with -fail-if-current.
+func (r *run) currentCode() int {
+	if r.opts.FailIfCurrent {
+		return ExitCurrentFailed
+	}
+
+	return ExitAlreadyCurrent
+}
+
+// cleanupMerged deletes the update branches whose pull requests have
+// been merged, for "depbump cleanup".
+func (r *run) cleanupMerged(ctx context.Context, t *updateTarget, haveToken bool) error {
+	if !haveToken {
+		return failf(ExitPrecondition, "%s is not set; it is needed to find merged pull requests", r.tokenName())
+	}
+
+	pushRemote := r.remote
+	if r.opts.ForkRemote != "" {
+		pushRemote = r.opts.ForkRemote
+	} else if ok, err := r.git().HasRemote(ctx, defaultForkRemote); err != nil {
+		return err
+	} else if ok {
+		pushRemote = defaultForkRemote
+	}
+
+	owner, repo, err := r.gitHubRepo(ctx, r.remote, r.opts.HostAliases)
+	if err != nil {
+		return err
+	}
+
+	headOwner, headRepo, err := r.gitHubRepo(ctx, pushRemote, r.opts.HostAliases)
+	if err != nil {
+		return err
+	}
+
+	env, err := r.pushEnv(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := r.cleanupBranches(ctx, owner, repo, headOwner+"/"+headRepo, pushRemote, t.parsed.branchPrefix, env); err != nil {
+		return err
+	}
+
+	r.setOutput("status", "cleaned-up")
+	return nil
+}
+
+// prepareCheckout takes the lock on the repository, checks it is clean,
+// and checks out what the update is made on top of, recording what to
+// return to afterwards.
+func (r *run) prepareCheckout(ctx context.Context) (*checkout, error) {
+	opts := r.opts
+	base := opts.Base
+	fromRef := opts.FromRef
+	sign := opts.Sign || opts.SignKey != ""
+
+	// One run at a time changes the repository.
+	if err := r.lockRepository(ctx, opts.LockTimeout); err != nil {
+		return nil, err
+	}
+
+	// Require clean repo before continuing. In a worktree, the state of
+	// the checkout doesn't matter, as it is never touched.
+	if opts.Worktree {
+		if err := r.enterWorktree(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	out, err := r.git().Output(ctx, "status", "--porcelain")
+	if err != nil {
+		return nil, err
+	}
+
+	// Untracked files can be allowed, as long as they are kept out of
+	// the commit.
+	if opts.IgnoreUntracked {
+		out = trackedChanges(out)
+		if r.keepUntracked, err = r.untrackedFiles(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(out) > 0 {
+		return nil, failf(ExitPrecondition, "uncommitted changes in repository, please commit or stash before continuing")
+	}
+
+	// Commits are signed when SSH signing is configured. Unlike GPG,
+	// SSH signing has no default key, so check there is one before
+	// doing any work.
+	signFormat, err := r.git().Config(ctx, "gpg.format")
+	if err != nil {
+		return nil, err
+	}
+
+	if signFormat == "ssh" {
+		sign = true
+		key, err := r.git().Config(ctx, "user.signingkey")
+		if err != nil {
+			return nil, err
+		}
+
+		if opts.SignKey == "" && key == "" {
+			return nil, failf(ExitPrecondition, "gpg.format is ssh, but user.signingkey is not set; set it, or use -sign-key to give the key to sign with")
+		}
+	}
+
+	// Get existing branch, to return to after committing. CI systems
+	// often check out a commit rather than a branch, in which case the
+	// commit is returned to instead.
+	oldBranch, detached, err := r.git().CurrentBranch(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if detached && !opts.Worktree {
+		r.logger.Info("HEAD is detached, returning to it after the update", "commit", oldBranch)
+	}
+
+	// Bring the branch up to date first with -pull, which is only done
+	// if it can be fast-forwarded, so that the update isn't made on top
+	// of history that has diverged from upstream.
+	if opts.Pull {
+		upstreamOut, err := gitrepo.Output(ctx, r, command("", nil, "git", "rev-parse", "--abbrev-ref", "--symbolic-full-name", "@{upstream}"))
+		switch {
+		case detached:
+			r.logger.Warn("HEAD is detached, not pulling")
+
+		case err != nil:
+			if ctx.Err() != nil {
+				return nil, errInterrupted
+			}
+
+			r.logger.Warn("branch has no upstream, not pulling", "branch", oldBranch)
+
+		default:
+			upstream := strings.TrimSpace(string(upstreamOut))
+			r.logger.Info("fast-forwarding branch", "branch", oldBranch, "upstream", upstream)
+			if err := r.git().Run(ctx, "pull", "-q", "--ff-only"); err != nil {
+				return nil, failf(ExitPrecondition, "could not fast-forward %s to %s; it may have diverged, so reconcile it before trying again: %w", oldBranch, upstream, err)
+			}
+		}
+	}
+
+	// The commit oldBranch is at, to put it back to if committing fails.
+	oldHead, err := r.git().Head(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	r.restoreCheckout = r.rollbackBranch(ctx, oldBranch, oldHead, "")
+
+	// With FreshBase, which the command line defaults to when pushing
+	// from CI, the update is made on top of the base branch as it is on
+	// the remote, as the local branch may well be behind it.
+	if opts.FreshBase && fromRef == "" {
+		b := base
+		if b == "" {
+			if b, err = r.git().DefaultBranch(ctx, r.remote); err != nil {
+				return nil, err
+			}
+		}
+
+		ref := "refs/remotes/" + r.remote + "/" + b
+		r.logger.Info("fetching base branch", "branch", b, "remote", r.remote)
+		if err := r.git().Run(ctx, "fetch", "-q", r.remote, "+refs/heads/"+b+":"+ref); err != nil {
+			return nil, failf(ExitGitFailed, "error fetching base branch %s: %w", b, err)
+		}
+
+		fromRef = ref
+	}
+
+	// With -from-ref, the update is made on top of the ref rather than
+	// what is checked out, which is returned to whether or not the run
+	// succeeds.
+	if fromRef != "" {
+		refCommit, err := r.fromRefCommit(ctx, fromRef)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := r.git().Run(ctx, "-c", "advice.detachedHead=false", "checkout", "-q", refCommit); err != nil {
+			return nil, fail(ExitGitFailed, err)
+		}
+
+		next := r.cleanup
+		r.cleanup = func() {
+			if err := r.git().Run(ctx, "-c", "advice.detachedHead=false", "checkout", "-q", oldBranch); err != nil {
+				r.logger.Warn("could not return to the original branch", "branch", oldBranch, "error", err)
+			}
+
+			if next != nil {
+				next()
+			}
+		}
+
+		if base == "" {
+			base = r.refBranch(ctx, fromRef)
+		}
+
+		r.logger.Info("updating from ref", "ref", fromRef, "commit", refCommit, "base", base)
+	}
+
+	return &checkout{oldBranch: oldBranch, oldHead: oldHead, detached: detached, base: base, sign: sign}, nil
+}
+
+// findModules works out which modules to update. Normally this is just
+// the current module, but in a workspace it is every module in the
+// workspace that requires the path, and in recursive mode every module
+// in the repository that does. The path is returned too, as it is
+// written in the modules' requirements.
+func (r *run) findModules(ctx context.Context, t *updateTarget) (string, []*moduleUpdate, error) {
+	path, gowork := t.path, t.gowork
+	recursive := r.opts.Recursive
+
+	var modules []*moduleUpdate
+	switch {
+	case t.goDirective && (recursive || gowork != ""):
+		return "", nil, failf(ExitPrecondition, "updating go is not supported with -recursive or in a workspace")
+
+	case t.group != "" && (recursive || gowork != ""):
+		return "", nil, failf(ExitPrecondition, "-group is not supported with -recursive or in a workspace")
+
+	case recursive && gowork != "":
+		return "", nil, failf(ExitPrecondition, "-recursive cannot be used in a workspace (%s); workspace modules are already updated together", gowork)
+
+	case t.goDirective:
+		v, err := pkgVersion(r.dir, path)
+		if err != nil {
+			return "", nil, err
+		}
+
+		modules = []*moduleUpdate{{Dir: ".", OldVersion: v, dir: r.dir}}
+
+	case t.group != "":
+		gomod, err := modinfo.Find(r.dir)
+		if err != nil {
+			return "", nil, fail(ExitPrecondition, err)
+		}
+
+		dir := filepath.Dir(gomod)
+		modules = tidyUpdates([]string{dir}, dir)
+
+	case recursive:
+		root, err := r.git().TopLevel(ctx)
+		if err != nil {
+			return "", nil, err
+		}
+
+		dirs, err := modinfo.FindAll(root)
+		if err != nil {
+			return "", nil, err
+		}
+
+		if t.tidyOnly {
+			modules = tidyUpdates(dirs, root)
+		} else if path, modules, err = r.moduleUpdates(dirs, root, path); err != nil {
+			return "", nil, err
+		}
+
+	case gowork != "":
+		dirs, err := modinfo.WorkspaceModules(gowork)
+		if err != nil {
+			return "", nil, err
+		}
+
+		if t.tidyOnly {
+			modules = tidyUpdates(dirs, filepath.Dir(gowork))
+		} else if path, modules, err = r.moduleUpdates(dirs, filepath.Dir(gowork), path); err != nil {
+			return "", nil, err
+		}
+
+	default:
+		gomod, err := modinfo.Find(r.dir)
+		if err != nil {
+			return "", nil, fail(ExitPrecondition, err)
+		}
+
+		dir := filepath.Dir(gomod)
+		if t.tidyOnly {
+			modules = tidyUpdates([]string{dir}, dir)
+		} else if path, modules, err = r.moduleUpdates([]string{dir}, dir, path); err != nil {
+			return "", nil, err
+		}
+	}
+
+	return path, modules, nil
+}
+
+// checkReplacements checks for a replace directive for the module at
+// path in each of modules. If one applies to all versions, updating the
+// require directive alone does not change what gets built, so this is
+// an error unless -ignore-replace or -bump-replace is given. The first
+// replacement found is returned, as it is described in the commit.
+func (r *run) checkReplacements(path string, modules []*moduleUpdate) (string, error) {
+	var replacement string
+	for _, m := range modules {
+		f, err := modinfo.Load(m.dir)
+		if err != nil {
+			return "", err
+		}
+
+		rep, ok := f.Replaced(path)
+		if !ok {
+			continue
+		}
+
+		switch {
+		case rep.OldVersion != "":
+			r.logger.Warn("replace directive will no longer apply after the update", "module", path, "version", rep.OldVersion, "dir", m.Dir)
+			continue
+
+		case r.opts.BumpReplace && rep.NewVersion == "":
+			return "", failf(ExitPrecondition, "%s is replaced by local directory %s, which cannot be updated with -bump-replace", path, rep.NewPath)
+
+		case r.opts.BumpReplace:
+			m.replace = &rep
+
+		case r.opts.IgnoreReplace:
+			r.logger.Warn("module is replaced, builds will continue to use the replacement", "module", path, "replacement", replacementString(rep))
+
+		default:
+			return "", failf(
+				ExitPrecondition,
+				"%s is replaced in go.mod by %s\n\n"+
+					"Updating the require directive will not change the version used in builds. Use\n"+
+					"-ignore-replace to update it anyway, or -bump-replace to also update a\n"+
+					"version-pinned replacement.",
+				path, replacementString(rep),
+			)
+		}
+
+		if replacement == "" {
+			replacement = replacementString(rep)
+		}
+	}
+
+	return replacement, nil
+}
+
+// preflightPR checks the remote to see if a pull request can be
+// opened for the update, and works out where the update branch is
+// pushed: to the remote, unless a fork is being used.
+func (r *run) preflightPR(ctx context.Context, t *updateTarget, co *checkout, haveToken bool) (*prTarget, error) {
+	opts := r.opts
+	forkRemote := opts.ForkRemote
+	prt := &prTarget{pushRemote: r.remote, pr: opts.PR}
+	if !opts.Push {
+		return &prTarget{pushRemote: r.remote}, nil
+	}
+
+	if forkRemote == "" {
+		ok, err := r.git().HasRemote(ctx, defaultForkRemote)
+		if err != nil {
+			return nil, err
+		}
+
+		if ok {
+			forkRemote = defaultForkRemote
+		}
+	}
+
+	if forkRemote != "" {
+		prt.pushRemote = forkRemote
+		r.logger.Info("pushing to fork remote", "remote", prt.pushRemote)
+	}
+
+	remoteURL, err := r.git().RemoteURL(ctx, r.remote)
+	if err != nil {
+		return nil, err
+	}
+
+	remote, err := gitremote.Parse(remoteURL)
+	if err != nil {
+		return nil, failf(ExitPrecondition, "error parsing remote URL: %w", err)
+	}
+
+	if alias, ok := opts.HostAliases[remote.Host]; ok {
+		remote.Host = alias
+	}
+
+	// Say why a pull request won't be created, unless it wasn't wanted
+	// anyway. Azure DevOps pull requests are handled separately, as
+	// none of the GitHub features apply to them.
+	host, owner, repo := remote.Host, remote.Owner, remote.Repo
+	azure, isAzure := remote.Azure()
+	r.runRepo = remote.String()
+	switch {
+	case !prt.pr:
+
+	case isAzure:
+		prt.pr = false
+		if opts.AzureToken == "" {
+			r.logger.Info(AzureTokenName + " is not set; pull request will not be created")
+		} else if forkRemote != "" {
+			r.logger.Info("forks are not supported in Azure DevOps; pull request will not be created")
+		} else {
+			prt.azurePR = true
+			prt.azureTarget = azure
+		}
+
+	case host != "github.com":
+		r.logger.Info("remote is not on github.com; pull request will not be created", "remote", r.remote, "host", host)
+		prt.pr = false
+
+	case !haveToken:
+		r.logger.Info(r.tokenName() + " is not set; pull request will not be created")
+		prt.pr = false
+	}
+
+	if prt.pr {
+		if owner == "" {
+			return nil, failf(ExitPrecondition, "expected repo remote URI to follow OWNER/REPO format")
+		}
+
+		prt.remoteOwner, prt.remoteRepo = owner, repo
+		prt.headOwner, prt.headRepo = owner, repo
+		if prt.pushRemote != r.remote {
+			if prt.headOwner, prt.headRepo, err = r.gitHubRepo(ctx, prt.pushRemote, opts.HostAliases); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if !prt.pr && !prt.azurePR {
+		return prt, nil
+	}
+
+	// Detect remote HEAD branch for PRs, unless the base was given.
+	prt.defaultBranch = co.base
+	if prt.defaultBranch == "" {
+		if prt.defaultBranch, err = r.git().DefaultBranch(ctx, r.remote); err != nil {
+			if !co.detached {
+				return nil, err
+			}
+
+			r.logger.Debug("remote default branch not found", "remote", r.remote, "error", err)
+		}
+	}
+
+	// The checked out branch can't stand in for the base of a detached
+	// HEAD, so give up before doing any work.
+	if prt.defaultBranch == "" && co.detached {
+		return nil, failf(ExitPrecondition, "HEAD is detached, and the remote default branch could not be found; use -base to set the base branch for the PR")
+	}
+
+	if prt.defaultBranch != "" {
+		shallow, err := r.git().IsShallow(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		if shallow {
+			if err := r.checkShallowBase(ctx, prt.defaultBranch, opts.FetchDepth); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return prt, nil
+}
+
+// planUpgrade works out what to give go get to upgrade the modules.
+// When updating Go itself, the target is the set of flags passed to
+// "go mod edit" instead, and without a version or toolchain, Go is
+// moved to its latest release, which t.version is set to.
+func (r *run) planUpgrade(ctx context.Context, t *updateTarget, modules []*moduleUpdate) (*upgradePlan, error) {
+	plan := &upgradePlan{target: t.path}
+	if t.version != "" {
+		plan.target = t.path + "@" + t.version
+	}
+
+	// A group is updated by naming each of its members, so that each
+	// resolves to its own latest version.
+	switch {
+	case t.listed != nil:
+		for _, e := range t.listed {
+			target := e.Path
+			if e.Version != "" {
+				target += "@" + e.Version
+			}
+
+			plan.members = append(plan.members, e.Path)
+			plan.memberTargets = append(plan.memberTargets, target)
+		}
+
+		plan.target = strings.Join(plan.memberTargets, " ")
+
+	case t.group != "":
+		f, err := modinfo.Load(modules[0].dir)
+		if err != nil {
+			return nil, err
+		}
+
+		plan.members = groupMembers(f, t.group)
+		if len(plan.members) < 1 {
+			return nil, failf(ExitPrecondition, "no requirements in go.mod start with %s", t.group)
+		}
+
+		plan.memberTargets = plan.members
+		plan.target = strings.Join(plan.members, " ")
+	}
+
+	if t.goDirective {
+		f, err := modinfo.Load(r.dir)
+		if err != nil {
+			return nil, err
+		}
+
+		plan.oldToolchain = f.Toolchain()
+		if t.version == "" && t.toolchain == "" {
+			if t.version, err = r.resolveVersion(ctx, "go", "latest"); err != nil {
+				return nil, err
+			}
+		}
+
+		var flags []string
+		if t.version != "" {
+			flags = append(flags, "-go="+t.version)
+		}
+
+		if t.toolchain != "" {
+			flags = append(flags, "-toolchain="+t.toolchain)
+		}
+
+		plan.target = strings.Join(flags, " ")
+	}
+
+	return plan, nil
+}
+
+// checkVersion checks that the version asked for can be updated to,
+// before any work is done.
+func (r *run) checkVersion(ctx context.Context, t *updateTarget) error {
+	path, version := t.path, t.version
+	if version == "" || t.goDirective {
+		return nil
+	}
+
+	// A tagged version that doesn't exist would only fail at go get,
+	// once the pre-update commands have run. Partial versions are
+	// queries, which go get resolves itself.
+	if completeVersion(version) && !module.IsPseudoVersion(version) {
+		if err := r.checkVersionExists(ctx, path, version); err != nil {
+			return err
+		}
+	}
+
+	// Refuse to move to a version the module's authors have retracted.
+	// Go already avoids these when picking the latest version, so only
+	// explicitly requested versions need checking.
+	resolved, err := r.resolveVersion(ctx, path, version)
+	if err != nil {
+		return err
+	}
+
+	retracted, err := r.retractions(ctx, path, resolved)
+	if err != nil {
+		return err
+	}
+
+	if len(retracted) > 0 {
+		if !r.opts.AllowRetracted {
+			return failf(ExitPrecondition, "%s@%s has been retracted by the module's authors:\n  %s\n\nUse -allow-retracted to update to it anyway.", path, resolved, strings.Join(retracted, "\n  "))
+		}
+
+		r.logger.Warn("version has been retracted", "module", path, "version", resolved, "rationale", strings.Join(retracted, "; "))
+	}
+
+	return nil
+}
+
+// findUpdatePR returns true if a pull request for the same update is
+// already open, even if its branch is named differently, for example
+// after the branch prefix is changed. It is looked for by its title
+// before doing any work, which is only possible for a single module, as
+// the version can be resolved up front.
+func (r *run) findUpdatePR(ctx context.Context, t *updateTarget, prt *prTarget, oldVersion string) (bool, error) {
+	if !prt.pr || t.goDirective || t.tidyOnly || t.group != "" {
+		return false, nil
+	}
+
+	path, version, commit := t.path, t.version, t.commit
+	query := version
+	if query == "" {
+		query = "upgrade"
+	}
+
+	resolved, err := r.resolveVersion(ctx, path, query)
+	if err != nil {
+		return false, err
+	}
+
+	titleData := commitTemplateData{
+		Project:    t.project(),
+		Owner:      moduleOwner(path),
+		Path:       path,
+		Version:    displayVersion(resolved, commit != ""),
+		OldVersion: oldVersion,
+		Prefix:     t.prefix,
+		Date:       t.date,
+	}
+
+	if q := trackedQuery(version, resolved); commit == "" && q != "" {
+		titleData.Version = fromVersion(resolved)
+		titleData.Query, titleData.PseudoVersion = q, resolved
+	}
+
+	b := new(strings.Builder)
+	if err := commitTemplate.Execute(b, titleData); err != nil {
+		return false, err
+	}
+
+	title := strings.SplitN(b.String(), "\n", 2)[0]
+	if t.parsed.prTitleTemplate != nil {
+		if title, err = renderPRTitle(t.parsed.prTitleTemplate, titleData); err != nil {
+			return false, fmt.Errorf("error rendering PR title template: %w", err)
+		}
+	}
+
+	prURL, err := r.gitHub().OpenPRWithTitle(ctx, prt.remoteOwner, prt.remoteRepo, title)
+	if err != nil {
+		if ctx.Err() != nil {
+			return false, errInterrupted
+		}
+
+		r.logger.Warn("could not check for an open pull request for the update", "error", err)
+		return false, nil
+	}
+
+	if prURL == "" {
+		return false, nil
+	}
+
+	r.logger.Info("pull request for the update is already open, exiting", "module", path, "pr_url", prURL)
+	r.setOutput("pr-url", prURL)
+	r.setOutput("pr-number", forge.PRNumber(prURL))
+	r.setOutput("status", "pr-exists")
+	return true, nil
+}
+
+// upgradeModules runs the pre-update commands, and upgrades and tidies
+// each of modules as planned. It returns nil if none of them changed.
+func (r *run) upgradeModules(ctx context.Context, t *updateTarget, plan *upgradePlan, modules []*moduleUpdate) (*upgradeResult, error) {
+	opts := r.opts
+	path, version, group := t.path, t.version, t.group
+	goDirective, tidyOnly := t.goDirective, t.tidyOnly
+	target := plan.target
+
+	// Run any pre-update commands. The new version isn't known yet, so
+	// only the fields describing the current state are available.
+	r.startPhase("upgrade")
+	if len(opts.PreCmds) > 0 {
+		preData := commitTemplateData{
+			Project:    t.project(),
+			Owner:      moduleOwner(path),
+			Path:       path,
+			Target:     target,
+			OldVersion: modules[0].OldVersion,
+			Date:       t.date,
+		}
+
+		for _, raw := range opts.PreCmds {
+			preCmd, err := renderCommand("pre-update", raw, preData)
+			if err != nil {
+				return nil, err
+			}
+
+			r.logger.InfoContext(commandCtx, "running pre-update command", "command", strings.Join(preCmd, " "))
+			if err := r.runCommand(ctx, preCmd, preData, ""); err != nil {
+				return nil, failf(ExitUpgradeFailed, "error running pre-update command: %w", err)
+			}
+		}
+	}
+
+	up := &upgradeResult{plan: plan}
+
+	// Record known vulnerabilities before the update, so that the
+	// effect of the update on them can be reported. If govulncheck
+	// can't be run, the check is skipped.
+	if (opts.Vulncheck || opts.VulncheckFailOnNew) && !tidyOnly {
+		up.vulnsBefore = r.moduleVulns(ctx, modules)
+	}
+
+	for _, m := range modules {
+		if tidyOnly {
+			break
+		}
+
+		f, err := modinfo.Load(m.dir)
+		if err != nil {
+			return nil, err
+		}
+
+		if m.requires, err = requireVersions(m.dir); err != nil {
+			return nil, err
+		}
+
+		m.goVersion = f.GoVersion()
+		args := []string{"get", target}
+		if goDirective {
+			args = append([]string{"mod", "edit"}, strings.Split(target, " ")...)
+		} else if group != "" {
+			args = append([]string{"get"}, plan.memberTargets...)
+		} else if m.tools = f.Tools(path); len(m.tools) > 0 {
+			// Tool dependencies are updated through the tool packages
+			// they provide.
+			args = []string{"get", "-tool"}
+			up.toolTargets = nil
+			for _, tool := range m.tools {
+				if version != "" {
+					tool += "@" + version
+				}
+
+				args = append(args, tool)
+				up.toolTargets = append(up.toolTargets, tool)
+			}
+		}
+
+		if err := r.execDir(ctx, m.dir, "go", args...); err != nil {
+			return nil, fail(ExitUpgradeFailed, err)
+		}
+	}
+
+	// Only modules that actually changed need tidying and reporting.
+	var err error
+	for _, m := range modules {
+		if tidyOnly {
+			up.modules = append(up.modules, m)
+			continue
+		}
+
+		// A group is current only if every member is.
+		if group != "" {
+			after, err := requireVersions(m.dir)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, c := range requirementChanges(m.requires, after, "") {
+				if inGroup(plan.members, c.Path) {
+					up.modules = append(up.modules, m)
+					break
+				}
+			}
+
+			continue
+		}
+
+		if m.NewVersion, err = pkgVersion(m.dir, path); err != nil {
+			return nil, err
+		}
+
+		if goDirective {
+			f, err := modinfo.Load(m.dir)
+			if err != nil {
+				return nil, err
+			}
+
+			up.newToolchain = f.Toolchain()
+		}
+
+		if m.OldVersion != m.NewVersion || plan.oldToolchain != up.newToolchain {
+			up.modules = append(up.modules, m)
+		}
+	}
+
+	if len(up.modules) < 1 {
+		r.setOutput("status", "already-current")
+		switch {
+		case opts.FailIfCurrent && group != "":
+			return nil, failf(r.currentCode(), "all modules in group %s are already current", group)
+		case opts.FailIfCurrent:
+			return nil, failf(r.currentCode(), "package %s version %s is already current", path, modules[0].OldVersion)
+		case group != "":
+			r.logger.Info("all modules in group are already current, nothing to do", "group", group)
+		default:
+			r.logger.Info("module is already current, nothing to do", "module", path, "version", modules[0].OldVersion)
+		}
+
+		return nil, nil
+	}
+
+	modules = up.modules
+	newVersion := modules[0].NewVersion
+	r.setOutput("new-version", newVersion)
+
+	// Check that the new version doesn't need a newer Go than the
+	// modules being updated declared, as their builds would then fail.
+	// This is checked against the go directive from before the update,
+	// as go get raises it to match the new requirement.
+	if !goDirective && !tidyOnly && group == "" {
+		dl, err := r.downloadVersion(ctx, path, newVersion)
+		if err != nil {
+			return nil, failf(ExitUpgradeFailed, "error downloading %s@%s: %w", path, newVersion, err)
+		}
+
+		f, err := modinfo.Parse(dl.GoMod)
+		if err != nil {
+			return nil, err
+		}
+
+		up.goRequirement = f.GoVersion()
+		for _, m := range modules {
+			ours := m.goVersion
+			if up.goRequirement == "" || ours == "" || goversion.Compare("go"+up.goRequirement, "go"+ours) <= 0 {
+				continue
+			}
+
+			if opts.IgnoreGoVersion {
+				r.logger.Warn("new version requires a newer Go than the module declares", "module", path, "version", newVersion, "requires", up.goRequirement, "go_mod", filepath.Join(m.Dir, "go.mod"), "declares", ours)
+				continue
+			}
+
+			return nil, r.resetAndFail(ctx, failf(ExitUpgradeFailed, "%s@%s requires go %s, but %s declares go %s\n\nUpdate the go directive first, or use -ignore-go-version to update anyway.", path, newVersion, up.goRequirement, filepath.Join(m.Dir, "go.mod"), ours))
+		}
+	}
+
+	// Update the replacement too, if requested. The same query used for
+	// the module itself is used for the replacement.
+	for _, m := range modules {
+		if m.replace == nil {
+			continue
+		}
+
+		query := "latest"
+		if version != "" {
+			query = version
+		}
+
+		if up.replacementVersion, err = r.resolveVersion(ctx, m.replace.NewPath, query); err != nil {
+			return nil, err
+		}
+
+		if err := r.execDir(ctx, m.dir, "go", "mod", "edit", "-replace="+path+"="+m.replace.NewPath+"@"+up.replacementVersion); err != nil {
+			return nil, fail(ExitUpgradeFailed, err)
+		}
+	}
+
+	// Tidy
+	for _, m := range modules {
+		if err := r.execDir(ctx, m.dir, "go", "mod", "tidy"); err != nil {
+			return nil, fail(ExitUpgradeFailed, err)
+		}
+	}
+
+	// Sync the workspace, so that modules that don't require the path
+	// directly see the same versions.
+	if t.gowork != "" {
+		if err := r.execDir(ctx, filepath.Dir(t.gowork), "go", "work", "sync"); err != nil {
+			return nil, fail(ExitUpgradeFailed, err)
+		}
+	}
+
+	return up, nil
+}
+
+// reportChanges works out what else the upgrade changed in the
+// requirements, vendors and verifies the updated modules, and checks the
+// effect on known vulnerabilities. Changes that are not to be committed
+// fail the update. It returns nil if tidying changed nothing.
+func (r *run) reportChanges(ctx context.Context, t *updateTarget, up *upgradeResult) (*changeReport, error) {
+	opts := r.opts
+	modules := up.modules
+	changes := &changeReport{}
+
+	// The same change in several modules is only reported once. When
+	// updating a group, changes to its members are reported separately.
+	seenChanges := make(map[requirementChange]bool)
+	skip := t.path
+	if t.group != "" {
+		skip = ""
+	}
+
+	for _, m := range modules {
+		if m.requires == nil {
+			continue
+		}
+
+		after, err := requireVersions(m.dir)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, c := range requirementChanges(m.requires, after, skip) {
+			switch {
+			case seenChanges[c]:
+			case inGroup(up.plan.members, c.Path):
+				changes.group = append(changes.group, c)
+			default:
+				changes.sideEffects = append(changes.sideEffects, c)
+			}
+
+			seenChanges[c] = true
+		}
+	}
+
+	// Members that are tool dependencies are marked as such. A group
+	// is only ever updated in a single module.
+	if len(changes.group) > 0 {
+		f, err := modinfo.Load(modules[0].dir)
+		if err != nil {
+			return nil, err
+		}
+
+		for i, c := range changes.group {
+			changes.group[i].Tool = len(f.Tools(c.Path)) > 0
+		}
+	}
+
+	sort.SliceStable(changes.sideEffects, func(i, j int) bool { return changes.sideEffects[i].Path < changes.sideEffects[j].Path })
+
+	// Minimal version selection can move a requirement back, when the
+	// update drops the requirement that held it up. Downgrades are easy
+	// to miss among the other changes, so they are reported on their
+	// own.
+	changes.sideEffects = slices.DeleteFunc(changes.sideEffects, func(c requirementChange) bool {
+		if c.OldVersion == "" || c.NewVersion == "" || semver.Compare(c.NewVersion, c.OldVersion) >= 0 {
+			return false
+		}
+
+		changes.downgrades = append(changes.downgrades, c)
+		return true
+	})
+
+	if len(changes.downgrades) > 0 {
+		var list []string
+		for _, c := range changes.downgrades {
+			list = append(list, c.Path+" "+c.OldVersion+" -> "+c.NewVersion)
+		}
+
+		r.logger.Warn("update downgrades other requirements", "downgrades", list)
+		if opts.FailOnDowngrade {
+			return nil, r.resetAndFail(ctx, failf(ExitUpgradeFailed, "update downgrades other requirements, not committing:\n  %s", strings.Join(list, "\n  ")))
+		}
+	}
+
+	var err error
+	if changes.vendorDirs, changes.vendored, err = r.vendorModules(ctx, modules, t.gowork); err != nil {
+		return nil, err
+	}
+
+	// Check the downloaded modules against go.sum, in the same places
+	// vendoring is done. In a workspace, this covers every module in the
+	// workspace at once.
+	if !opts.NoVerifyModules {
+		for _, dir := range changes.vendorDirs {
+			out, err := gitrepo.CombinedOutput(ctx, r, command(dir, nil, "go", "mod", "verify"))
+			if err != nil {
+				if ctx.Err() != nil {
+					return nil, errInterrupted
+				}
+
+				return nil, r.resetAndFail(ctx, failf(ExitUpgradeFailed, "go mod verify failed, not committing:\n%s", out))
+			}
+		}
+	}
+
+	if up.vulnsBefore != nil {
+		if vulnsAfter := r.moduleVulns(ctx, modules); vulnsAfter != nil {
+			changes.vulnChecked = true
+			changes.vulnsFixed = missingFrom(up.vulnsBefore, vulnsAfter)
+			changes.vulnsIntroduced = missingFrom(vulnsAfter, up.vulnsBefore)
+		}
+
+		if len(changes.vulnsIntroduced) > 0 && opts.VulncheckFailOnNew {
+			return nil, r.resetAndFail(ctx, failf(ExitUpgradeFailed, "update introduces known vulnerabilities, not committing:\n  %s", strings.Join(changes.vulnsIntroduced, "\n  ")))
+		}
+	}
+
+	// When tidying, there is only something to commit if the metadata
+	// changed, and nothing else did.
+	if t.tidyOnly {
+		out, err := r.git().Output(ctx, "status", "--porcelain")
+		if err != nil {
+			return nil, err
+		}
+
+		if len(out) < 1 {
+			r.setOutput("status", "no-changes")
+			return nil, nil
+		}
+
+		if others := nonMetadataChanges(out); len(others) > 0 {
+			return nil, r.resetAndFail(ctx, failf(ExitUpgradeFailed, "tidying changed files other than module metadata, not committing:\n  %s", strings.Join(others, "\n  ")))
+		}
+	}
+
+	return changes, nil
+}
+
+// vendorModules vendors the updated modules, if vendor/modules.txt
+// exists. In a workspace, vendoring is done for the whole workspace at
+// its root, otherwise it's done in each module that is vendored. It
+// returns the directories where vendoring was checked for, and those
+// that were vendored.
+func (r *run) vendorModules(ctx context.Context, modules []*moduleUpdate, gowork string) ([]string, []string, error) {
+	vendorCmd := "mod"
+	var vendorDirs []string
+	if gowork != "" {
+		vendorCmd = "work"
+		vendorDirs = []string{filepath.Dir(gowork)}
+	} else {
+		for _, m := range modules {
+			vendorDirs = append(vendorDirs, m.dir)
+		}
+	}
+
+	var vendored []string
+	for _, dir := range vendorDirs {
+		if _, err := os.Stat(filepath.Join(dir, "vendor", "modules.txt")); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+
+			return nil, nil, err
+		}
+
+		vendored = append(vendored, dir)
+		if err := r.execDir(ctx, dir, "go", vendorCmd, "vendor"); err != nil {
+			return nil, nil, fail(ExitUpgradeFailed, err)
+		}
+	}
+
+	return vendorDirs, vendored, nil
+}
+
+// describeUpdate builds the template data for the update, with links to
+// the release where the module's repository is known, picks the
+// templates to render it with, and names the update branch.
+func (r *run) describeUpdate(ctx context.Context, t *updateTarget, co *checkout, prt *prTarget, up *upgradeResult, changes *changeReport) (*updateDescription, error) {
+	opts := r.opts
+	path, version, commit, group := t.path, t.version, t.commit, t.group
+	goDirective, tidyOnly := t.goDirective, t.tidyOnly
+	modules := up.modules
+	oldVersion, newVersion := modules[0].OldVersion, modules[0].NewVersion
+
+	data := commitTemplateData{
+		Project:    t.project(),
+		Owner:      moduleOwner(path),
+		Path:       path,
+		Target:     up.plan.target,
+		OldVersion: oldVersion,
+		Vendor:     len(changes.vendored) > 0,
+		Prefix:     t.prefix,
+		Date:       t.date,
+
+		Replacement:        t.replacement,
+		ReplacementVersion: up.replacementVersion,
+
+		Tools:       modules[0].tools,
+		ToolTargets: up.toolTargets,
+
+		Workspace: t.gowork != "",
+
+		SideEffects: changes.sideEffects,
+		Downgrades:  changes.downgrades,
+		Skipped:     t.skipped,
+
+		GoRequirement: up.goRequirement,
+		Verified:      !opts.NoVerifyModules,
+
+		VulnChecked:    changes.vulnChecked,
+		VulnFixed:      changes.vulnsFixed,
+		VulnIntroduced: changes.vulnsIntroduced,
+	}
+	if t.gowork != "" || opts.Recursive {
+		data.Modules = modules
+	}
+
+	// The trailers identify the update for other tools. A group has no
+	// one version; its changes are listed in the message.
+	trailers := trailer.Trailers{
+		Module:     path,
+		OldVersion: oldVersion,
+		NewVersion: newVersion,
+		Target:     up.plan.target,
+	}
+	if group != "" {
+		trailers.OldVersion, trailers.NewVersion = "", ""
+	}
+	data.Version = displayVersion(newVersion, commit != "")
+	if q := trackedQuery(version, newVersion); commit == "" && q != "" {
+		data.Version = fromVersion(newVersion)
+		data.Query, data.PseudoVersion = q, newVersion
+	}
+	data.FromVersion = fromVersion(oldVersion)
+
+	if commit != "" {
+		data.Commit = version[:7]
+	}
+
+	commitTmpl := commitTemplate
+	defaultPRTemplate := prBodyTemplate
+	if goDirective {
+		// The version shown is the one being moved to, which is the
+		// toolchain's if only that is being set.
+		data.Version = newVersion
+		if version == "" {
+			data.Version = strings.TrimPrefix(t.toolchain, "go")
+		}
+
+		data.GoVersion = newVersion
+		data.OldToolchain = up.plan.oldToolchain
+		if up.newToolchain != up.plan.oldToolchain {
+			data.Toolchain = up.newToolchain
+		}
+
+		data.URL = goReleaseNotesURL(data.Version)
+		commitTmpl = goCommitTemplate
+		defaultPRTemplate = goPRBodyTemplate
+	}
+
+	if tidyOnly {
+		commitTmpl = tidyCommitTemplate
+		defaultPRTemplate = tidyPRBodyTemplate
+	}
+
+	if group != "" {
+		data.Group = changes.group
+		commitTmpl = groupCommitTemplate
+		defaultPRTemplate = groupPRBodyTemplate
+		if t.listed != nil {
+			commitTmpl = listCommitTemplate
+			defaultPRTemplate = listPRBodyTemplate
+		}
+	}
+
+	prTemplate := t.parsed.prTemplate
+	if prTemplate == nil {
+		prTemplate = defaultPRTemplate
+	}
+
+	// Deprecated modules are still updated, as fixes to them are still
+	// worth having, but reviewers need to know.
+	if !goDirective && !tidyOnly && group == "" {
+		if msg, err := r.deprecation(ctx, path, newVersion); err != nil {
+			r.logger.Warn("could not check whether module is deprecated", "module", path, "error", err)
+		} else if msg != "" {
+			data.Deprecated = msg
+			data.DeprecatedReplacement = deprecatedReplacement(msg)
+			r.logger.Warn("module is deprecated", "module", path, "message", msg)
+		}
+	}
+
+	// Flag any change to the module's license, so that it can be
+	// reviewed.
+	if !goDirective && !tidyOnly && group == "" {
+		oldLicense, err := r.moduleLicense(ctx, path, oldVersion)
+		if err != nil {
+			r.logger.Warn("could not check for license changes", "module", path, "version", oldVersion, "error", err)
+		} else if newLicense, err := r.moduleLicense(ctx, path, newVersion); err != nil {
+			r.logger.Warn("could not check for license changes", "module", path, "version", newVersion, "error", err)
+		} else if !oldLicense.Same(newLicense) {
+			data.OldLicense = oldLicense.String()
+			data.NewLicense = newLicense.String()
+			r.logger.Warn("license change detected", "module", path, "old_license", data.OldLicense, "new_license", data.NewLicense)
+		}
+	}
+
+	// Add release and compare links if the module's repository is on a
+	// recognized host, resolving custom import paths if necessary.
+	// Private modules are left alone, unless asked otherwise, as their
+	// hosts shouldn't be contacted or shown to reviewers.
+	linkRepo := !goDirective && !tidyOnly && group == ""
+	if linkRepo && !opts.ReleaseURL {
+		private, err := r.privateModule(ctx, path)
+		if err != nil {
+			return nil, err
+		}
+
+		if private {
+			r.logger.Info("private module, release link omitted", "module", path)
+			linkRepo = false
+		}
+	}
+
+	if linkRepo {
+		resolver := &modrepo.Resolver{Client: r.httpClient(apiTimeout)}
+		if repo, ok := resolver.Resolve(path); ok {
+			data.Owner = repo.Owner
+			newRef := repoRef(repo, newVersion)
+			if newRef != "" {
+				data.URL = repo.TreeURL(newRef)
+			}
+
+			if oldRef := repoRef(repo, oldVersion); oldRef != "" && newRef != "" {
+				data.CompareURL = repo.CompareURL(oldRef, newRef)
+			}
+
+			// Release notes are only shown in the PR body, and only
+			// exist for tagged versions.
+			if _, ok := releaseTag(newVersion); ok && prt.pr && repo.Host == "github.com" {
+				data.ReleaseNotes = r.gitHub().ReleaseNotes(ctx, repo.Owner, repo.Name, newRef)
+			}
+		}
+	}
+
+	// Name the branch now, so that the post-update commands can use
+	// it, even though it isn't created until the update is committed.
+	//
+	// When updating Go, the version reported is the one shown in the
+	// commit, which accounts for toolchain-only updates.
+	if goDirective {
+		newVersion = data.Version
+	}
+
+	// Tidy branches are named after the changes, so that a run that
+	// produces the same changes finds the existing branch.
+	if tidyOnly {
+		diff, err := r.git().Output(ctx, "diff", "HEAD")
+		if err != nil {
+			return nil, err
+		}
+
+		newVersion = fmt.Sprintf("%x", sha256.Sum256([]byte(diff)))[:12]
+	}
+
+	// Group branches are named after the prefix, along with the set of
+	// versions the members were updated to.
+	if group != "" {
+		h := sha256.New()
+		for _, c := range changes.group {
+			fmt.Fprintf(h, "%s@%s\n", c.Path, c.NewVersion)
+		}
+
+		newVersion = "group-" + fmt.Sprintf("%x", h.Sum(nil))[:12]
+	}
+
+	// A branch or commit query is tracked on a branch named after the
+	// commit it resolved to, rather than the long pseudo-version.
+	branchVersion := newVersion
+	if data.Query != "" {
+		branchVersion = shortHash(newVersion)
+	}
+
+	branch := branchName(t.parsed.branchPrefix, t.project(), branchVersion)
+	if !r.git().ValidBranchName(ctx, branch) {
+		return nil, failf(ExitPrecondition, "update branch name %q is not a valid branch name", branch)
+	}
+
+	r.setOutput("branch", branch)
+
+	data.Branch = branch
+	data.BaseBranch = prt.defaultBranch
+	if data.BaseBranch == "" {
+		data.BaseBranch = co.base
+	}
+
+	if data.BaseBranch == "" && !co.detached {
+		data.BaseBranch = co.oldBranch
+	}
+
+	return &updateDescription{data: data, commitTmpl: commitTmpl, prTmpl: prTemplate, trailers: trailers, newVersion: newVersion}, nil
+}
+
+// reviewUpdate runs the post-update commands, and shows what the update
+// changed. When run by hand, it checks that the update should go ahead,
+// returning false, with the repository reset, if it shouldn't.
+func (r *run) reviewUpdate(ctx context.Context, t *updateTarget, d *updateDescription, modules []*moduleUpdate) (bool, error) {
+	opts := r.opts
+
+	// If we have post-run commands, run them now, in order
+	for _, raw := range t.postCmds {
+		postCmd, err := renderCommand("post-update", raw, d.data)
+		if err != nil {
+			return false, err
+		}
+
+		r.logger.InfoContext(commandCtx, "running post-update command", "command", strings.Join(postCmd, " "))
+		if err := r.runCommand(ctx, postCmd, d.data, ""); err != nil {
+			return false, failf(ExitUpgradeFailed, "error running post-update command: %w", err)
+		}
+	}
+
+	if !opts.ShowDiff && !opts.FullDiff {
+		return true, nil
+	}
+
+	var mods, sums []string
+	for _, m := range modules {
+		mods = append(mods, filepath.Join(m.dir, "go.mod"))
+		sums = append(sums, filepath.Join(m.dir, "go.sum"))
+	}
+
+	diffs := [][]string{{"--no-pager", "diff", "--stat"}, append([]string{"--no-pager", "diff", "--"}, mods...)}
+	if opts.FullDiff {
+		diffs = append(diffs, append([]string{"--no-pager", "diff", "--"}, sums...))
+	}
+
+	for _, args := range diffs {
+		if err := r.git().Run(ctx, args...); err != nil {
+			return false, err
+		}
+	}
+
+	if opts.Yes || !isTerminal(r.stdin) || !isTerminal(r.stdout) {
+		return true, nil
+	}
+
+	fmt.Fprint(r.stdout, "proceed? [y/N] ")
+	answers := make(chan string, 1)
+	go func() {
+		answer, _ := bufio.NewReader(r.stdin).ReadString('\n')
+		answers <- answer
+	}()
+
+	var answer string
+	select {
+	case answer = <-answers:
+	case <-ctx.Done():
+		fmt.Fprintln(r.stdout)
+		return false, errInterrupted
+	}
+
+	if a := strings.ToLower(strings.TrimSpace(answer)); a == "y" || a == "yes" {
+		return true, nil
+	}
+
+	for _, args := range [][]string{{"reset", "-q", "--hard", "HEAD"}, r.cleanArgs()} {
+		if err := r.git().Run(ctx, args...); err != nil {
+			return false, failf(ExitGitFailed, "could not reset repository back to original state: %w", err)
+		}
+	}
+
+	r.setOutput("status", "declined")
+	r.logger.Info("update declined, repository reset")
+	return false, nil
+}
+
+// commitUpdate checks the update branch doesn't exist already, runs the
+// verify commands, and commits the update on the branch. It returns nil
+// if there is nothing to commit, or the branch exists.
+func (r *run) commitUpdate(ctx context.Context, t *updateTarget, co *checkout, prt *prTarget, d *updateDescription, up *upgradeResult, changes *changeReport) (*commitResult, error) {
+	opts := r.opts
+	data := d.data
+	branch := data.Branch
+	author, committer := opts.Author, opts.Committer
+
+	env, err := r.pushEnv(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	remoteBranchSHA, exists, err := r.checkUpdateBranch(ctx, t, prt, d, up.modules[0].dir, env)
+	if err != nil {
+		return nil, err
+	}
+
+	if exists {
+		r.setOutput("status", "branch-exists")
+		return nil, r.resetAndFail(ctx, nil)
+	}
+
+	if len(opts.VerifyCmds) > 0 {
+		if err := r.runVerifyCommands(ctx, data); err != nil {
+			return nil, err
+		}
+	}
+
+	// A remote branch being replaced may also exist locally.
+	r.startPhase("commit")
+	checkoutFlag := "-b"
+	if remoteBranchSHA != "" {
+		checkoutFlag = "-B"
+	}
+
+	if err := r.git().Run(ctx, "checkout", checkoutFlag, branch); err != nil {
+		return nil, fail(ExitGitFailed, err)
+	}
+
+	// From here until the commit is made, failures put the repository
+	// back the way it was.
+	if !opts.NoRollback {
+		r.rollback = r.rollbackBranch(ctx, co.oldBranch, co.oldHead, branch)
+	}
+
+	// Stage only the files the update is expected to change: module
+	// metadata, vendored code, and anything given with -add. In a
+	// workspace, go work sync can change any module in it.
+	stageDirs := changes.vendorDirs
+	if t.gowork != "" {
+		if stageDirs, err = modinfo.WorkspaceModules(t.gowork); err != nil {
+			return nil, fail(ExitGitFailed, err)
+		}
+
+		stageDirs = append(stageDirs, filepath.Dir(t.gowork))
+	}
+
+	var stage []string
+	for _, dir := range stageDirs {
+		for _, name := range []string{"go.mod", "go.sum", "go.work", "go.work.sum"} {
+			stage = append(stage, filepath.Join(dir, name))
+		}
+	}
+
+	for _, dir := range changes.vendored {
+		stage = append(stage, filepath.Join(dir, "vendor"))
+	}
+
+	stage = append(stage, opts.Add...)
+	if err := r.stagePaths(ctx, stage); err != nil {
+		return nil, fail(ExitGitFailed, err)
+	}
+
+	// Untracked files present before the update aren't worth warning
+	// about, so untracked files are listed individually to be able to
+	// leave those out.
+	out, err := r.git().Output(ctx, "status", "--porcelain", "--untracked-files=all")
+	if err != nil {
+		return nil, fail(ExitGitFailed, err)
+	}
+
+	var unstaged []string
+	for _, p := range unstagedChanges(out) {
+		if !slices.Contains(r.keepUntracked, p) {
+			unstaged = append(unstaged, p)
+		}
+	}
+
+	if len(unstaged) > 0 {
+		r.logger.Warn("changes that are not part of the update were left uncommitted (use -add to include them)", "paths", unstaged)
+	}
+
+	// Tidying, vendoring, or a post-update command can undo the
+	// changes made by go get, leaving nothing to commit. The update
+	// branch is removed, as it would be empty.
+	if err := r.Run(ctx, command("", nil, "git", "diff", "--cached", "--quiet")); err == nil {
+		r.rollback = nil
+		r.rollbackBranch(ctx, co.oldBranch, co.oldHead, branch)()
+		r.setOutput("status", "no-changes")
+		r.logger.Info("no effective changes after update, nothing to commit")
+		return nil, nil
+	} else if gitrepo.ExitCode(err) != 1 {
+		return nil, fail(ExitGitFailed, err)
+	}
+
+	// Pre-commit hooks run on the update branch, so anything they stage
+	// is committed along with the update.
+	if err := r.runHooks(ctx, "pre-commit", opts.PreCommitHooks, data); err != nil {
+		return nil, failf(ExitUpgradeFailed, "error running pre-commit hook: %w", err)
+	}
+
+	b := new(bytes.Buffer)
+	if err := d.commitTmpl.Execute(b, data); err != nil {
+		return nil, fail(ExitGitFailed, err)
+	}
+
+	// Save the commit title first, for possible use in a PR, and render
+	// the PR title, if it has its own template, and body.
+	title := strings.SplitN(b.String(), "\n\n", 2)[0]
+	if t.parsed.prTitleTemplate != nil && (prt.pr || prt.azurePR) {
+		if title, err = renderPRTitle(t.parsed.prTitleTemplate, data); err != nil {
+			return nil, fmt.Errorf("error rendering PR title template: %w", err)
+		}
+	}
+
+	prBody := new(bytes.Buffer)
+	if err := d.prTmpl.Execute(prBody, data); err != nil {
+		return nil, fmt.Errorf("error rendering PR template: %w", err)
+	}
+
+	// The trailers are added after rendering, so that a custom template
+	// can't leave them out, and the PR body never has them.
+	trailers := d.trailers
+	trailers.Deprecated = data.Deprecated != ""
+	message := trailer.Append(b.String(), trailers)
+
+	commitArgs := []string{"commit", "-F", "-"}
+	if co.sign {
+		commitArgs = append(commitArgs, "-S")
+	}
+
+	if opts.SignKey != "" {
+		commitArgs = append([]string{"-c", "user.signingkey=" + opts.SignKey}, commitArgs...)
+	}
+
+	// In GitHub Actions, runners often have no git identity at all, so
+	// fall back to the Actions bot's.
+	if os.Getenv("GITHUB_ACTIONS") == "true" {
+		name, err := r.git().Config(ctx, "user.name")
+		if err != nil {
+			return nil, err
+		}
+
+		email, err := r.git().Config(ctx, "user.email")
+		if err != nil {
+			return nil, err
+		}
+
+		if name == "" && email == "" {
+			if author == "" {
+				author = gitHubActionsIdentity
+			}
+
+			if committer == "" {
+				committer = gitHubActionsIdentity
+			}
+		}
+	}
+
+	var commitEnv []string
+	if author != "" {
+		commitEnv = append(commitEnv, identityEnv("AUTHOR", author)...)
+	}
+
+	if committer != "" {
+		commitEnv = append(commitEnv, identityEnv("COMMITTER", committer)...)
+	}
+
+	if err := r.git().RunInput(ctx, commitEnv, strings.NewReader(message), commitArgs...); err != nil {
+		if opts.NoRollback {
+			return nil, fail(ExitGitFailed, fmt.Errorf("%w\n\nWARNING: repository is in an unclean state; please correct before trying again", err))
+		}
+
+		return nil, fail(ExitGitFailed, err)
+	}
+
+	r.rollback = nil
+
+	// The commit is needed to look up its checks once it is pushed.
+	commitSHA, err := r.git().Head(ctx)
+	if err != nil {
+		return nil, fail(ExitGitFailed, err)
+	}
+
+	r.setOutput("commit-sha", commitSHA)
+	return &commitResult{
+		sha:             commitSHA,
+		env:             env,
+		remoteBranchSHA: remoteBranchSHA,
+		title:           title,
+		prBody:          strings.TrimSpace(prBody.String()),
+	}, nil
+}
+
+// checkUpdateBranch checks whether the update branch exists: on the
+// remote if we are pushing, or otherwise locally, as the remote may not
+// be reachable at all. It returns true if it does, in which case the
+// update is abandoned.
+//
+// A remote branch that is left over from a run that failed before its
+// PR was created, or that doesn't contain the update, is replaced
+// instead, and the commit it is at returned. This can only be told when
+// PRs can be looked up. dir is the module the update is checked for on
+// the branch.
+func (r *run) checkUpdateBranch(ctx context.Context, t *updateTarget, prt *prTarget, d *updateDescription, dir string, env []string) (string, bool, error) {
+	branch := d.data.Branch
+	if !r.opts.Push {
+		exists, err := r.git().BranchExists(ctx, branch)
+		if err != nil {
+			return "", false, err
+		}
+
+		if exists {
+			r.logger.Info("local branch already exists, exiting; this could possibly be due to a pending update", "branch", branch)
+		}
+
+		return "", exists, nil
+	}
+
+	out, err := r.git().OutputEnv(ctx, env, "ls-remote", "--heads", prt.pushRemote, branch)
+	if err != nil {
+		return "", false, failf(ExitGitFailed, "error checking for remote branch: %w", err)
+	}
+
+	if len(out) < 1 {
+		return "", false, nil
+	}
+
+	var remoteBranchSHA, stale string
+	if prt.pr && prt.defaultBranch != "" {
+		f := strings.Fields(out)
+		hasUpdate, err := r.remoteBranchHasUpdate(ctx, prt.pushRemote, branch, dir, t.path, d.newVersion, t.goDirective || t.tidyOnly || t.group != "", env)
+		if err != nil {
+			return "", false, err
+		}
+
+		switch {
+		case !hasUpdate:
+			stale = "does not contain the update"
+			remoteBranchSHA = f[0]
+
+		case !r.openPRExists(ctx, prt.remoteOwner, prt.remoteRepo, prt.headOwner+":"+branch):
+			stale = "has no open pull request"
+			remoteBranchSHA = f[0]
+		}
+	}
+
+	if stale == "" {
+		r.logger.Info("remote branch for version already exists, exiting; this could possibly be due to a pending update", "branch", branch, "details", strings.TrimSpace(out))
+		return "", true, nil
+	}
+
+	r.logger.Info("remote branch already exists, but "+stale+"; it will be replaced", "branch", branch)
+	return remoteBranchSHA, false, nil
+}
+
+// runVerifyCommands runs the verify commands, which gate the commit.
+// Anything they change in the tree is discarded, so the state of the
+// tree is recorded first by staging it.
+func (r *run) runVerifyCommands(ctx context.Context, data commitTemplateData) error {
+	r.startPhase("verify")
+	if err := r.git().Run(ctx, append([]string{"add", "--all", "--", ":/"}, r.excludeUntracked()...)...); err != nil {
+		return fail(ExitGitFailed, err)
+	}
+
+	tree, err := r.git().Output(ctx, "write-tree")
+	if err != nil {
+		return fail(ExitGitFailed, err)
+	}
+
+	tree = strings.TrimSpace(tree)
+	for _, raw := range r.opts.VerifyCmds {
+		verifyCmd, err := renderCommand("verify", raw, data)
+		if err != nil {
+			return err
+		}
+
+		r.logger.InfoContext(commandCtx, "running verify command", "command", strings.Join(verifyCmd, " "))
+		if err := r.runCommand(ctx, verifyCmd, data, ""); err != nil {
+			if ctx.Err() != nil {
+				return errInterrupted
+			}
+
+			r.logger.Warn("verify command failed", "command", strings.Join(verifyCmd, " "), "error", err)
+			for _, args := range [][]string{{"reset", "--hard", "HEAD"}, r.cleanArgs()} {
+				if err := r.git().Run(ctx, args...); err != nil {
+					return failf(ExitGitFailed, "could not reset repository back to original state: %w", err)
+				}
+			}
+
+			r.setOutput("status", "verify-failed")
+			return failf(ExitVerifyFailed, "update failed verification, not committing")
+		}
+	}
+
+	if err := r.git().Run(ctx, "read-tree", "--reset", "-u", tree); err != nil {
+		return fail(ExitGitFailed, err)
+	}
+
+	if err := r.git().Run(ctx, r.cleanArgs()...); err != nil {
+		return fail(ExitGitFailed, err)
+	}
+
+	// Only the tree was needed, the files to commit are staged
+	// separately.
+	if err := r.git().Run(ctx, "reset", "-q"); err != nil {
+		return fail(ExitGitFailed, err)
+	}
+
+	return nil
+}
+
+// pushAndOpenPR pushes the update branch, returns to the branch the run
+// started on, and opens the pull request for the update, reporting the
+// outcome.
+func (r *run) pushAndOpenPR(ctx context.Context, t *updateTarget, co *checkout, prt *prTarget, d *updateDescription, c *commitResult) error {
+	opts := r.opts
+	push := opts.Push
+	deleteLocalBranch := opts.DeleteLocalBranch
+	data := d.data
+	branch := data.Branch
+	pushRemote, defaultBranch := prt.pushRemote, prt.defaultBranch
+	remoteOwner, remoteRepo := prt.remoteOwner, prt.remoteRepo
+
+	// Push to the remote.
+	if push {
+		r.startPhase("push")
+		pushArgs := []string{"push"}
+		for _, o := range opts.PushOptions {
+			r.logger.Debug("using push option", "option", o)
+			pushArgs = append(pushArgs, "-o", o)
+		}
+
+		// Only replace the remote branch if it is still the one that
+		// was checked.
+		if c.remoteBranchSHA != "" {
+			pushArgs = append(pushArgs, "--force-with-lease=refs/heads/"+branch+":"+c.remoteBranchSHA)
+		}
+
+		// Track the remote branch, so that it can be pulled and pushed
+		// to after switching to it.
+		pushArgs = append(pushArgs, "--set-upstream", pushRemote, branch)
+		if err := r.git().RunEnv(ctx, c.env, pushArgs...); err != nil {
+			return fail(ExitGitFailed, fmt.Errorf("%w\n\nWARNING: commit succeeded but push failed; push manually to correct", err))
+		}
+
+		// The branch is already pushed, so a failing hook can't stop
+		// anything.
+		if err := r.runHooks(ctx, "post-push", opts.PostPushHooks, data); err != nil {
+			r.logger.Warn("post-push hook failed", "error", err)
+		}
+	}
+
+	// Checkout old branch, or the commit HEAD was detached at, without
+	// the advice git gives for detaching.
+	if err := r.git().Run(ctx, "-c", "advice.detachedHead=false", "checkout", co.oldBranch); err != nil {
+		return fail(ExitGitFailed, fmt.Errorf("%w\n\nWARNING: update succeeded, but cannot checkout old branch", err))
+	}
+
+	// The pushed branch is the copy that matters, so the local one can
+	// go. It is always kept if it wasn't pushed.
+	if push && deleteLocalBranch {
+		if err := r.git().Run(ctx, "branch", "-q", "-D", branch); err != nil {
+			r.logger.Warn("could not delete local branch", "branch", branch, "error", err)
+			deleteLocalBranch = false
+		}
+	}
+
+	// Submit PR
+	var prURL string
+	var prExisted bool
+	var err error
+	if prt.pr && defaultBranch != "" {
+		r.startPhase("pull-request")
+		r.logger.Info("creating pull request", "branch", branch, "base", defaultBranch)
+		// A branch that was replaced may still have the pull request
+		// for the update it used to contain.
+		prURL, prExisted, err = r.createPR(ctx, remoteOwner, remoteRepo, forge.NewPR{
+			Title: c.title,
+			Body:  c.prBody,
+			Head:  prt.headOwner + ":" + branch,
+			Base:  defaultBranch,
+		}, c.remoteBranchSHA != "")
+		if err != nil {
+			return fail(ExitPRFailed, err)
+		}
+	} else if prt.azurePR && defaultBranch != "" {
+		r.startPhase("pull-request")
+		r.logger.Info("creating pull request", "branch", branch, "base", defaultBranch)
+		az := &forge.Azure{Client: r.client, Token: opts.AzureToken, Logger: r.logger}
+		if prURL, err = az.CreatePR(ctx, prt.azureTarget, branch, defaultBranch, c.title, c.prBody); err != nil {
+			return failf(ExitPRFailed, "error creating pull request: %w", err)
+		}
+	} else if prt.pr || prt.azurePR {
+		r.logger.Warn("no remote default branch found, cannot submit pull request")
+	}
+
+	if prURL != "" {
+		data.PRURL = prURL
+		if err := r.runHooks(ctx, "post-pr", opts.PostPRHooks, data); err != nil {
+			r.logger.Warn("post-pr hook failed", "error", err)
+		}
+	}
+
+	// Turning on the repository setting needs admin access, which
+	// depbump's token may well not have.
+	if opts.DeleteBranchOnMerge && prt.pr && prURL != "" {
+		if err := r.gitHub().SetDeleteBranchOnMerge(ctx, remoteOwner, remoteRepo); err != nil {
+			r.logger.Warn("could not have GitHub delete branches on merge; merged update branches can be deleted with \"depbump cleanup\"", "error", err)
+		}
+	}
+
+	if opts.Supersede && prt.pr && prURL != "" {
+		r.supersedePRs(ctx, remoteOwner, remoteRepo, prt.headOwner+"/"+prt.headRepo, pushRemote, t.parsed.branchPrefix, t.project(), branch, prURL, c.env)
+	}
+
+	if t.tidyOnly {
+		r.logger.InfoContext(successCtx, "module metadata successfully tidied", "branch", branch, "commit", c.sha)
+	} else if t.group != "" {
+		r.logger.InfoContext(successCtx, "modules in group successfully updated", "group", t.group, "branch", branch, "commit", c.sha)
+	} else {
+		r.logger.InfoContext(successCtx, "module successfully updated", "module", t.path, "old_version", data.OldVersion, "new_version", d.newVersion, "branch", branch, "commit", c.sha)
+	}
+	if push {
+		r.setOutput("remote-ref", pushRemote+"/"+branch)
+	}
+	if push && deleteLocalBranch {
+		r.logger.Info("branch pushed, and deleted locally", "branch", branch, "remote_ref", pushRemote+"/"+branch)
+	} else if push {
+		r.logger.Info("branch pushed, and tracking the remote branch", "branch", branch, "remote_ref", pushRemote+"/"+branch)
+	} else {
+		r.logger.Info("branch committed locally, but not pushed", "branch", branch, "push_command", "git push --set-upstream "+r.remote+" "+branch)
+	}
+	if prURL != "" {
+		r.setOutput("pr-url", prURL)
+		r.setOutput("pr-number", forge.PRNumber(prURL))
+	}
+
+	if prExisted {
+		r.logger.InfoContext(successCtx, "pull request already exists", "pr_url", prURL)
+	} else if prURL != "" {
+		r.logger.InfoContext(successCtx, "pull request has been created", "pr_url", prURL)
+		r.notifySlack(ctx, r.slackPRMessage(prURL))
+	}
+
+	if opts.WaitForChecks > 0 {
+		if prURL == "" || !prt.pr {
+			r.logger.Warn("no GitHub pull request was created, not waiting for checks")
+		} else {
+			r.startPhase("checks")
+			ok, err := r.waitForChecks(ctx, remoteOwner, remoteRepo, defaultBranch, c.sha, opts.WaitForChecks)
+			if err != nil {
+				return err
+			}
+
+			if !ok {
+				r.setOutput("status", "checks-failed")
+				return failf(ExitChecksFailed, "checks on the pull request did not pass")
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,831 @@
+package bump
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"os/exec"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vancluever/depbump/internal/constraint"
+	"github.com/vancluever/depbump/internal/gitrepo"
+	"github.com/vancluever/depbump/internal/license"
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+)
+
+// Type from "go help list", for "go list -m -json".
+type listModule struct {
+	Path       string
+	Version    string
+	Versions   []string   // available module versions (with -versions)
+	Time       *time.Time // time version was created
+	Retracted  []string   // retraction information, if any (with -retracted or -u)
+	Deprecated string     // deprecation message, if any (with -u)
+}
+
+// Type from "go help mod download", for "go mod download -json".
+type downloadModule struct {
+	Path    string
+	Version string
+	GoMod   string // absolute path to cached .mod file
+	Dir     string // absolute path to cached source root directory
+}
+
+// downloadVersion downloads the module path at version to the module
+// cache, and returns where it was stored.
+func (r *run) downloadVersion(ctx context.Context, path, version string) (downloadModule, error) {
+	var m downloadModule
+	out, err := r.output(ctx, command("", nil, "go", "mod", "download", "-json", path+"@"+version))
+	if err != nil {
+		return m, err
+	}
+
+	err = json.Unmarshal(out, &m)
+	return m, err
+}
+
+// govulncheckPackage is run with "go run" when govulncheck isn't
+// installed.
+const govulncheckPackage = "golang.org/x/vuln/cmd/govulncheck@latest"
+
+// vulnFindings runs govulncheck on the module in dir, and returns the
+// IDs of the vulnerabilities found.
+func (r *run) vulnFindings(ctx context.Context, dir string) (map[string]bool, error) {
+	c := command(dir, nil, "go", "run", govulncheckPackage, "-format", "json", "./...")
+	if p, err := exec.LookPath("govulncheck"); err == nil {
+		c = command(dir, nil, p, "-format", "json", "./...")
+	}
+
+	out, err := r.output(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+
+	// The output is a stream of messages, of which only findings are
+	// of interest.
+	result := make(map[string]bool)
+	dec := json.NewDecoder(bytes.NewReader(out))
+	for dec.More() {
+		var msg struct {
+			Finding *struct {
+				OSV string `json:"osv"`
+			} `json:"finding"`
+		}
+
+		if err := dec.Decode(&msg); err != nil {
+			return nil, err
+		}
+
+		if msg.Finding != nil {
+			result[msg.Finding.OSV] = true
+		}
+	}
+
+	return result, nil
+}
+
+// moduleVulns returns the IDs of the vulnerabilities found in any of
+// modules. If govulncheck can't be run, a warning is logged and nil is
+// returned.
+func (r *run) moduleVulns(ctx context.Context, modules []*moduleUpdate) map[string]bool {
+	result := make(map[string]bool)
+	for _, m := range modules {
+		found, err := r.vulnFindings(ctx, m.dir)
+		if err != nil {
+			r.logger.Warn("could not run govulncheck, skipping vulnerability check", "error", err)
+			return nil
+		}
+
+		for id := range found {
+			result[id] = true
+		}
+	}
+
+	return result
+}
+
+// missingFrom returns the keys of a that are not in b, sorted.
+func missingFrom(a, b map[string]bool) []string {
+	var result []string
+	for k := range a {
+		if !b[k] {
+			result = append(result, k)
+		}
+	}
+
+	sort.Strings(result)
+	return result
+}
+
+// moduleLicense returns the license of the module path at version, or
+// the zero License if it doesn't have one.
+func (r *run) moduleLicense(ctx context.Context, path, version string) (license.License, error) {
+	dl, err := r.downloadVersion(ctx, path, version)
+	if err != nil {
+		return license.License{}, err
+	}
+
+	l, _, err := license.Find(dl.Dir)
+	return l, err
+}
+
+// goVersionRe matches a Go release version, without any "go" or "v"
+// prefix.
+var goVersionRe = regexp.MustCompile(`^(\d+)\.(\d+)(\.(\d+)|rc\d+)?$`)
+
+// goReleaseNotesURL returns the URL of the release notes for the Go
+// release version.
+func goReleaseNotesURL(version string) string {
+	m := goVersionRe.FindStringSubmatch(version)
+	if m == nil {
+		return "https://go.dev/doc/devel/release"
+	}
+
+	if m[4] != "" && m[4] != "0" {
+		// Minor release, these are documented in the release history.
+		return "https://go.dev/doc/devel/release#go" + m[1] + "." + m[2] + ".minor"
+	}
+
+	return "https://go.dev/doc/go" + m[1] + "." + m[2]
+}
+
+// resolveVersion resolves a module query (path@query) to a concrete
+// version.
+func (r *run) resolveVersion(ctx context.Context, path, query string) (string, error) {
+	out, err := r.output(ctx, command("", nil, "go", "list", "-m", "-json", path+"@"+query))
+	if err != nil {
+		return "", failf(ExitUpgradeFailed, "error resolving %s@%s: %w", path, query, err)
+	}
+
+	var m listModule
+	if err := json.Unmarshal(out, &m); err != nil {
+		return "", err
+	}
+
+	return m.Version, nil
+}
+
+// checkVersionExists returns an error if version, a release or pre-release that is
+// to be updated to, isn't one of the tagged versions of path. The
+// closest versions that do exist are suggested instead. If the versions
+// can't be listed, go get is left to report the problem.
+func (r *run) checkVersionExists(ctx context.Context, path, version string) error {
+	out, err := gitrepo.Output(ctx, r, command("", nil, "go", "list", "-m", "-versions", "-retracted", "-json", path))
+	if err != nil {
+		r.logger.Debug("could not list versions", "module", path, "error", err)
+		return nil
+	}
+
+	var m listModule
+	if err := json.Unmarshal(out, &m); err != nil {
+		return err
+	}
+
+	if slices.Contains(m.Versions, version) {
+		return nil
+	}
+
+	closest := closestVersions(m.Versions, version, 3)
+	if len(closest) < 1 {
+		return failf(ExitPrecondition, "%s has no version %s, and no tagged versions at all", path, version)
+	}
+
+	return failf(ExitPrecondition, "%s has no version %s; the closest versions are:\n  %s", path, version, strings.Join(closest, "\n  "))
+}
+
+// availableVersions returns the tagged versions of path, in semver
+// order, leaving out retracted versions. Pre-releases are left out too,
+// unless pre is set.
+func (r *run) availableVersions(ctx context.Context, path string, pre bool) ([]string, error) {
+	out, err := r.output(ctx, command("", nil, "go", "list", "-m", "-versions", "-json", path))
+	if err != nil {
+		return nil, failf(ExitUpgradeFailed, "error listing versions of %s: %w", path, err)
+	}
+
+	var m listModule
+	if err := json.Unmarshal(out, &m); err != nil {
+		return nil, err
+	}
+
+	versions := m.Versions
+	if !pre {
+		versions = slices.DeleteFunc(versions, func(v string) bool { return semver.Prerelease(v) != "" })
+	}
+
+	semver.Sort(versions)
+	return versions, nil
+}
+
+// versionTime returns when version of path was published, as recorded
+// by the module proxy, or the zero time if it isn't known.
+func (r *run) versionTime(ctx context.Context, path, version string) (time.Time, error) {
+	out, err := r.output(ctx, command("", nil, "go", "list", "-m", "-json", path+"@"+version))
+	if err != nil {
+		return time.Time{}, failf(ExitUpgradeFailed, "error resolving %s@%s: %w", path, version, err)
+	}
+
+	var m listModule
+	if err := json.Unmarshal(out, &m); err != nil {
+		return time.Time{}, err
+	}
+
+	if m.Time == nil {
+		return time.Time{}, nil
+	}
+
+	return *m.Time, nil
+}
+
+// formatAge returns how long ago t was, in days if it was more than a
+// day ago.
+func formatAge(t time.Time) string {
+	age := time.Since(t)
+	if age < 24*time.Hour {
+		return age.Round(time.Minute).String()
+	}
+
+	return fmt.Sprintf("%d days", int(age/(24*time.Hour)))
+}
+
+// constraintVersion returns the highest available version of path that
+// satisfies c and isn't skipped, or an error if there isn't one. The newer
+// matching versions that were skipped are returned too.
+func constraintVersion(logger *slog.Logger, path string, c *constraint.Constraint, pre bool, skips []versionSkip, lookup versionLookup) (string, []versionSkip, error) {
+	versions, err := lookup.available(pre)
+	if err != nil {
+		return "", nil, err
+	}
+
+	matching := slices.DeleteFunc(slices.Clone(versions), func(v string) bool { return !c.Match(v) })
+	if len(matching) < 1 {
+		if len(versions) < 1 {
+			return "", nil, failf(ExitNoMatch, "no version of %s matches %q, as it has no tagged versions", path, c)
+		}
+
+		return "", nil, failf(ExitNoMatch, "no version of %s matches %q; the versions considered were:\n  %s", path, c, strings.Join(versions, "\n  "))
+	}
+
+	version, skipped := pickVersion(logger, path, matching, skips)
+	if version == "" {
+		return "", nil, failf(ExitNoMatch, "every version of %s matching %q is skipped with -skip-version", path, c)
+	}
+
+	logger.Debug("picked version matching constraint", "module", path, "constraint", c, "version", version)
+	return version, skipped, nil
+}
+
+// versionLookup looks up the versions of the module being updated, for
+// selectVersion. resolve resolves a query, such as "upgrade", to a
+// version; available returns the tagged versions as availableVersions
+// does; and published returns when a version was published, or the zero
+// time if it isn't known.
+type versionLookup struct {
+	resolve   func(query string) (string, error)
+	available func(pre bool) ([]string, error)
+	published func(version string) (time.Time, error)
+}
+
+// versionLookup returns the lookups of the versions of path, through
+// the go command.
+func (r *run) versionLookup(ctx context.Context, path string) versionLookup {
+	return versionLookup{
+		resolve:   func(query string) (string, error) { return r.resolveVersion(ctx, path, query) },
+		available: func(pre bool) ([]string, error) { return r.availableVersions(ctx, path, pre) },
+		published: func(version string) (time.Time, error) { return r.versionTime(ctx, path, version) },
+	}
+}
+
+// versionRequest is what selectVersion picks a version for: the module
+// at path, currently at current, with the version given for it, if
+// any, and the options that rule versions out.
+type versionRequest struct {
+	path, current, version string
+
+	constraint *constraint.Constraint
+	pre        bool
+	skips      []versionSkip
+
+	// minAge is how long ago a version must have been published, as
+	// of now.
+	minAge time.Duration
+	now    time.Time
+}
+
+// versionChoice is the version selectVersion picked, or "" to leave it
+// to go get, along with the newer versions that were skipped. If there
+// is nothing newer to update to, current says why instead.
+type versionChoice struct {
+	version string
+	skipped []versionSkip
+	current string
+}
+
+// selectVersion picks the version of a single module to update to. A
+// constraint picks it as if it had been given with -version, and
+// versions that are skipped, or too recent for -min-age, are passed over
+// for the newest one below them that isn't. Versions below the current
+// one are never picked, and an explicitly given version is only checked
+// against the skips.
+func selectVersion(logger *slog.Logger, req versionRequest, lookup versionLookup) (versionChoice, error) {
+	path, oldVersion := req.path, req.current
+	choice := versionChoice{version: req.version}
+	explicitVersion := req.version != "" && !versionKeywords[req.version]
+	versionQuery := "upgrade"
+	if versionKeywords[req.version] {
+		versionQuery = req.version
+	}
+
+	// Matching versions below the current one are not downgraded to.
+	if req.constraint != nil {
+		var err error
+		if choice.version, choice.skipped, err = constraintVersion(logger, path, req.constraint, req.pre, req.skips, lookup); err != nil {
+			return versionChoice{}, err
+		}
+
+		if semver.Compare(choice.version, oldVersion) < 0 {
+			choice.current = fmt.Sprintf("package %s is at version %s, newer than %s, the newest version matching %q", path, oldVersion, choice.version, req.constraint)
+			return choice, nil
+		}
+	}
+
+	// When the version go get would pick is skipped, the newest one
+	// below it that isn't is picked instead.
+	if req.constraint == nil && len(req.skips) > 0 {
+		if explicitVersion {
+			if s, ok := skipFor(req.skips, path, req.version); ok {
+				return versionChoice{}, failf(ExitPrecondition, "%s %s is skipped with -skip-version%s", path, req.version, skipReasonSuffix(s))
+			}
+		} else {
+			latest, err := lookup.resolve(versionQuery)
+			if err != nil {
+				return versionChoice{}, err
+			}
+
+			if _, ok := skipFor(req.skips, path, latest); ok {
+				available, err := lookup.available(semver.Prerelease(latest) != "")
+				if err != nil {
+					return versionChoice{}, err
+				}
+
+				candidates := slices.DeleteFunc(available, func(v string) bool {
+					return semver.Compare(v, latest) > 0 || semver.Compare(v, oldVersion) < 0
+				})
+
+				choice.version, choice.skipped = pickVersion(logger, path, candidates, req.skips)
+				if choice.version == "" || choice.version == oldVersion {
+					choice.current = fmt.Sprintf("package %s is at version %s, and the newer versions are skipped", path, oldVersion)
+					return choice, nil
+				}
+			}
+		}
+	}
+
+	// Versions given with -version or -commit are used regardless of
+	// their age.
+	if req.minAge <= 0 || explicitVersion {
+		return choice, nil
+	}
+
+	candidate := choice.version
+	if candidate == "" || versionKeywords[candidate] {
+		var err error
+		if candidate, err = lookup.resolve(versionQuery); err != nil {
+			return versionChoice{}, err
+		}
+	}
+
+	cutoff := req.now.Add(-req.minAge)
+	var published time.Time
+	if candidate != oldVersion {
+		var err error
+		if published, err = lookup.published(candidate); err != nil {
+			return versionChoice{}, err
+		}
+	}
+
+	switch {
+	case candidate == oldVersion:
+
+	case published.IsZero():
+		logger.Warn("publish time of version is not known, assuming it is old enough for -min-age", "module", path, "version", candidate)
+
+	case published.After(cutoff):
+		logger.Info("newest version is too recent for -min-age", "module", path, "version", candidate, "age", formatAge(published))
+		available, err := lookup.available(req.pre || semver.Prerelease(candidate) != "")
+		if err != nil {
+			return versionChoice{}, err
+		}
+
+		candidates := slices.DeleteFunc(available, func(v string) bool {
+			if _, ok := skipFor(req.skips, path, v); ok {
+				return true
+			}
+
+			if req.constraint != nil && !req.constraint.Match(v) {
+				return true
+			}
+
+			return semver.Compare(v, candidate) >= 0 || semver.Compare(v, oldVersion) <= 0
+		})
+
+		choice.version = ""
+		for i := len(candidates) - 1; i >= 0; i-- {
+			t, err := lookup.published(candidates[i])
+			if err != nil {
+				return versionChoice{}, err
+			}
+
+			if !t.After(cutoff) {
+				candidate, choice.version, published = candidates[i], candidates[i], t
+				break
+			}
+
+			logger.Debug("version is too recent for -min-age", "module", path, "version", candidates[i], "age", formatAge(t))
+		}
+
+		if choice.version == "" {
+			choice.current = fmt.Sprintf("nothing eligible: no version of %s newer than %s was published at least %s ago", path, oldVersion, formatMinAge(req.minAge))
+			return choice, nil
+		}
+
+		fallthrough
+
+	default:
+		logger.Debug("picked version old enough for -min-age", "module", path, "version", candidate, "published", published, "age", formatAge(published))
+	}
+
+	return choice, nil
+}
+
+// formatMinAge returns d, the minimum age of versions, as it would be
+// given on the command line: in days if it is a whole number of them.
+func formatMinAge(d time.Duration) string {
+	if d > 0 && d%(24*time.Hour) == 0 {
+		return fmt.Sprintf("%dd", d/(24*time.Hour))
+	}
+
+	return d.String()
+}
+
+// versionSkip is a version given with -skip-version, or in a
+// -skip-file, that is never updated to. Path is empty if it applies to
+// any module.
+type versionSkip struct {
+	Path    string
+	Version string
+	Reason  string
+}
+
+// parseSkip parses a version to skip, in the form
+// [PATH@]VERSION[: REASON].
+func parseSkip(s string) (versionSkip, error) {
+	spec, reason, _ := strings.Cut(s, ":")
+	var skip versionSkip
+	skip.Reason = strings.TrimSpace(reason)
+	skip.Version = strings.TrimSpace(spec)
+	if p, v, ok := strings.Cut(skip.Version, "@"); ok {
+		if err := module.CheckPath(p); err != nil {
+			return versionSkip{}, err
+		}
+
+		skip.Path, skip.Version = p, v
+	}
+
+	if !strings.HasPrefix(skip.Version, "v") {
+		skip.Version = "v" + skip.Version
+	}
+
+	if !semver.IsValid(skip.Version) {
+		return versionSkip{}, fmt.Errorf("invalid version %q", strings.TrimPrefix(skip.Version, "v"))
+	}
+
+	return skip, nil
+}
+
+// readSkipFile reads the versions to skip from the -skip-file at name,
+// one per line in the same form as -skip-version. Blank lines and lines
+// starting with # are ignored.
+func readSkipFile(name string) ([]versionSkip, error) {
+	content, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var skips []versionSkip
+	for n, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		skip, err := parseSkip(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", name, n+1, err)
+		}
+
+		skips = append(skips, skip)
+	}
+
+	return skips, nil
+}
+
+// skipFor returns the skip that applies to version of path, if any.
+func skipFor(skips []versionSkip, path, version string) (versionSkip, bool) {
+	for _, s := range skips {
+		if (s.Path == "" || s.Path == path) && semver.Compare(s.Version, version) == 0 {
+			s.Path = path
+			return s, true
+		}
+	}
+
+	return versionSkip{}, false
+}
+
+// skipReasonSuffix returns the reason for s, prefixed by ": ", or ""
+// if it has none.
+func skipReasonSuffix(s versionSkip) string {
+	if s.Reason == "" {
+		return ""
+	}
+
+	return ": " + s.Reason
+}
+
+// pickVersion returns the newest of versions, which are in semver
+// order, that isn't skipped, or "" if they all are. The newer versions
+// that were skipped are returned too, newest first.
+func pickVersion(logger *slog.Logger, path string, versions []string, skips []versionSkip) (string, []versionSkip) {
+	var skipped []versionSkip
+	for i := len(versions) - 1; i >= 0; i-- {
+		s, ok := skipFor(skips, path, versions[i])
+		if !ok {
+			return versions[i], skipped
+		}
+
+		logger.Info("skipping version", "module", path, "version", versions[i], "reason", s.Reason)
+		s.Version = versions[i]
+		skipped = append(skipped, s)
+	}
+
+	return "", skipped
+}
+
+// closestVersions returns up to n of versions that are nearest to
+// version in semver order, in that order.
+func closestVersions(versions []string, version string, n int) []string {
+	sorted := slices.Clone(versions)
+	semver.Sort(sorted)
+	i, _ := slices.BinarySearchFunc(sorted, version, semver.Compare)
+	lo, hi := i, i
+	for hi-lo < n && (lo > 0 || hi < len(sorted)) {
+		if hi < len(sorted) {
+			hi++
+		}
+
+		if hi-lo < n && lo > 0 {
+			lo--
+		}
+	}
+
+	return sorted[lo:hi]
+}
+
+// releaseTag returns the tag of version in the module's repository,
+// and whether version is a tagged release or pre-release, rather than
+// a pseudo-version. Tags don't carry build metadata, such as the
+// +incompatible of modules without a go.mod from major version 2 on.
+func releaseTag(version string) (string, bool) {
+	tag := semver.Canonical(version)
+	if tag == "" || module.IsPseudoVersion(version) || !strings.HasPrefix(version, tag) {
+		return "", false
+	}
+
+	return tag, true
+}
+
+// versionKeywords are the -version values that stand for module
+// queries, updating to path@KEYWORD. latest is the latest release, even
+// if go.mod requires a newer pseudo-version or a branch; patch is the
+// latest release with the same minor version; and upgrade is latest,
+// unless the current version is newer, as when no version is given.
+var versionKeywords = map[string]bool{
+	"latest":  true,
+	"patch":   true,
+	"upgrade": true,
+}
+
+// trackedQuery returns version, as given with -version, if it is a
+// branch or commit query rather than a version, and resolved is the
+// pseudo-version it resolved to. Otherwise "" is returned.
+func trackedQuery(version, resolved string) string {
+	if version == "" || versionKeywords[version] || semverLikeRe.MatchString(version) || !module.IsPseudoVersion(resolved) {
+		return ""
+	}
+
+	return version
+}
+
+// semverLikeRe matches versions that are meant to be semver, given
+// with or without their "v", as opposed to queries or branch names.
+var semverLikeRe = regexp.MustCompile(`^v?\d+(\.\d+){0,2}([-+].*)?$`)
+
+// normalizeVersion returns version, as given with -version, with a "v"
+// added if it is a semver version without one. Versions that look like
+// semver but aren't valid are reported as not ok, while queries and
+// branch names are returned as they are.
+func normalizeVersion(version string) (string, bool) {
+	if !semverLikeRe.MatchString(version) {
+		return version, true
+	}
+
+	if !strings.HasPrefix(version, "v") {
+		version = "v" + version
+	}
+
+	return version, semver.IsValid(version)
+}
+
+// sameVersion returns true if version, as given with -version, is the
+// same as current. A version missing its minor or patch number is a
+// query for the latest that matches it, so only complete versions are
+// compared by semver precedence.
+func sameVersion(current, version string) bool {
+	if current == version {
+		return true
+	}
+
+	return completeVersion(version) && semver.Compare(current, version) == 0
+}
+
+// completeVersion returns true if version is a complete semver
+// version, with its minor and patch numbers, rather than a shorthand
+// query for the latest version matching it, such as v1.2.
+func completeVersion(version string) bool {
+	c := semver.Canonical(version)
+	return c != "" && strings.HasPrefix(version, c)
+}
+
+// displayVersion returns version as shown in commit messages: without
+// its "v" (or +incompatible) for releases and pre-releases, and in full
+// for commits. Other versions aren't shown.
+func displayVersion(version string, commit bool) string {
+	if _, ok := releaseTag(version); ok {
+		return strings.TrimPrefix(strings.TrimSuffix(version, "+incompatible"), "v")
+	}
+
+	if commit {
+		return version
+	}
+
+	return ""
+}
+
+// templateDate returns the time for the Date template field: the time
+// in SOURCE_DATE_EPOCH, in seconds since the Unix epoch, if it is set,
+// or else when the run started. Both are in UTC.
+func (r *run) templateDate() (time.Time, error) {
+	epoch := os.Getenv("SOURCE_DATE_EPOCH")
+	if epoch == "" {
+		return r.start.UTC(), nil
+	}
+
+	secs, err := strconv.ParseInt(epoch, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid SOURCE_DATE_EPOCH %q, expected seconds since the Unix epoch", epoch)
+	}
+
+	return time.Unix(secs, 0).UTC(), nil
+}
+
+// moduleProject returns the project named in commit messages and
+// branches for the module path: its last element.
+func moduleProject(path string) string {
+	return path[strings.LastIndex(path, "/")+1:]
+}
+
+// moduleOwner returns the owner of the repository hosting the module
+// path, if it can be told from the path alone: the second element of a
+// github.com path. Other paths need resolving, and return "".
+func moduleOwner(path string) string {
+	parts := strings.Split(path, "/")
+	if parts[0] != "github.com" || len(parts) < 3 {
+		return ""
+	}
+
+	return parts[1]
+}
+
+// fromVersion returns version, the version being updated from, as
+// shown in commit messages: without its "v" (or +incompatible) for
+// releases, and as the abbreviated commit hash for pseudo-versions.
+func fromVersion(version string) string {
+	if module.IsPseudoVersion(version) {
+		if rev, err := module.PseudoVersionRev(version); err == nil && len(rev) >= 7 {
+			return rev[:7]
+		}
+	}
+
+	return strings.TrimPrefix(strings.TrimSuffix(version, "+incompatible"), "v")
+}
+
+// retractions returns the rationale for each retract directive that
+// covers version of the module path, if any. An empty rationale is
+// reported as "(no rationale given)", so that a retraction is always
+// returned as at least one entry.
+func (r *run) retractions(ctx context.Context, path, version string) ([]string, error) {
+	out, err := r.output(ctx, command("", nil, "go", "list", "-m", "-retracted", "-json", path+"@"+version))
+	if err != nil {
+		return nil, fmt.Errorf("error checking %s@%s for retractions: %w", path, version, err)
+	}
+
+	var m listModule
+	if err := json.Unmarshal(out, &m); err != nil {
+		return nil, err
+	}
+
+	for i, r := range m.Retracted {
+		if r == "" {
+			m.Retracted[i] = "(no rationale given)"
+		}
+	}
+
+	return m.Retracted, nil
+}
+
+// deprecation returns the deprecation message of the module path at
+// version, or an empty string if it isn't deprecated.
+func (r *run) deprecation(ctx context.Context, path, version string) (string, error) {
+	out, err := r.output(ctx, command("", nil, "go", "list", "-m", "-u", "-json", path+"@"+version))
+	if err != nil {
+		return "", err
+	}
+
+	var m listModule
+	if err := json.Unmarshal(out, &m); err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(m.Deprecated), nil
+}
+
+// deprecatedReplacementRe matches the module suggested in place of a
+// deprecated one, in messages such as `Use "example.com/new" instead.`
+// or "moved to example.com/new".
+var deprecatedReplacementRe = regexp.MustCompile(`(?i)\b(?:use|moved to|replaced by|see|switch to)\s+(?:the\s+)?["']?([a-z0-9][-a-z0-9.]*\.[a-z]{2,}(?:/[^\s"',;()]*)?)`)
+
+// deprecatedReplacement returns the module suggested in a deprecation
+// message, if one can be found.
+func deprecatedReplacement(msg string) string {
+	m := deprecatedReplacementRe.FindStringSubmatch(msg)
+	if m == nil {
+		return ""
+	}
+
+	return strings.TrimRight(m[1], ".")
+}
+
+// privateModule returns true if path matches GOPRIVATE or GONOPROXY,
+// in which case nothing should be looked up about it, or published.
+func (r *run) privateModule(ctx context.Context, path string) (bool, error) {
+	out, err := r.output(ctx, command("", nil, "go", "env", "-json", "GOPRIVATE", "GONOPROXY"))
+	if err != nil {
+		return false, err
+	}
+
+	var env struct {
+		GOPRIVATE string
+		GONOPROXY string
+	}
+
+	if err := json.Unmarshal(out, &env); err != nil {
+		return false, err
+	}
+
+	return module.MatchPrefixPatterns(env.GOPRIVATE, path) || module.MatchPrefixPatterns(env.GONOPROXY, path), nil
+}
+
+// goWorkFile returns the path to the active go.work file, or an empty
+// string if workspace mode is not in use.
+func (r *run) goWorkFile(ctx context.Context) (string, error) {
+	out, err := r.output(ctx, command("", nil, "go", "env", "GOWORK"))
+	if err != nil {
+		return "", err
+	}
+
+	gowork := strings.TrimSpace(string(out))
+	if gowork == "off" {
+		return "", nil
+	}
+
+	return gowork, nil
+}
@@ -0,0 +1,232 @@
+package bump
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/vancluever/depbump/internal/constraint"
+	"golang.org/x/mod/semver"
+)
+
+func TestCompleteVersion(t *testing.T) {
+	cases := []struct {
+		version string
+		want    bool
+	}{
+		{version: "v1.9.3", want: true},
+		{version: "v1.9.3-rc.1", want: true},
+		{version: "v2.0.5+incompatible", want: true},
+		{version: "v1.2", want: false},
+		{version: "v1", want: false},
+		{version: "master", want: false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.version, func(t *testing.T) {
+			if got := completeVersion(tc.version); got != tc.want {
+				t.Fatalf("expected %t, got %t", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestModuleProjectAndOwner(t *testing.T) {
+	cases := []struct {
+		path        string
+		wantProject string
+		wantOwner   string
+	}{
+		{path: "example.com", wantProject: "example.com"},
+		{path: "github.com/foo", wantProject: "foo"},
+		{path: "github.com/hashicorp/terraform", wantProject: "terraform", wantOwner: "hashicorp"},
+		{path: "github.com/foo/bar/v2/sub", wantProject: "sub", wantOwner: "foo"},
+		{path: "rsc.io/quote", wantProject: "quote"},
+		{path: "gitlab.com/group/project", wantProject: "project"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.path, func(t *testing.T) {
+			if got := moduleProject(tc.path); got != tc.wantProject {
+				t.Fatalf("expected project %q, got %q", tc.wantProject, got)
+			}
+
+			if got := moduleOwner(tc.path); got != tc.wantOwner {
+				t.Fatalf("expected owner %q, got %q", tc.wantOwner, got)
+			}
+		})
+	}
+}
+
+// testNow is the time selectVersion is run at in TestSelectVersion.
+var testNow = time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+
+// testLookup looks up versions of example.com/mod, published the given
+// number of days before testNow, or at an unknown time if negative.
+func testLookup(t *testing.T, ages map[string]int) versionLookup {
+	return versionLookup{
+		resolve: func(query string) (string, error) {
+			if query != "upgrade" {
+				t.Fatalf("expected the upgrade query, got %q", query)
+			}
+
+			return "v1.2.0", nil
+		},
+		available: func(pre bool) ([]string, error) {
+			var versions []string
+			for _, v := range []string{"v1.0.0", "v1.1.0", "v1.2.0", "v1.3.0-rc.1"} {
+				if pre || semver.Prerelease(v) == "" {
+					versions = append(versions, v)
+				}
+			}
+
+			return versions, nil
+		},
+		published: func(version string) (time.Time, error) {
+			days, ok := ages[version]
+			if !ok {
+				t.Fatalf("unexpected lookup of when %s was published", version)
+			}
+
+			if days < 0 {
+				return time.Time{}, nil
+			}
+
+			return testNow.AddDate(0, 0, -days), nil
+		},
+	}
+}
+
+func TestSelectVersion(t *testing.T) {
+	mustParse := func(s string) *constraint.Constraint {
+		c, err := constraint.Parse(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return c
+	}
+
+	week := 7 * 24 * time.Hour
+	cases := []struct {
+		name        string
+		req         versionRequest
+		ages        map[string]int
+		want        string
+		wantSkipped []versionSkip
+		wantCurrent string
+		wantCode    int
+	}{
+		{
+			name: "latest",
+			req:  versionRequest{current: "v1.0.0"},
+		},
+		{
+			name: "constraint",
+			req:  versionRequest{current: "v1.0.0", constraint: mustParse("<1.2")},
+			want: "v1.1.0",
+		},
+		{
+			name: "constraint with pre-releases",
+			req:  versionRequest{current: "v1.0.0", constraint: mustParse(">1.2"), pre: true},
+			want: "v1.3.0-rc.1",
+		},
+		{
+			name:        "constraint below current",
+			req:         versionRequest{current: "v1.1.0", constraint: mustParse("<1.1")},
+			want:        "v1.0.0",
+			wantCurrent: `package example.com/mod is at version v1.1.0, newer than v1.0.0, the newest version matching "<1.1"`,
+		},
+		{
+			name:        "skipped latest",
+			req:         versionRequest{current: "v1.0.0", skips: []versionSkip{{Version: "v1.2.0"}}},
+			want:        "v1.1.0",
+			wantSkipped: []versionSkip{{Path: "example.com/mod", Version: "v1.2.0"}},
+		},
+		{
+			name:        "all newer skipped",
+			req:         versionRequest{current: "v1.0.0", skips: []versionSkip{{Version: "v1.1.0"}, {Path: "example.com/mod", Version: "v1.2.0"}}},
+			want:        "v1.0.0",
+			wantSkipped: []versionSkip{{Path: "example.com/mod", Version: "v1.2.0"}, {Path: "example.com/mod", Version: "v1.1.0"}},
+			wantCurrent: "package example.com/mod is at version v1.0.0, and the newer versions are skipped",
+		},
+		{
+			name:     "explicit version skipped",
+			req:      versionRequest{current: "v1.0.0", version: "v1.2.0", skips: []versionSkip{{Version: "v1.2.0", Reason: "broken"}}},
+			wantCode: ExitPrecondition,
+		},
+		{
+			name: "skips for another module",
+			req:  versionRequest{current: "v1.0.0", skips: []versionSkip{{Path: "example.com/other", Version: "v1.2.0"}}},
+		},
+		{
+			name: "old enough",
+			req:  versionRequest{current: "v1.0.0", minAge: week},
+			ages: map[string]int{"v1.2.0": 30},
+		},
+		{
+			name: "too recent",
+			req:  versionRequest{current: "v1.0.0", minAge: week},
+			ages: map[string]int{"v1.2.0": 1, "v1.1.0": 30},
+			want: "v1.1.0",
+		},
+		{
+			name:        "nothing old enough",
+			req:         versionRequest{current: "v1.0.0", minAge: week},
+			ages:        map[string]int{"v1.2.0": 1, "v1.1.0": 2},
+			wantCurrent: "nothing eligible: no version of example.com/mod newer than v1.0.0 was published at least 7d ago",
+		},
+		{
+			name: "publish time unknown",
+			req:  versionRequest{current: "v1.0.0", minAge: week},
+			ages: map[string]int{"v1.2.0": -1},
+		},
+		{
+			name:        "too recent and skipped",
+			req:         versionRequest{current: "v1.0.0", minAge: week, skips: []versionSkip{{Version: "v1.1.0"}}},
+			ages:        map[string]int{"v1.2.0": 1},
+			wantCurrent: "nothing eligible: no version of example.com/mod newer than v1.0.0 was published at least 7d ago",
+		},
+		{
+			name: "explicit version too recent",
+			req:  versionRequest{current: "v1.0.0", version: "v1.2.0", minAge: week},
+			want: "v1.2.0",
+		},
+	}
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := tc.req
+			req.path, req.now = "example.com/mod", testNow
+			choice, err := selectVersion(logger, req, testLookup(t, tc.ages))
+			if tc.wantCode != 0 {
+				var runErr *Error
+				if !errors.As(err, &runErr) || runErr.Code != tc.wantCode {
+					t.Fatalf("expected exit code %d, got %v", tc.wantCode, err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if choice.version != tc.want {
+				t.Fatalf("expected version %q, got %q", tc.want, choice.version)
+			}
+
+			if !reflect.DeepEqual(choice.skipped, tc.wantSkipped) {
+				t.Fatalf("expected skipped %v, got %v", tc.wantSkipped, choice.skipped)
+			}
+
+			if choice.current != tc.wantCurrent {
+				t.Fatalf("expected current %q, got %q", tc.wantCurrent, choice.current)
+			}
+		})
+	}
+}
@@ -0,0 +1,337 @@
+// Package constraint parses semver range constraints, such as
+// ">=1.4 <1.7" or "^1.2", and matches module versions against them.
+package constraint
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/mod/semver"
+)
+
+// Constraint is a parsed range constraint. A version matches if it
+// satisfies every comparator in any one of the alternatives separated
+// by "||".
+type Constraint struct {
+	raw  string
+	alts [][]comparator
+}
+
+// comparator reports whether a canonical version satisfies one part of
+// a constraint.
+type comparator func(v string) bool
+
+// partialRe matches a version that may be missing its minor and patch
+// numbers, or have them replaced by a wildcard (x, X or *).
+var partialRe = regexp.MustCompile(`^v?(0|[1-9][0-9]*|[xX*])(?:\.(0|[1-9][0-9]*|[xX*]))?(?:\.(0|[1-9][0-9]*|[xX*]))?(-[0-9A-Za-z.-]+)?(?:\+[0-9A-Za-z.-]+)?$`)
+
+// partial is a version as written in a constraint. n is the number of
+// leading numbers given, before any wildcard.
+type partial struct {
+	nums [3]int
+	n    int
+	pre  string
+}
+
+// parsePartial parses a version in a constraint.
+func parsePartial(s string) (partial, error) {
+	m := partialRe.FindStringSubmatch(s)
+	if m == nil {
+		return partial{}, fmt.Errorf("invalid version %q", s)
+	}
+
+	var p partial
+	for i, f := range m[1:4] {
+		if isWildcard(f) {
+			break
+		}
+
+		p.nums[i], _ = strconv.Atoi(f)
+		p.n++
+	}
+
+	// Wildcards after the last number are fine, but not before it.
+	for _, f := range m[p.n+1 : 4] {
+		if !isWildcard(f) {
+			return partial{}, fmt.Errorf("invalid version %q: numbers cannot follow a wildcard", s)
+		}
+	}
+
+	p.pre = m[4]
+	if p.pre != "" && p.n < 3 {
+		return partial{}, fmt.Errorf("invalid version %q: a pre-release needs a full version", s)
+	}
+
+	return p, nil
+}
+
+// isWildcard returns true if f, a number of a version, is missing or a
+// wildcard.
+func isWildcard(f string) bool {
+	return f == "" || f == "x" || f == "X" || f == "*"
+}
+
+// version returns the canonical version for p, with any missing numbers
+// as zero.
+func (p partial) version() string {
+	return fmt.Sprintf("v%d.%d.%d%s", p.nums[0], p.nums[1], p.nums[2], p.pre)
+}
+
+// next returns the lowest version beyond the range p covers, bumping
+// the number at index i. Pre-releases of that version are excluded, as
+// they sort before it.
+func (p partial) next(i int) string {
+	nums := p.nums
+	nums[i]++
+	for j := i + 1; j < 3; j++ {
+		nums[j] = 0
+	}
+
+	return fmt.Sprintf("v%d.%d.%d-0", nums[0], nums[1], nums[2])
+}
+
+// bounds returns the lowest version p covers, and the version just
+// beyond the highest, or "" if there is no upper bound.
+func (p partial) bounds() (string, string) {
+	if p.n == 0 {
+		return "v0.0.0-0", ""
+	}
+
+	if p.n == 3 {
+		return p.version(), ""
+	}
+
+	return p.version(), p.next(p.n - 1)
+}
+
+func atLeast(lo string) comparator {
+	return func(v string) bool { return semver.Compare(v, lo) >= 0 }
+}
+
+func below(hi string) comparator {
+	return func(v string) bool { return semver.Compare(v, hi) < 0 }
+}
+
+func within(lo, hi string) comparator {
+	return func(v string) bool {
+		return semver.Compare(v, lo) >= 0 && (hi == "" || semver.Compare(v, hi) < 0)
+	}
+}
+
+// parseComparator parses an operator and the version it applies to.
+func parseComparator(op, s string) (comparator, error) {
+	p, err := parsePartial(s)
+	if err != nil {
+		return nil, err
+	}
+
+	lo, hi := p.bounds()
+	exact := p.n == 3
+	switch op {
+	case "", "=", "==":
+		if exact {
+			return func(v string) bool { return semver.Compare(v, lo) == 0 }, nil
+		}
+
+		return within(lo, hi), nil
+
+	case "!=":
+		if exact {
+			return func(v string) bool { return semver.Compare(v, lo) != 0 }, nil
+		}
+
+		in := within(lo, hi)
+		return func(v string) bool { return !in(v) }, nil
+
+	case ">":
+		if exact {
+			return func(v string) bool { return semver.Compare(v, lo) > 0 }, nil
+		}
+
+		if hi == "" {
+			return func(string) bool { return false }, nil
+		}
+
+		return atLeast(hi), nil
+
+	case ">=":
+		return atLeast(lo), nil
+
+	case "<":
+		if p.pre == "" {
+			// Pre-releases of the bound are below it, but aren't
+			// expected to match.
+			return below(lo + "-0"), nil
+		}
+
+		return below(lo), nil
+
+	case "<=":
+		if exact {
+			return func(v string) bool { return semver.Compare(v, lo) <= 0 }, nil
+		}
+
+		if hi == "" {
+			return func(string) bool { return true }, nil
+		}
+
+		return below(hi), nil
+
+	case "~":
+		// Patch updates, or minor ones if only a major version is
+		// given.
+		switch p.n {
+		case 0:
+			return within(lo, ""), nil
+
+		case 1:
+			return within(lo, p.next(0)), nil
+		}
+
+		return within(lo, p.next(1)), nil
+
+	case "^":
+		// Updates that don't change the leftmost non-zero number.
+		if p.n == 0 {
+			return within(lo, ""), nil
+		}
+
+		i := 0
+		for i < p.n-1 && p.nums[i] == 0 {
+			i++
+		}
+
+		return within(lo, p.next(i)), nil
+	}
+
+	return nil, fmt.Errorf("invalid operator %q", op)
+}
+
+// operators are the comparator operators, longest first so that the
+// first match is the right one.
+var operators = []string{"==", "!=", ">=", "<=", ">", "<", "=", "~", "^"}
+
+// Parse parses a constraint. Comparators are separated by spaces or
+// commas, and all of them must match; alternatives are separated by
+// "||". The supported forms are:
+//
+//	1.2.3, =1.2.3    exactly 1.2.3
+//	1.2, 1.2.x       any 1.2 version
+//	!=1.2.3          anything but 1.2.3
+//	>1.2, >=1.2      above or at least 1.2
+//	<1.2, <=1.2      below or at most 1.2
+//	~1.2.3           at least 1.2.3, below 1.3
+//	^1.2.3           at least 1.2.3, below 2
+//	1.2 - 1.4        at least 1.2, at most 1.4
+//	*                any version
+//
+// Versions may be given with or without a leading "v".
+func Parse(s string) (*Constraint, error) {
+	c := &Constraint{raw: s}
+	for _, alt := range strings.Split(s, "||") {
+		var fields []string
+		for _, f := range strings.Fields(strings.ReplaceAll(alt, ",", " ")) {
+			// Join operators separated from their version by a space.
+			if n := len(fields); n > 0 && isOperator(fields[n-1]) {
+				fields[n-1] += f
+				continue
+			}
+
+			fields = append(fields, f)
+		}
+
+		if len(fields) < 1 {
+			return nil, fmt.Errorf("invalid constraint %q: empty alternative", s)
+		}
+
+		var comparators []comparator
+		for i := 0; i < len(fields); i++ {
+			f := fields[i]
+			if isOperator(f) {
+				return nil, fmt.Errorf("invalid constraint %q: %s is missing a version", s, f)
+			}
+
+			// A hyphen range, as in "1.2 - 1.4".
+			if i+2 < len(fields) && fields[i+1] == "-" {
+				lo, err := parseComparator(">=", f)
+				if err != nil {
+					return nil, fmt.Errorf("invalid constraint %q: %w", s, err)
+				}
+
+				hi, err := parseComparator("<=", fields[i+2])
+				if err != nil {
+					return nil, fmt.Errorf("invalid constraint %q: %w", s, err)
+				}
+
+				comparators = append(comparators, lo, hi)
+				i += 2
+				continue
+			}
+
+			var op string
+			for _, o := range operators {
+				if strings.HasPrefix(f, o) {
+					op = o
+					break
+				}
+			}
+
+			cmp, err := parseComparator(op, strings.TrimPrefix(f, op))
+			if err != nil {
+				return nil, fmt.Errorf("invalid constraint %q: %w", s, err)
+			}
+
+			comparators = append(comparators, cmp)
+		}
+
+		c.alts = append(c.alts, comparators)
+	}
+
+	return c, nil
+}
+
+// isOperator returns true if s is an operator on its own.
+func isOperator(s string) bool {
+	for _, o := range operators {
+		if s == o {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Match returns true if version satisfies the constraint. Build
+// metadata, such as +incompatible, is ignored.
+func (c *Constraint) Match(version string) bool {
+	if !strings.HasPrefix(version, "v") {
+		version = "v" + version
+	}
+
+	if !semver.IsValid(version) {
+		return false
+	}
+
+	for _, alt := range c.alts {
+		ok := true
+		for _, cmp := range alt {
+			if !cmp(version) {
+				ok = false
+				break
+			}
+		}
+
+		if ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// String returns the constraint as it was given.
+func (c *Constraint) String() string {
+	return c.raw
+}
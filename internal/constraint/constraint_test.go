@@ -0,0 +1,198 @@
+package constraint
+
+import (
+	"reflect"
+	"testing"
+)
+
+// testVersions are the versions matched against in TestMatch.
+var testVersions = []string{
+	"v0.0.3",
+	"v0.0.4",
+	"v0.1.0",
+	"v1.0.0",
+	"v1.2.0",
+	"v1.2.5",
+	"v1.3.0",
+	"v1.4.9",
+	"v1.5.0",
+	"v1.7.0-rc.1",
+	"v1.7.0",
+	"v2.0.0",
+	"v3.0.0",
+	"v3.1.0+incompatible",
+}
+
+func TestMatch(t *testing.T) {
+	cases := []struct {
+		constraint string
+		want       []string
+	}{
+		{
+			constraint: "1.2.5",
+			want:       []string{"v1.2.5"},
+		},
+		{
+			constraint: "1.2.x",
+			want:       []string{"v1.2.0", "v1.2.5"},
+		},
+		{
+			constraint: "!=1.2.5",
+			want:       []string{"v0.0.3", "v0.0.4", "v0.1.0", "v1.0.0", "v1.2.0", "v1.3.0", "v1.4.9", "v1.5.0", "v1.7.0-rc.1", "v1.7.0", "v2.0.0", "v3.0.0", "v3.1.0+incompatible"},
+		},
+		{
+			constraint: "^0.0.3",
+			want:       []string{"v0.0.3"},
+		},
+		{
+			constraint: "^0.1",
+			want:       []string{"v0.1.0"},
+		},
+		{
+			constraint: "^1.2.5",
+			want:       []string{"v1.2.5", "v1.3.0", "v1.4.9", "v1.5.0", "v1.7.0-rc.1", "v1.7.0"},
+		},
+		{
+			constraint: "~1",
+			want:       []string{"v1.0.0", "v1.2.0", "v1.2.5", "v1.3.0", "v1.4.9", "v1.5.0", "v1.7.0-rc.1", "v1.7.0"},
+		},
+		{
+			constraint: "~1.2.1",
+			want:       []string{"v1.2.5"},
+		},
+		{
+			constraint: ">1.2",
+			want:       []string{"v1.3.0", "v1.4.9", "v1.5.0", "v1.7.0-rc.1", "v1.7.0", "v2.0.0", "v3.0.0", "v3.1.0+incompatible"},
+		},
+		{
+			constraint: "<1.7",
+			want:       []string{"v0.0.3", "v0.0.4", "v0.1.0", "v1.0.0", "v1.2.0", "v1.2.5", "v1.3.0", "v1.4.9", "v1.5.0"},
+		},
+		{
+			constraint: "<1.7.0-rc.2",
+			want:       []string{"v0.0.3", "v0.0.4", "v0.1.0", "v1.0.0", "v1.2.0", "v1.2.5", "v1.3.0", "v1.4.9", "v1.5.0", "v1.7.0-rc.1"},
+		},
+		{
+			constraint: "<=1.4",
+			want:       []string{"v0.0.3", "v0.0.4", "v0.1.0", "v1.0.0", "v1.2.0", "v1.2.5", "v1.3.0", "v1.4.9"},
+		},
+		{
+			constraint: ">= 1.4, < 1.7",
+			want:       []string{"v1.4.9", "v1.5.0"},
+		},
+		{
+			constraint: "1.2 - 1.4",
+			want:       []string{"v1.2.0", "v1.2.5", "v1.3.0", "v1.4.9"},
+		},
+		{
+			constraint: "1.x || >=3",
+			want:       []string{"v1.0.0", "v1.2.0", "v1.2.5", "v1.3.0", "v1.4.9", "v1.5.0", "v1.7.0-rc.1", "v1.7.0", "v3.0.0", "v3.1.0+incompatible"},
+		},
+		{
+			constraint: "*",
+			want:       testVersions,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.constraint, func(t *testing.T) {
+			c, err := Parse(tc.constraint)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for _, v := range testVersions {
+				if c.Match(v) {
+					got = append(got, v)
+				}
+			}
+
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("expected %q, got %q", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestMatchVersionForms(t *testing.T) {
+	c, err := Parse("^1.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		version string
+		want    bool
+	}{
+		{version: "1.4.0", want: true},
+		{version: "v1.4.0", want: true},
+		{version: "v2.0.0", want: false},
+		{version: "garbage", want: false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.version, func(t *testing.T) {
+			if got := c.Match(tc.version); got != tc.want {
+				t.Fatalf("expected %t, got %t", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	cases := []struct {
+		constraint string
+		want       string
+	}{
+		{
+			constraint: "",
+			want:       `invalid constraint "": empty alternative`,
+		},
+		{
+			constraint: "1.2 ||",
+			want:       `invalid constraint "1.2 ||": empty alternative`,
+		},
+		{
+			constraint: ">=",
+			want:       `invalid constraint ">=": >= is missing a version`,
+		},
+		{
+			constraint: "garbage",
+			want:       `invalid constraint "garbage": invalid version "garbage"`,
+		},
+		{
+			constraint: "01.2",
+			want:       `invalid constraint "01.2": invalid version "01.2"`,
+		},
+		{
+			constraint: "=>1.2",
+			want:       `invalid constraint "=>1.2": invalid version ">1.2"`,
+		},
+		{
+			constraint: "1.x.2",
+			want:       `invalid constraint "1.x.2": invalid version "1.x.2": numbers cannot follow a wildcard`,
+		},
+		{
+			constraint: "^1.2-rc.1",
+			want:       `invalid constraint "^1.2-rc.1": invalid version "1.2-rc.1": a pre-release needs a full version`,
+		},
+		{
+			constraint: "1.2 - 1.x.4",
+			want:       `invalid constraint "1.2 - 1.x.4": invalid version "1.x.4": numbers cannot follow a wildcard`,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.constraint, func(t *testing.T) {
+			_, err := Parse(tc.constraint)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+
+			if err.Error() != tc.want {
+				t.Fatalf("expected %q, got %q", tc.want, err.Error())
+			}
+		})
+	}
+}
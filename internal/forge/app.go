@@ -0,0 +1,162 @@
+package forge
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// App mints installation access tokens for a GitHub App, to use in
+// place of a personal token. Tokens are minted when first needed, and
+// again when they are close to expiring, since they only last an hour.
+type App struct {
+	// BaseURL is the root of the API, DefaultGitHubURL if it is empty.
+	BaseURL string
+
+	// Client sends the requests, or the default client if it is nil.
+	Client *http.Client
+
+	// Logger gets warnings about retried requests. Nothing is logged if
+	// it is nil.
+	Logger *slog.Logger
+
+	id             string
+	installationID string
+	key            *rsa.PrivateKey
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// LoadApp returns an App for the app id, installed as installationID,
+// with the PEM private key in keyFile.
+func LoadApp(id, installationID, keyFile string) (*App, error) {
+	data, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading GitHub App key: %w", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in GitHub App key file %s", keyFile)
+	}
+
+	// GitHub issues PKCS #1 keys, but converted keys are often PKCS #8.
+	key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		k, err8 := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err8 != nil {
+			return nil, fmt.Errorf("error parsing GitHub App key: %w", err)
+		}
+
+		var ok bool
+		if key, ok = k.(*rsa.PrivateKey); !ok {
+			return nil, errors.New("GitHub App key is not an RSA key")
+		}
+	}
+
+	return &App{id: id, installationID: installationID, key: key}, nil
+}
+
+// jwt returns a JSON web token identifying the app, valid for a few
+// minutes. It is backdated slightly to allow for clock drift.
+func (a *App) jwt() (string, error) {
+	now := time.Now()
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`))
+	claims, err := json.Marshal(map[string]interface{}{
+		"iat": now.Add(-time.Minute).Unix(),
+		"exp": now.Add(9 * time.Minute).Unix(),
+		"iss": a.id,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	signed := header + "." + base64.RawURLEncoding.EncodeToString(claims)
+	sum := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(nil, a.key, crypto.SHA256, sum[:])
+	if err != nil {
+		return "", err
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// Token returns an installation access token, minting one if there
+// isn't one that is still good for a few minutes.
+func (a *App) Token(ctx context.Context) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.token != "" && time.Until(a.expires) > 5*time.Minute {
+		return a.token, nil
+	}
+
+	jwt, err := a.jwt()
+	if err != nil {
+		return "", fmt.Errorf("error signing GitHub App token request: %w", err)
+	}
+
+	base := a.BaseURL
+	if base == "" {
+		base = DefaultGitHubURL
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("%s/app/installations/%s/access_tokens", strings.TrimSuffix(base, "/"), a.installationID), nil)
+	if err != nil {
+		return "", err
+	}
+
+	req.Header.Add("Authorization", "Bearer "+jwt)
+	req.Header.Add("Accept", "application/vnd.github+json")
+
+	resp, err := Do(ctx, withTimeout(a.Client, apiTimeout), a.Logger, req)
+	if err != nil {
+		return "", fmt.Errorf("error getting GitHub App installation token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return "", fmt.Errorf("error getting GitHub App installation token (%s): %s", resp.Status, body)
+	}
+
+	var t struct {
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&t); err != nil {
+		return "", fmt.Errorf("error reading GitHub App installation token: %w", err)
+	}
+
+	a.token, a.expires = t.Token, t.ExpiresAt
+	return a.token, nil
+}
+
+// GitAuthEnv returns the environment for git commands that talk to
+// GitHub over HTTPS with token, using the "x-access-token" user. The
+// header is passed through the environment, where it doesn't show up
+// in the command line.
+func GitAuthEnv(token string) []string {
+	auth := base64.StdEncoding.EncodeToString([]byte("x-access-token:" + token))
+	return []string{
+		"GIT_CONFIG_COUNT=1",
+		"GIT_CONFIG_KEY_0=http.https://github.com/.extraheader",
+		"GIT_CONFIG_VALUE_0=AUTHORIZATION: basic " + auth,
+	}
+}
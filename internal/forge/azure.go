@@ -0,0 +1,102 @@
+package forge
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/vancluever/depbump/internal/gitremote"
+)
+
+// DefaultAzureURL is the Azure DevOps API used when Azure.BaseURL isn't
+// set.
+const DefaultAzureURL = "https://dev.azure.com"
+
+// maxAzureDescription is the longest pull request description Azure
+// DevOps accepts, in characters.
+const maxAzureDescription = 4000
+
+// Azure is a client for the Azure DevOps API.
+type Azure struct {
+	// BaseURL is the root of the API, DefaultAzureURL if it is empty.
+	BaseURL string
+
+	// Client sends the requests, or the default client if it is nil.
+	Client *http.Client
+
+	// Token is the personal access token to authenticate requests with.
+	Token string
+
+	// Logger gets warnings about retried requests. Nothing is logged if
+	// it is nil.
+	Logger *slog.Logger
+}
+
+// CreatePR opens a pull request in repo from branch to base, and
+// returns its web URL. Descriptions too long for Azure DevOps are
+// truncated.
+func (a *Azure) CreatePR(ctx context.Context, repo gitremote.AzureRepo, branch, base, title, description string) (string, error) {
+	if utf8.RuneCountInString(description) > maxAzureDescription {
+		description = string([]rune(description)[:maxAzureDescription-len("\n\n(truncated)")]) + "\n\n(truncated)"
+	}
+
+	payloadB := new(bytes.Buffer)
+	err := json.NewEncoder(payloadB).Encode(map[string]string{
+		"sourceRefName": "refs/heads/" + branch,
+		"targetRefName": "refs/heads/" + base,
+		"title":         title,
+		"description":   description,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	baseURL := a.BaseURL
+	if baseURL == "" {
+		baseURL = DefaultAzureURL
+	}
+
+	endpoint := fmt.Sprintf("%s/%s/%s/_apis/git/repositories/%s/pullrequests?api-version=7.1", strings.TrimSuffix(baseURL, "/"), url.PathEscape(repo.Org), url.PathEscape(repo.Project), url.PathEscape(repo.Name))
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, payloadB)
+	if err != nil {
+		return "", err
+	}
+
+	req.SetBasicAuth("", a.Token)
+	req.Header.Add("Content-Type", "application/json")
+
+	resp, err := Do(ctx, withTimeout(a.Client, 0), a.Logger, req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	respBytes, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	if resp.StatusCode != http.StatusCreated {
+		return "", fmt.Errorf("unexpected response (%s): %s", resp.Status, respBytes)
+	}
+
+	var created struct {
+		ID         int `json:"pullRequestId"`
+		Repository struct {
+			WebURL string `json:"webUrl"`
+		} `json:"repository"`
+	}
+
+	if err := json.Unmarshal(respBytes, &created); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s/pullrequest/%d", created.Repository.WebURL, created.ID), nil
+}
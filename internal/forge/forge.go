@@ -0,0 +1,142 @@
+// Package forge talks to the APIs of the hosts that pull requests are
+// opened on: GitHub, and Azure DevOps.
+package forge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// apiTimeout is the timeout for API requests made while gathering
+// information, which are skipped on failure.
+const apiTimeout = 10 * time.Second
+
+// maxAttempts and maxWait bound the retries of API requests that are
+// rate limited or fail on the server side: no request is attempted more
+// than maxAttempts times, or waits longer than maxWait in total.
+const (
+	maxAttempts = 3
+	maxWait     = 2 * time.Minute
+)
+
+// ErrRateLimited is returned by requests that aren't retried, when they
+// are rate limited.
+var ErrRateLimited = errors.New("rate limited")
+
+// Do sends req with client, retrying it if it is rate limited, after
+// the delay the response asks for, or if it fails with a server error,
+// backing off exponentially. Retries are logged to logger, with only
+// the scheme and host of the URL, as some URLs, such as those of Slack
+// webhooks, are secrets themselves. The last response is returned as
+// is if retries aren't needed; if they are exhausted, an error saying
+// when the rate limit resets, if known, is returned. Waiting stops
+// early if ctx is canceled.
+func Do(ctx context.Context, client *http.Client, logger *slog.Logger, req *http.Request) (*http.Response, error) {
+	var waited time.Duration
+	for attempt := 1; ; attempt++ {
+		if attempt > 1 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+
+			req.Body = body
+		}
+
+		resp, err := client.Do(req.WithContext(ctx))
+		if err != nil {
+			return nil, err
+		}
+
+		delay, reset, retry := retryDelay(resp, attempt)
+		if !retry {
+			return resp, nil
+		}
+
+		resp.Body.Close()
+		if attempt >= maxAttempts || waited+delay > maxWait {
+			if !reset.IsZero() {
+				return nil, fmt.Errorf("unexpected response: %s, rate limited until %s", resp.Status, reset.Format(time.RFC1123))
+			}
+
+			return nil, fmt.Errorf("unexpected response: %s, after %d attempts", resp.Status, attempt)
+		}
+
+		loggerOrDiscard(logger).Warn("retrying API request", "method", req.Method, "url", redactURL(req.URL), "status", resp.Status, "delay", delay)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+
+		waited += delay
+	}
+}
+
+// redactURL returns the scheme and host of u, leaving out the path and
+// query, along with any credentials.
+func redactURL(u *url.URL) string {
+	return (&url.URL{Scheme: u.Scheme, Host: u.Host}).String()
+}
+
+// retryDelay returns how long to wait before retrying the request that
+// got resp, on the given attempt, and whether it should be retried at
+// all. For rate limits, the time the limit resets is also returned, if
+// the response gives it. A 403 is only a rate limit if the headers say
+// so, as it is otherwise a permissions error.
+func retryDelay(resp *http.Response, attempt int) (time.Duration, time.Time, bool) {
+	switch {
+	case resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusTooManyRequests:
+		if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			delay := time.Duration(secs) * time.Second
+			return delay, time.Now().Add(delay), true
+		}
+
+		if resp.Header.Get("X-RateLimit-Remaining") == "0" {
+			if unix, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+				reset := time.Unix(unix, 0)
+				return max(time.Until(reset), 0), reset, true
+			}
+		}
+
+		if resp.StatusCode == http.StatusForbidden {
+			return 0, time.Time{}, false
+		}
+
+		return time.Duration(1<<(attempt-1)) * time.Second, time.Time{}, true
+
+	case resp.StatusCode >= 500:
+		return time.Duration(1<<(attempt-1)) * time.Second, time.Time{}, true
+	}
+
+	return 0, time.Time{}, false
+}
+
+// withTimeout returns a copy of client, or of the default client if it
+// is nil, with timeout, or no timeout if it is zero.
+func withTimeout(client *http.Client, timeout time.Duration) *http.Client {
+	var c http.Client
+	if client != nil {
+		c = *client
+	}
+
+	c.Timeout = timeout
+	return &c
+}
+
+// loggerOrDiscard returns logger, or one that discards everything if
+// it is nil.
+func loggerOrDiscard(logger *slog.Logger) *slog.Logger {
+	if logger == nil {
+		return slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+
+	return logger
+}
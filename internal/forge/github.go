@@ -0,0 +1,509 @@
+package forge
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// DefaultGitHubURL is the GitHub API used when GitHub.BaseURL isn't
+// set.
+const DefaultGitHubURL = "https://api.github.com"
+
+// maxReleaseNotes is the length, in bytes, at which release notes are
+// truncated.
+const maxReleaseNotes = 10000
+
+// GitHub is a client for the GitHub API.
+type GitHub struct {
+	// BaseURL is the root of the API, DefaultGitHubURL if it is empty.
+	BaseURL string
+
+	// Client sends the requests, or the default client if it is nil.
+	// Its timeout is replaced by one suitable for each request.
+	Client *http.Client
+
+	// Token returns the token to authenticate requests with.
+	Token func(ctx context.Context) (string, error)
+
+	// Logger gets warnings about retried requests, and information
+	// about lookups given up on. Nothing is logged if it is nil.
+	Logger *slog.Logger
+}
+
+// PR is a pull request, as listed by the API.
+type PR struct {
+	Number   int     `json:"number"`
+	URL      string  `json:"html_url"`
+	Title    string  `json:"title"`
+	MergedAt *string `json:"merged_at"`
+	Head     PRHead  `json:"head"`
+}
+
+// PRHead is the branch a pull request is opened from.
+type PRHead struct {
+	Ref  string `json:"ref"`
+	SHA  string `json:"sha"`
+	Repo struct {
+		FullName string `json:"full_name"`
+	} `json:"repo"`
+}
+
+// NewPR is a pull request to open. Head is OWNER:BRANCH, and Base the
+// branch to merge it into.
+type NewPR struct {
+	Title string `json:"title"`
+	Body  string `json:"body"`
+	Head  string `json:"head"`
+	Base  string `json:"base"`
+}
+
+// endpoint returns the URL of the API endpoint path, formatted with a.
+func (g *GitHub) endpoint(path string, a ...interface{}) string {
+	base := g.BaseURL
+	if base == "" {
+		base = DefaultGitHubURL
+	}
+
+	return strings.TrimSuffix(base, "/") + fmt.Sprintf(path, a...)
+}
+
+// newRequest returns an authenticated request to endpoint.
+func (g *GitHub) newRequest(ctx context.Context, method, endpoint string, body io.Reader) (*http.Request, error) {
+	var token string
+	if g.Token != nil {
+		var err error
+		if token, err = g.Token(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, body)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Add("Authorization", fmt.Sprintf("bearer %s", token))
+	req.Header.Add("Accept", "application/vnd.github+json")
+	if body != nil {
+		req.Header.Add("Content-Type", "application/json")
+	}
+
+	return req, nil
+}
+
+// Get fetches endpoint, decoding the response into v. Any response
+// other than 200 OK is an error.
+func (g *GitHub) Get(ctx context.Context, endpoint string, v interface{}) error {
+	return g.fetch(ctx, endpoint, v, true)
+}
+
+// GetOnce is Get without the retries, for lookups that aren't worth
+// holding anything up for. If the request is rate limited, the error is
+// ErrRateLimited.
+func (g *GitHub) GetOnce(ctx context.Context, endpoint string, v interface{}) error {
+	return g.fetch(ctx, endpoint, v, false)
+}
+
+// fetch implements Get and GetOnce.
+func (g *GitHub) fetch(ctx context.Context, endpoint string, v interface{}, retry bool) error {
+	req, err := g.newRequest(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return err
+	}
+
+	client := withTimeout(g.Client, apiTimeout)
+	var resp *http.Response
+	if retry {
+		resp, err = Do(ctx, client, g.Logger, req)
+	} else {
+		resp, err = client.Do(req)
+	}
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if !retry && (resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusTooManyRequests) {
+		if _, _, limited := retryDelay(resp, 1); limited {
+			return ErrRateLimited
+		}
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected response: %s", resp.Status)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// Send sends payload to endpoint, using method. Any response other
+// than a 2xx status is an error.
+func (g *GitHub) Send(ctx context.Context, method, endpoint string, payload interface{}) error {
+	payloadB := new(bytes.Buffer)
+	if err := json.NewEncoder(payloadB).Encode(payload); err != nil {
+		return err
+	}
+
+	req, err := g.newRequest(ctx, method, endpoint, payloadB)
+	if err != nil {
+		return err
+	}
+
+	resp, err := Do(ctx, withTimeout(g.Client, apiTimeout), g.Logger, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected response: %s", resp.Status)
+	}
+
+	return nil
+}
+
+// CreatePR opens pr in the repository owner/repo, and returns its URL.
+// If there is already an open pull request from the same head, as a
+// previous run may have created before failing, its URL is returned
+// instead, with existed set.
+func (g *GitHub) CreatePR(ctx context.Context, owner, repo string, pr NewPR) (prURL string, existed bool, err error) {
+	payloadB := new(bytes.Buffer)
+	if err := json.NewEncoder(payloadB).Encode(pr); err != nil {
+		return "", false, fmt.Errorf("error encoding pull request payload: %w", err)
+	}
+
+	req, err := g.newRequest(ctx, "POST", g.endpoint("/repos/%s/%s/pulls", owner, repo), payloadB)
+	if err != nil {
+		return "", false, fmt.Errorf("error creating request: %w", err)
+	}
+
+	resp, err := Do(ctx, withTimeout(g.Client, 0), g.Logger, req)
+	if err != nil {
+		return "", false, fmt.Errorf("error creating pull request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBytes, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", false, fmt.Errorf("error reading response body after creating pull request: %w\n\nWARNING: pull request status unknown, check your repository", err)
+	}
+
+	switch resp.StatusCode {
+	case http.StatusCreated:
+		var created struct {
+			URL string `json:"html_url"`
+		}
+
+		if strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
+			if err := json.Unmarshal(respBytes, &created); err != nil {
+				return "", false, fmt.Errorf("error reading response JSON: %w\n\nWARNING: pull request status unknown, check your repository", err)
+			}
+		}
+
+		if created.URL == "" {
+			loggerOrDiscard(g.Logger).Warn("pull request successfully created, but no URL was returned")
+		}
+
+		return created.URL, false, nil
+
+	case http.StatusUnprocessableEntity:
+		if !prAlreadyExists(respBytes) {
+			break
+		}
+
+		u, err := g.OpenPR(ctx, owner, repo, pr.Head)
+		if err != nil {
+			return "", false, fmt.Errorf("pull request for %s already exists, but could not be looked up: %w", pr.Head, err)
+		}
+
+		if u != "" {
+			return u, true, nil
+		}
+	}
+
+	return "", false, fmt.Errorf("error creating pull request (%s): %s", resp.Status, respBytes)
+}
+
+// prAlreadyExists returns true if body, the response to a request to
+// create a pull request that failed validation, says that there is
+// already a pull request for the head branch.
+func prAlreadyExists(body []byte) bool {
+	var resp struct {
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return false
+	}
+
+	for _, e := range resp.Errors {
+		if strings.HasPrefix(e.Message, "A pull request already exists") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// OpenPR returns the URL of the open pull request in the repository
+// owner/repo from head (OWNER:BRANCH), or an empty string if there
+// isn't one.
+func (g *GitHub) OpenPR(ctx context.Context, owner, repo, head string) (string, error) {
+	var prs []PR
+	if err := g.Get(ctx, g.endpoint("/repos/%s/%s/pulls?state=open&head=%s", owner, repo, url.QueryEscape(head)), &prs); err != nil {
+		return "", err
+	}
+
+	if len(prs) < 1 {
+		return "", nil
+	}
+
+	return prs[0].URL, nil
+}
+
+// OpenPRWithTitle returns the URL of an open pull request in the
+// repository owner/repo with title, or an empty string if there isn't
+// one.
+func (g *GitHub) OpenPRWithTitle(ctx context.Context, owner, repo, title string) (string, error) {
+	prs, err := g.OpenPRs(ctx, owner, repo)
+	if err != nil {
+		return "", err
+	}
+
+	for _, p := range prs {
+		if p.Title == title {
+			return p.URL, nil
+		}
+	}
+
+	return "", nil
+}
+
+// OpenPRs returns the first 100 open pull requests in the repository
+// owner/repo.
+func (g *GitHub) OpenPRs(ctx context.Context, owner, repo string) ([]PR, error) {
+	var prs []PR
+	err := g.Get(ctx, g.endpoint("/repos/%s/%s/pulls?state=open&per_page=100", owner, repo), &prs)
+	return prs, err
+}
+
+// ClosedPRs returns the 100 most recently updated closed pull requests
+// in the repository owner/repo.
+func (g *GitHub) ClosedPRs(ctx context.Context, owner, repo string) ([]PR, error) {
+	var prs []PR
+	err := g.Get(ctx, g.endpoint("/repos/%s/%s/pulls?state=closed&sort=updated&direction=desc&per_page=100", owner, repo), &prs)
+	return prs, err
+}
+
+// PRNumber returns the number of the pull request at prURL, the last
+// element of its path.
+func PRNumber(prURL string) string {
+	return prURL[strings.LastIndex(prURL, "/")+1:]
+}
+
+// UpdatePR replaces the title and body of the pull request at prURL in
+// the repository owner/repo, after its branch has been replaced with a
+// different update, and comments on it so that reviewers are notified.
+func (g *GitHub) UpdatePR(ctx context.Context, owner, repo, prURL, title, body string) error {
+	n, err := strconv.Atoi(PRNumber(prURL))
+	if err != nil {
+		return fmt.Errorf("unexpected pull request URL %s", prURL)
+	}
+
+	payload := map[string]string{"title": title, "body": body}
+	if err := g.Send(ctx, "PATCH", g.endpoint("/repos/%s/%s/pulls/%d", owner, repo, n), payload); err != nil {
+		return fmt.Errorf("error updating pull request: %w", err)
+	}
+
+	if err := g.Comment(ctx, owner, repo, n, "The branch has been replaced, and this pull request updated: "+title+"."); err != nil {
+		return fmt.Errorf("error commenting on pull request: %w", err)
+	}
+
+	return nil
+}
+
+// Comment comments body on pull request n in the repository owner/repo.
+func (g *GitHub) Comment(ctx context.Context, owner, repo string, n int, body string) error {
+	return g.Send(ctx, "POST", g.endpoint("/repos/%s/%s/issues/%d/comments", owner, repo, n), map[string]string{"body": body})
+}
+
+// ClosePR closes pull request n in the repository owner/repo.
+func (g *GitHub) ClosePR(ctx context.Context, owner, repo string, n int) error {
+	return g.Send(ctx, "PATCH", g.endpoint("/repos/%s/%s/pulls/%d", owner, repo, n), map[string]string{"state": "closed"})
+}
+
+// SetDeleteBranchOnMerge turns on the setting of the repository
+// owner/repo that deletes the branches of pull requests once they are
+// merged. This needs admin access to the repository.
+func (g *GitHub) SetDeleteBranchOnMerge(ctx context.Context, owner, repo string) error {
+	return g.Send(ctx, "PATCH", g.endpoint("/repos/%s/%s", owner, repo), map[string]bool{"delete_branch_on_merge": true})
+}
+
+// escapeRef escapes ref, such as a tag, for use in an API path. Each
+// element is escaped on its own, keeping the slashes between them, as
+// the tags of modules in subdirectories have the form subdir/vX.Y.Z.
+func escapeRef(ref string) string {
+	parts := strings.Split(ref, "/")
+	for i, p := range parts {
+		parts[i] = url.PathEscape(p)
+	}
+
+	return strings.Join(parts, "/")
+}
+
+// ReleaseNotes returns the notes for tag in the repository owner/repo:
+// the body of its release, or the message of the tag (or the commit it
+// points at) if there is no release. Notes that can't be fetched for
+// any reason are returned empty. The notes are optional, so requests
+// aren't retried, and nothing more is asked for once they are rate
+// limited.
+func (g *GitHub) ReleaseNotes(ctx context.Context, owner, repo, tag string) string {
+	var release struct {
+		Body string `json:"body"`
+	}
+
+	var notes string
+	if err := g.GetOnce(ctx, g.endpoint("/repos/%s/%s/releases/tags/%s", owner, repo, escapeRef(tag)), &release); err == nil {
+		notes = release.Body
+	} else if errors.Is(err, ErrRateLimited) {
+		loggerOrDiscard(g.Logger).Info("rate limited fetching release notes; leaving them out", "tag", tag)
+		return ""
+	} else {
+		var ref struct {
+			Object struct {
+				Type string `json:"type"`
+				SHA  string `json:"sha"`
+			} `json:"object"`
+		}
+
+		if err := g.GetOnce(ctx, g.endpoint("/repos/%s/%s/git/ref/tags/%s", owner, repo, escapeRef(tag)), &ref); err != nil {
+			return ""
+		}
+
+		// Annotated tags point to a tag object, and lightweight tags
+		// directly to the commit.
+		if ref.Object.Type != "tag" && ref.Object.Type != "commit" {
+			return ""
+		}
+
+		var object struct {
+			Message string `json:"message"`
+		}
+
+		if err := g.GetOnce(ctx, g.endpoint("/repos/%s/%s/git/%ss/%s", owner, repo, ref.Object.Type, ref.Object.SHA), &object); err != nil {
+			return ""
+		}
+
+		notes = object.Message
+	}
+
+	notes = strings.TrimSpace(notes)
+	if len(notes) > maxReleaseNotes {
+		cut := maxReleaseNotes
+		for cut > 0 && !utf8.RuneStart(notes[cut]) {
+			cut--
+		}
+
+		notes = notes[:cut] + "\n\n(truncated)"
+	}
+
+	return notes
+}
+
+// Check is the state of a check run or commit status on a commit.
+// Result is empty while it is still running.
+type Check struct {
+	Name   string
+	Result string
+}
+
+// Failed returns true if the check finished without passing.
+func (c Check) Failed() bool {
+	switch c.Result {
+	case "", "success", "neutral", "skipped":
+		return false
+	}
+
+	return true
+}
+
+// CommitChecks returns the check runs and commit statuses reported for
+// sha in the repository owner/repo.
+func (g *GitHub) CommitChecks(ctx context.Context, owner, repo, sha string) ([]Check, error) {
+	var runs struct {
+		CheckRuns []struct {
+			Name       string `json:"name"`
+			Status     string `json:"status"`
+			Conclusion string `json:"conclusion"`
+		} `json:"check_runs"`
+	}
+
+	if err := g.Get(ctx, g.endpoint("/repos/%s/%s/commits/%s/check-runs?per_page=100", owner, repo, sha), &runs); err != nil {
+		return nil, err
+	}
+
+	var status struct {
+		Statuses []struct {
+			Context string `json:"context"`
+			State   string `json:"state"`
+		} `json:"statuses"`
+	}
+
+	if err := g.Get(ctx, g.endpoint("/repos/%s/%s/commits/%s/status?per_page=100", owner, repo, sha), &status); err != nil {
+		return nil, err
+	}
+
+	var result []Check
+	for _, r := range runs.CheckRuns {
+		c := Check{Name: r.Name}
+		if r.Status == "completed" {
+			c.Result = r.Conclusion
+		}
+
+		result = append(result, c)
+	}
+
+	for _, st := range status.Statuses {
+		c := Check{Name: st.Context}
+		if st.State != "pending" {
+			c.Result = st.State
+		}
+
+		result = append(result, c)
+	}
+
+	return result, nil
+}
+
+// RequiredChecks returns the names of the checks required by the
+// protection of branch in the repository owner/repo, or nil if they
+// can't be found, which needs admin access to the repository.
+func (g *GitHub) RequiredChecks(ctx context.Context, owner, repo, branch string) map[string]bool {
+	var protection struct {
+		Contexts []string `json:"contexts"`
+	}
+
+	if err := g.Get(ctx, g.endpoint("/repos/%s/%s/branches/%s/protection/required_status_checks", owner, repo, url.PathEscape(branch)), &protection); err != nil || len(protection.Contexts) < 1 {
+		return nil
+	}
+
+	result := make(map[string]bool)
+	for _, c := range protection.Contexts {
+		result[c] = true
+	}
+
+	return result
+}
@@ -0,0 +1,453 @@
+package forge
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/vancluever/depbump/internal/gitremote"
+)
+
+// apiRequest is a request received by a testAPI.
+type apiRequest struct {
+	Method string
+	Path   string
+	Body   map[string]interface{}
+}
+
+// testAPI is a mocked API, answering each request with the response
+// registered for its method and path, and recording the requests.
+type testAPI struct {
+	t        *testing.T
+	server   *httptest.Server
+	handlers map[string]http.HandlerFunc
+
+	mu       sync.Mutex
+	requests []apiRequest
+}
+
+func newTestAPI(t *testing.T, handlers map[string]http.HandlerFunc) *testAPI {
+	a := &testAPI{t: t, handlers: handlers}
+	a.server = httptest.NewServer(http.HandlerFunc(a.serve))
+	t.Cleanup(a.server.Close)
+	return a
+}
+
+func (a *testAPI) serve(w http.ResponseWriter, r *http.Request) {
+	req := apiRequest{Method: r.Method, Path: r.URL.RequestURI()}
+	if data, _ := ioutil.ReadAll(r.Body); len(data) > 0 {
+		if err := json.Unmarshal(data, &req.Body); err != nil {
+			a.t.Errorf("bad request body for %s %s: %s", r.Method, req.Path, err)
+		}
+	}
+
+	a.mu.Lock()
+	a.requests = append(a.requests, req)
+	a.mu.Unlock()
+
+	h, ok := a.handlers[r.Method+" "+req.Path]
+	if !ok {
+		a.t.Errorf("unexpected request: %s %s", r.Method, req.Path)
+		http.NotFound(w, r)
+		return
+	}
+
+	h(w, r)
+}
+
+// calls returns the method and path of each request received, in order.
+func (a *testAPI) calls() []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var result []string
+	for _, r := range a.requests {
+		result = append(result, r.Method+" "+r.Path)
+	}
+
+	return result
+}
+
+func (a *testAPI) gitHub() *GitHub {
+	return &GitHub{
+		BaseURL: a.server.URL,
+		Client:  a.server.Client(),
+		Token:   func(context.Context) (string, error) { return "secret", nil },
+	}
+}
+
+// respond returns a handler replying with status and body, as JSON.
+func respond(status int, body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(status)
+		fmt.Fprint(w, body)
+	}
+}
+
+func TestCreatePR(t *testing.T) {
+	pr := NewPR{Title: "modules: upgrade quote to 1.5.2", Body: "body", Head: "owner:update-rsc.io-quote-v1.5.2", Base: "main"}
+	cases := []struct {
+		name        string
+		handlers    map[string]http.HandlerFunc
+		wantURL     string
+		wantExisted bool
+		wantErr     string
+		wantCalls   []string
+	}{
+		{
+			name: "created",
+			handlers: map[string]http.HandlerFunc{
+				"POST /repos/owner/repo/pulls": respond(http.StatusCreated, `{"html_url": "https://github.com/owner/repo/pull/1"}`),
+			},
+			wantURL:   "https://github.com/owner/repo/pull/1",
+			wantCalls: []string{"POST /repos/owner/repo/pulls"},
+		},
+		{
+			name: "already exists",
+			handlers: map[string]http.HandlerFunc{
+				"POST /repos/owner/repo/pulls": respond(http.StatusUnprocessableEntity, `{"errors": [{"message": "A pull request already exists for owner:update-rsc.io-quote-v1.5.2."}]}`),
+				"GET /repos/owner/repo/pulls?state=open&head=owner%3Aupdate-rsc.io-quote-v1.5.2": respond(http.StatusOK, `[{"html_url": "https://github.com/owner/repo/pull/2"}]`),
+			},
+			wantURL:     "https://github.com/owner/repo/pull/2",
+			wantExisted: true,
+			wantCalls: []string{
+				"POST /repos/owner/repo/pulls",
+				"GET /repos/owner/repo/pulls?state=open&head=owner%3Aupdate-rsc.io-quote-v1.5.2",
+			},
+		},
+		{
+			name: "already exists, but not found",
+			handlers: map[string]http.HandlerFunc{
+				"POST /repos/owner/repo/pulls": respond(http.StatusUnprocessableEntity, `{"errors": [{"message": "A pull request already exists for owner:update-rsc.io-quote-v1.5.2."}]}`),
+				"GET /repos/owner/repo/pulls?state=open&head=owner%3Aupdate-rsc.io-quote-v1.5.2": respond(http.StatusOK, `[]`),
+			},
+			wantErr: "error creating pull request (422 Unprocessable Entity)",
+			wantCalls: []string{
+				"POST /repos/owner/repo/pulls",
+				"GET /repos/owner/repo/pulls?state=open&head=owner%3Aupdate-rsc.io-quote-v1.5.2",
+			},
+		},
+		{
+			name: "validation failed",
+			handlers: map[string]http.HandlerFunc{
+				"POST /repos/owner/repo/pulls": respond(http.StatusUnprocessableEntity, `{"errors": [{"message": "No commits between main and update-rsc.io-quote-v1.5.2"}]}`),
+			},
+			wantErr:   "No commits between",
+			wantCalls: []string{"POST /repos/owner/repo/pulls"},
+		},
+		{
+			name: "forbidden",
+			handlers: map[string]http.HandlerFunc{
+				"POST /repos/owner/repo/pulls": respond(http.StatusForbidden, `{"message": "Resource not accessible by integration"}`),
+			},
+			wantErr:   "error creating pull request (403 Forbidden)",
+			wantCalls: []string{"POST /repos/owner/repo/pulls"},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			api := newTestAPI(t, tc.handlers)
+			gotURL, gotExisted, err := api.gitHub().CreatePR(context.Background(), "owner", "repo", pr)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tc.wantErr, err)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			if gotURL != tc.wantURL {
+				t.Fatalf("expected URL %q, got %q", tc.wantURL, gotURL)
+			}
+
+			if gotExisted != tc.wantExisted {
+				t.Fatalf("expected existed to be %t, got %t", tc.wantExisted, gotExisted)
+			}
+
+			if got := api.calls(); !reflect.DeepEqual(got, tc.wantCalls) {
+				t.Fatalf("expected calls %q, got %q", tc.wantCalls, got)
+			}
+		})
+	}
+}
+
+func TestCreatePRRequest(t *testing.T) {
+	var header http.Header
+	api := newTestAPI(t, map[string]http.HandlerFunc{
+		"POST /repos/owner/repo/pulls": func(w http.ResponseWriter, r *http.Request) {
+			header = r.Header
+			respond(http.StatusCreated, `{"html_url": "https://github.com/owner/repo/pull/1"}`)(w, r)
+		},
+	})
+
+	pr := NewPR{Title: "title", Body: "body", Head: "owner:branch", Base: "main"}
+	if _, _, err := api.gitHub().CreatePR(context.Background(), "owner", "repo", pr); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := header.Get("Authorization"); got != "bearer secret" {
+		t.Fatalf("expected Authorization to be %q, got %q", "bearer secret", got)
+	}
+
+	want := map[string]interface{}{"title": "title", "body": "body", "head": "owner:branch", "base": "main"}
+	if got := api.requests[0].Body; !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %+v, got %+v", want, got)
+	}
+}
+
+func TestUpdatePR(t *testing.T) {
+	api := newTestAPI(t, map[string]http.HandlerFunc{
+		"PATCH /repos/owner/repo/pulls/7":          respond(http.StatusOK, `{}`),
+		"POST /repos/owner/repo/issues/7/comments": respond(http.StatusCreated, `{}`),
+	})
+
+	if err := api.gitHub().UpdatePR(context.Background(), "owner", "repo", "https://github.com/owner/repo/pull/7", "new title", "new body"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []apiRequest{
+		{
+			Method: "PATCH",
+			Path:   "/repos/owner/repo/pulls/7",
+			Body:   map[string]interface{}{"title": "new title", "body": "new body"},
+		},
+		{
+			Method: "POST",
+			Path:   "/repos/owner/repo/issues/7/comments",
+			Body:   map[string]interface{}{"body": "The branch has been replaced, and this pull request updated: new title."},
+		},
+	}
+
+	if !reflect.DeepEqual(api.requests, want) {
+		t.Fatalf("expected %+v, got %+v", want, api.requests)
+	}
+}
+
+func TestUpdatePRBadURL(t *testing.T) {
+	api := newTestAPI(t, nil)
+	if err := api.gitHub().UpdatePR(context.Background(), "owner", "repo", "https://github.com/owner/repo/pulls", "title", "body"); err == nil {
+		t.Fatal("expected an error")
+	}
+
+	if got := api.calls(); len(got) > 0 {
+		t.Fatalf("expected no requests, got %q", got)
+	}
+}
+
+func TestGetRetries(t *testing.T) {
+	attempts := 0
+	api := newTestAPI(t, map[string]http.HandlerFunc{
+		"GET /repos/owner/repo/pulls?state=open&per_page=100": func(w http.ResponseWriter, r *http.Request) {
+			attempts++
+			if attempts < 2 {
+				w.Header().Set("Retry-After", "0")
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+
+			respond(http.StatusOK, `[{"number": 3, "title": "modules: upgrade quote to 1.5.2", "html_url": "https://github.com/owner/repo/pull/3"}]`)(w, r)
+		},
+	})
+
+	got, err := api.gitHub().OpenPRWithTitle(context.Background(), "owner", "repo", "modules: upgrade quote to 1.5.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := "https://github.com/owner/repo/pull/3"; got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+
+	if attempts != 2 {
+		t.Fatalf("expected 2 attempts, got %d", attempts)
+	}
+}
+
+func TestReleaseNotes(t *testing.T) {
+	cases := []struct {
+		name     string
+		tag      string
+		handlers map[string]http.HandlerFunc
+		want     string
+	}{
+		{
+			name: "release",
+			handlers: map[string]http.HandlerFunc{
+				"GET /repos/owner/repo/releases/tags/v1.5.2": respond(http.StatusOK, `{"body": "  Fixes things.\n"}`),
+			},
+			want: "Fixes things.",
+		},
+		{
+			name: "annotated tag",
+			handlers: map[string]http.HandlerFunc{
+				"GET /repos/owner/repo/releases/tags/v1.5.2": respond(http.StatusNotFound, `{}`),
+				"GET /repos/owner/repo/git/ref/tags/v1.5.2":  respond(http.StatusOK, `{"object": {"type": "tag", "sha": "abc"}}`),
+				"GET /repos/owner/repo/git/tags/abc":         respond(http.StatusOK, `{"message": "Tag message."}`),
+			},
+			want: "Tag message.",
+		},
+		{
+			name: "lightweight tag",
+			handlers: map[string]http.HandlerFunc{
+				"GET /repos/owner/repo/releases/tags/v1.5.2": respond(http.StatusNotFound, `{}`),
+				"GET /repos/owner/repo/git/ref/tags/v1.5.2":  respond(http.StatusOK, `{"object": {"type": "commit", "sha": "def"}}`),
+				"GET /repos/owner/repo/git/commits/def":      respond(http.StatusOK, `{"message": "Commit message."}`),
+			},
+			want: "Commit message.",
+		},
+		{
+			name: "rate limited",
+			handlers: map[string]http.HandlerFunc{
+				"GET /repos/owner/repo/releases/tags/v1.5.2": func(w http.ResponseWriter, r *http.Request) {
+					w.Header().Set("X-RateLimit-Remaining", "0")
+					w.Header().Set("X-RateLimit-Reset", "0")
+					w.WriteHeader(http.StatusForbidden)
+				},
+			},
+		},
+		{
+			name: "truncated",
+			handlers: map[string]http.HandlerFunc{
+				"GET /repos/owner/repo/releases/tags/v1.5.2": respond(http.StatusOK, `{"body": "`+strings.Repeat("é", maxReleaseNotes)+`"}`),
+			},
+			want: strings.Repeat("é", maxReleaseNotes/2) + "\n\n(truncated)",
+		},
+		{
+			name: "subdirectory release",
+			tag:  "sub/v1.5.2",
+			handlers: map[string]http.HandlerFunc{
+				"GET /repos/owner/repo/releases/tags/sub/v1.5.2": respond(http.StatusOK, `{"body": "Fixes sub."}`),
+			},
+			want: "Fixes sub.",
+		},
+		{
+			name: "subdirectory tag",
+			tag:  "sub/v1.5.2",
+			handlers: map[string]http.HandlerFunc{
+				"GET /repos/owner/repo/releases/tags/sub/v1.5.2": respond(http.StatusNotFound, `{}`),
+				"GET /repos/owner/repo/git/ref/tags/sub/v1.5.2":  respond(http.StatusOK, `{"object": {"type": "tag", "sha": "abc"}}`),
+				"GET /repos/owner/repo/git/tags/abc":             respond(http.StatusOK, `{"message": "Sub tag message."}`),
+			},
+			want: "Sub tag message.",
+		},
+		{
+			name: "escaped tag",
+			tag:  "sub dir/v1.5.2+meta",
+			handlers: map[string]http.HandlerFunc{
+				"GET /repos/owner/repo/releases/tags/sub%20dir/v1.5.2+meta": respond(http.StatusNotFound, `{}`),
+				"GET /repos/owner/repo/git/ref/tags/sub%20dir/v1.5.2+meta":  respond(http.StatusOK, `{"object": {"type": "commit", "sha": "def"}}`),
+				"GET /repos/owner/repo/git/commits/def":                     respond(http.StatusOK, `{"message": "Commit message."}`),
+			},
+			want: "Commit message.",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			tag := tc.tag
+			if tag == "" {
+				tag = "v1.5.2"
+			}
+
+			api := newTestAPI(t, tc.handlers)
+			if got := api.gitHub().ReleaseNotes(context.Background(), "owner", "repo", tag); got != tc.want {
+				t.Fatalf("expected:\n%s\ngot:\n%s", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestCommitChecks(t *testing.T) {
+	api := newTestAPI(t, map[string]http.HandlerFunc{
+		"GET /repos/owner/repo/commits/abc/check-runs?per_page=100": respond(http.StatusOK, `{"check_runs": [
+			{"name": "build", "status": "completed", "conclusion": "success"},
+			{"name": "lint", "status": "in_progress"}
+		]}`),
+		"GET /repos/owner/repo/commits/abc/status?per_page=100": respond(http.StatusOK, `{"statuses": [
+			{"context": "ci/legacy", "state": "failure"},
+			{"context": "ci/slow", "state": "pending"}
+		]}`),
+	})
+
+	got, err := api.gitHub().CommitChecks(context.Background(), "owner", "repo", "abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Check{
+		{Name: "build", Result: "success"},
+		{Name: "lint"},
+		{Name: "ci/legacy", Result: "failure"},
+		{Name: "ci/slow"},
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %+v, got %+v", want, got)
+	}
+
+	wantFailed := []bool{false, false, true, false}
+	for i, c := range got {
+		if c.Failed() != wantFailed[i] {
+			t.Fatalf("expected %s failed to be %t, got %t", c.Name, wantFailed[i], c.Failed())
+		}
+	}
+}
+
+func TestRequiredChecks(t *testing.T) {
+	api := newTestAPI(t, map[string]http.HandlerFunc{
+		"GET /repos/owner/repo/branches/release%2F1.x/protection/required_status_checks": respond(http.StatusOK, `{"contexts": ["build", "lint"]}`),
+		"GET /repos/owner/repo/branches/main/protection/required_status_checks":          respond(http.StatusForbidden, `{}`),
+	})
+
+	want := map[string]bool{"build": true, "lint": true}
+	if got := api.gitHub().RequiredChecks(context.Background(), "owner", "repo", "release/1.x"); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %+v, got %+v", want, got)
+	}
+
+	if got := api.gitHub().RequiredChecks(context.Background(), "owner", "repo", "main"); got != nil {
+		t.Fatalf("expected no required checks, got %+v", got)
+	}
+}
+
+func TestAzureCreatePR(t *testing.T) {
+	var user, password string
+	api := newTestAPI(t, map[string]http.HandlerFunc{
+		"POST /org/my%20project/_apis/git/repositories/repo/pullrequests?api-version=7.1": func(w http.ResponseWriter, r *http.Request) {
+			user, password, _ = r.BasicAuth()
+			respond(http.StatusCreated, `{"pullRequestId": 12, "repository": {"webUrl": "https://dev.azure.com/org/my%20project/_git/repo"}}`)(w, r)
+		},
+	})
+
+	a := &Azure{BaseURL: api.server.URL, Client: api.server.Client(), Token: "pat"}
+	repo := gitremote.AzureRepo{Org: "org", Project: "my project", Name: "repo"}
+	got, err := a.CreatePR(context.Background(), repo, "update-quote", "main", "title", strings.Repeat("x", maxAzureDescription+1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := "https://dev.azure.com/org/my%20project/_git/repo/pullrequest/12"; got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+
+	if user != "" || password != "pat" {
+		t.Fatalf("expected basic auth with the token as password, got %q:%q", user, password)
+	}
+
+	body := api.requests[0].Body
+	if body["sourceRefName"] != "refs/heads/update-quote" || body["targetRefName"] != "refs/heads/main" {
+		t.Fatalf("unexpected refs in %+v", body)
+	}
+
+	if d := body["description"].(string); len(d) != maxAzureDescription || !strings.HasSuffix(d, "\n\n(truncated)") {
+		t.Fatalf("expected description truncated to %d characters, got %d", maxAzureDescription, len(d))
+	}
+}
@@ -0,0 +1,148 @@
+// Package gitremote parses git remote URLs, to find the host and the
+// repository on it that a remote refers to.
+package gitremote
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Remote is a parsed git remote URL.
+type Remote struct {
+	// Host is the host of the remote, in lower case and without any
+	// port.
+	Host string
+
+	// Path is the path of the repository on the host, without leading
+	// or trailing slashes, or a .git suffix.
+	Path string
+
+	// Owner and Repo are the elements of Path, if it is in OWNER/REPO
+	// form, and empty otherwise.
+	Owner string
+	Repo  string
+}
+
+// Parse parses a git remote URL. Remotes can be URLs (including ssh://
+// URLs, with or without a user and port), or scp-style [user@]host:path
+// remotes. Local paths, and file:// URLs, have no host. Any credentials
+// in the URL are dropped, and left out of errors, so that they can be
+// logged.
+func Parse(rawURL string) (Remote, error) {
+	rawURL = strings.TrimSpace(rawURL)
+	if rawURL == "" {
+		return Remote{}, errors.New("empty remote URL")
+	}
+
+	var host, p string
+	if u, err := url.Parse(rawURL); err == nil && u.Opaque == "" {
+		host, p = u.Hostname(), u.Path
+		if host == "" && u.Scheme != "" && u.Scheme != "file" {
+			return Remote{}, fmt.Errorf("no host in remote URL %q", Redact(rawURL))
+		}
+	} else if strings.Contains(rawURL, "://") {
+		// The error would have the URL in it, credentials and all.
+		return Remote{}, fmt.Errorf("invalid remote URL %q", Redact(rawURL))
+	} else {
+		// scp-style remotes aren't valid URLs, or parse as a URL with
+		// the host as the scheme when there's no user.
+		i := strings.Index(rawURL, ":")
+		if i < 0 || strings.Contains(rawURL[:i], "/") {
+			return Remote{}, fmt.Errorf("unrecognized remote URL %q", rawURL)
+		}
+
+		host, p = rawURL[:i], rawURL[i+1:]
+		if j := strings.LastIndex(host, "@"); j >= 0 {
+			host = host[j+1:]
+		}
+
+		if host == "" {
+			return Remote{}, fmt.Errorf("no host in remote URL %q", rawURL)
+		}
+	}
+
+	// Trailing slashes are allowed after the .git suffix, as well as
+	// before it.
+	p = strings.Trim(p, "/")
+	p = strings.TrimRight(strings.TrimSuffix(p, ".git"), "/")
+
+	r := Remote{Host: strings.ToLower(host), Path: p}
+	parts := strings.Split(p, "/")
+	if len(parts) == 2 && parts[0] != "" && parts[1] != "" {
+		r.Owner, r.Repo = parts[0], parts[1]
+	}
+
+	return r, nil
+}
+
+// String returns the host and path of the remote, as HOST/PATH.
+func (r Remote) String() string {
+	return r.Host + "/" + r.Path
+}
+
+// credentialsRe matches the scheme and user information in a URL.
+var credentialsRe = regexp.MustCompile(`^([A-Za-z][A-Za-z0-9+.-]*)://([^/?#]*)@`)
+
+// Redact returns rawURL without any credentials in it. Over HTTPS, and
+// other schemes, the user name can be a token, so the whole of the user
+// information is removed; over SSH, the user name is kept, as keys are
+// the credentials, and only a password is removed. scp-style remotes
+// are returned as they are, as they can only name a user.
+func Redact(rawURL string) string {
+	m := credentialsRe.FindStringSubmatch(rawURL)
+	if m == nil {
+		return rawURL
+	}
+
+	rest := rawURL[len(m[0]):]
+	switch strings.ToLower(m[1]) {
+	case "ssh", "git+ssh", "ssh+git":
+		user, _, _ := strings.Cut(m[2], ":")
+		return m[1] + "://" + user + "@" + rest
+	}
+
+	return m[1] + "://" + rest
+}
+
+// AzureRepo is a repository in Azure DevOps Repos.
+type AzureRepo struct {
+	Org     string
+	Project string
+	Name    string
+}
+
+// Azure returns the Azure DevOps repository for the remote, and
+// whether it is one.
+// Both dev.azure.com and the older ORG.visualstudio.com URLs are
+// recognized, over HTTPS (with a _git element before the repository)
+// and SSH (with a v3 element before the organization).
+func (r Remote) Azure() (AzureRepo, bool) {
+	host := r.Host
+	parts := strings.Split(r.Path, "/")
+	switch {
+	case host == "ssh.dev.azure.com" || host == "vs-ssh.visualstudio.com":
+		if len(parts) == 4 && parts[0] == "v3" {
+			return AzureRepo{Org: parts[1], Project: parts[2], Name: parts[3]}, true
+		}
+
+	case host == "dev.azure.com":
+		if len(parts) == 4 && parts[2] == "_git" {
+			return AzureRepo{Org: parts[0], Project: parts[1], Name: parts[3]}, true
+		}
+
+	case strings.HasSuffix(host, ".visualstudio.com"):
+		org := strings.TrimSuffix(host, ".visualstudio.com")
+		if len(parts) == 4 && strings.EqualFold(parts[0], "DefaultCollection") {
+			parts = parts[1:]
+		}
+
+		if len(parts) == 3 && parts[1] == "_git" {
+			return AzureRepo{Org: org, Project: parts[0], Name: parts[2]}, true
+		}
+	}
+
+	return AzureRepo{}, false
+}
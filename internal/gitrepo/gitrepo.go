@@ -0,0 +1,312 @@
+// Package gitrepo runs the git operations depbump makes on the
+// repository being updated, and the remotes it pushes to. Commands are
+// run through a Runner, so that they can be replaced in tests.
+package gitrepo
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+// Repo is a git repository, or a worktree of one.
+type Repo struct {
+	// Runner runs git.
+	Runner Runner
+
+	// Dir is the directory git is run in, the current one if empty.
+	Dir string
+
+	// Stdout and Stderr are where the output of git is passed through
+	// to. Errors from git are always passed through, even when the
+	// output is read.
+	Stdout, Stderr io.Writer
+
+	// Logger gets information about fetches made to fill in a shallow
+	// clone. Nothing is logged if it is nil.
+	Logger *slog.Logger
+}
+
+func (r *Repo) command(env []string, args ...string) *Command {
+	return &Command{Name: "git", Args: args, Dir: r.Dir, Env: env}
+}
+
+// Run runs git with args, passing its output through.
+func (r *Repo) Run(ctx context.Context, args ...string) error {
+	return r.RunEnv(ctx, nil, args...)
+}
+
+// RunEnv runs git with args and env, passing its output through.
+func (r *Repo) RunEnv(ctx context.Context, env []string, args ...string) error {
+	c := r.command(env, args...)
+	c.Stdout, c.Stderr = r.Stdout, r.Stderr
+	return r.Runner.Run(ctx, c)
+}
+
+// RunInput runs git with args and env, passing its output through,
+// with stdin as its input.
+func (r *Repo) RunInput(ctx context.Context, env []string, stdin io.Reader, args ...string) error {
+	c := r.command(env, args...)
+	c.Stdin, c.Stdout, c.Stderr = stdin, r.Stdout, r.Stderr
+	return r.Runner.Run(ctx, c)
+}
+
+// Output runs git with args, and returns its output.
+func (r *Repo) Output(ctx context.Context, args ...string) (string, error) {
+	return r.OutputEnv(ctx, nil, args...)
+}
+
+// OutputEnv runs git with args and env, and returns its output.
+func (r *Repo) OutputEnv(ctx context.Context, env []string, args ...string) (string, error) {
+	c := r.command(env, args...)
+	c.Stderr = r.Stderr
+	out, err := Output(ctx, r.Runner, c)
+	return string(out), err
+}
+
+// line runs git with args, and returns the first line of its output,
+// without surrounding whitespace.
+func (r *Repo) line(ctx context.Context, args ...string) (string, error) {
+	out, err := r.Output(ctx, args...)
+	l, _, _ := strings.Cut(strings.TrimSpace(out), "\n")
+	return l, err
+}
+
+// Succeeds runs git with args, without passing anything through, and
+// returns true if it succeeded.
+func (r *Repo) Succeeds(ctx context.Context, args ...string) bool {
+	return r.Runner.Run(ctx, r.command(nil, args...)) == nil
+}
+
+// CurrentBranch returns the name of the branch that is checked out. If
+// HEAD is detached, as CI systems often leave it, the commit it is at
+// is returned instead, and detached is set.
+func (r *Repo) CurrentBranch(ctx context.Context) (name string, detached bool, err error) {
+	out, err := Output(ctx, r.Runner, r.command(nil, "symbolic-ref", "-q", "--short", "HEAD"))
+	if err == nil {
+		return strings.TrimSpace(string(out)), false, nil
+	}
+
+	// symbolic-ref fails quietly, with status 1, only when HEAD isn't a
+	// symbolic ref.
+	if ExitCode(err) != 1 {
+		return "", false, fmt.Errorf("error finding the current branch: %w", err)
+	}
+
+	head, err := r.Head(ctx)
+	if err != nil {
+		return "", false, err
+	}
+
+	return head, true, nil
+}
+
+// Head returns the commit HEAD is at.
+func (r *Repo) Head(ctx context.Context) (string, error) {
+	return r.line(ctx, "rev-parse", "HEAD")
+}
+
+// TopLevel returns the top-level directory of the repository.
+func (r *Repo) TopLevel(ctx context.Context) (string, error) {
+	return r.line(ctx, "rev-parse", "--show-toplevel")
+}
+
+// Prefix returns the path of Dir relative to the top of the repository,
+// ending in a slash, or an empty string at the top.
+func (r *Repo) Prefix(ctx context.Context) (string, error) {
+	return r.line(ctx, "rev-parse", "--show-prefix")
+}
+
+// CommonDir returns the absolute path of the git directory shared by
+// the repository's worktrees.
+func (r *Repo) CommonDir(ctx context.Context) (string, error) {
+	return r.line(ctx, "rev-parse", "--path-format=absolute", "--git-common-dir")
+}
+
+// Config returns the value of the git config key, or an empty string
+// if it isn't set.
+func (r *Repo) Config(ctx context.Context, key string) (string, error) {
+	out, err := r.Output(ctx, "config", "--get", key)
+	if err != nil {
+		if ExitCode(err) == 1 {
+			return "", nil
+		}
+
+		return "", fmt.Errorf("error reading git config %s: %w", key, err)
+	}
+
+	return strings.TrimSpace(out), nil
+}
+
+// Remotes returns the names of the repository's remotes.
+func (r *Repo) Remotes(ctx context.Context) ([]string, error) {
+	out, err := r.Output(ctx, "remote")
+	return strings.Fields(out), err
+}
+
+// HasRemote returns true if there is a remote called name.
+func (r *Repo) HasRemote(ctx context.Context, name string) (bool, error) {
+	remotes, err := r.Remotes(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	for _, rem := range remotes {
+		if rem == name {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// RemoteURL returns the URL of the remote called name.
+func (r *Repo) RemoteURL(ctx context.Context, name string) (string, error) {
+	return r.line(ctx, "remote", "get-url", name)
+}
+
+// RefExists returns true if ref, a full ref name, exists.
+func (r *Repo) RefExists(ctx context.Context, ref string) bool {
+	return r.Succeeds(ctx, "show-ref", "--verify", "--quiet", ref)
+}
+
+// BranchExists returns true if there is a local branch named branch.
+func (r *Repo) BranchExists(ctx context.Context, branch string) (bool, error) {
+	err := r.Runner.Run(ctx, r.command(nil, "show-ref", "--verify", "--quiet", "refs/heads/"+branch))
+	switch {
+	case err == nil:
+		return true, nil
+
+	case ExitCode(err) == 1:
+		return false, nil
+	}
+
+	return false, fmt.Errorf("error checking for local branch: %w", err)
+}
+
+// ValidBranchName returns true if name is allowed as a branch name.
+func (r *Repo) ValidBranchName(ctx context.Context, name string) bool {
+	return r.Succeeds(ctx, "check-ref-format", "refs/heads/"+name)
+}
+
+// IsShallow returns true if the repository is a shallow clone.
+func (r *Repo) IsShallow(ctx context.Context) (bool, error) {
+	out, err := r.line(ctx, "rev-parse", "--is-shallow-repository")
+	return out == "true", err
+}
+
+// IsAncestor returns true if commit a is an ancestor of b, or the same
+// commit.
+func (r *Repo) IsAncestor(ctx context.Context, a, b string) bool {
+	return r.Succeeds(ctx, "merge-base", "--is-ancestor", a, b)
+}
+
+// spaceRe matches the separators in the output of ls-remote.
+var spaceRe = regexp.MustCompile(`\s+`)
+
+// DefaultBranch returns the branch the HEAD of remote points at.
+func (r *Repo) DefaultBranch(ctx context.Context, remote string) (string, error) {
+	out, err := r.Output(ctx, "ls-remote", "--symref", remote, "HEAD")
+	if err != nil {
+		return "", fmt.Errorf("error detecting default remote branch: %w", err)
+	}
+
+	// The symbolic ref is on the first line, as in
+	// "ref: refs/heads/main	HEAD".
+	l, _, _ := strings.Cut(out, "\n")
+	parts := spaceRe.Split(strings.TrimSpace(l), -1)
+	if len(parts) != 3 || parts[0] != "ref:" {
+		return "", fmt.Errorf("bad remote HEAD line: %s", l)
+	}
+
+	return strings.TrimPrefix(parts[1], "refs/heads/"), nil
+}
+
+// RemoteHeads returns the commit each branch on remote is at, keyed by
+// branch name, using env. If branches are given, only those are
+// listed.
+func (r *Repo) RemoteHeads(ctx context.Context, remote string, env []string, branches ...string) (map[string]string, error) {
+	out, err := r.OutputEnv(ctx, env, append([]string{"ls-remote", "--heads", remote}, branches...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	heads := make(map[string]string)
+	for _, l := range strings.Split(strings.TrimSpace(out), "\n") {
+		if f := strings.Fields(l); len(f) == 2 {
+			heads[strings.TrimPrefix(f[1], "refs/heads/")] = f[0]
+		}
+	}
+
+	return heads, nil
+}
+
+// ShallowError is returned by EnsureOnBase when HEAD can't be seen to
+// be on the base branch, because of what a shallow clone is missing.
+type ShallowError struct {
+	Remote, Base string
+
+	// Missing is set if the base branch itself is missing, rather than
+	// the history connecting it to HEAD. Fetch is then the git command,
+	// without "git", that fetches it.
+	Missing bool
+	Fetch   []string
+}
+
+func (e *ShallowError) Error() string {
+	if e.Missing {
+		return fmt.Sprintf("the repository is a shallow clone without base branch %s; fetch it with:\n    git %s", e.Base, strings.Join(e.Fetch, " "))
+	}
+
+	return fmt.Sprintf("HEAD is not on base branch %s within the history of this shallow clone; fetch more with:\n    git fetch --unshallow %s", e.Base, e.Remote)
+}
+
+// EnsureOnBase makes sure that, in a shallow clone, HEAD can be seen to
+// be on base, the branch on remote that a pull request is opened
+// against, so that the pull request only contains the update. Shallow
+// and single-branch clones may have neither the base branch nor enough
+// history to tell. With depth set, the missing history is fetched, up
+// to depth commits; otherwise a *ShallowError says what to fetch.
+func (r *Repo) EnsureOnBase(ctx context.Context, remote, base string, depth int) error {
+	ref := "refs/remotes/" + remote + "/" + base
+	if !r.RefExists(ctx, ref) {
+		fetch := []string{"fetch", "-q", "--depth=" + fmt.Sprint(max(depth, 1)), remote, "+refs/heads/" + base + ":" + ref}
+		if depth < 1 {
+			return &ShallowError{Remote: remote, Base: base, Missing: true, Fetch: fetch}
+		}
+
+		r.logger().InfoContext(ctx, "fetching base branch", "branch", base, "depth", depth)
+		if err := r.Run(ctx, fetch...); err != nil {
+			return fmt.Errorf("error fetching base branch %s: %w", base, err)
+		}
+	}
+
+	if r.IsAncestor(ctx, "HEAD", ref) {
+		return nil
+	}
+
+	if depth > 0 {
+		r.logger().InfoContext(ctx, "deepening history to find HEAD on base branch", "branch", base, "depth", depth)
+		if err := r.Run(ctx, "fetch", "-q", "--deepen="+fmt.Sprint(depth), remote, "+refs/heads/"+base+":"+ref); err != nil {
+			return fmt.Errorf("error fetching history of base branch %s: %w", base, err)
+		}
+
+		if r.IsAncestor(ctx, "HEAD", ref) {
+			return nil
+		}
+	}
+
+	return &ShallowError{Remote: remote, Base: base}
+}
+
+// logger returns Logger, or one that discards everything if it is nil.
+func (r *Repo) logger() *slog.Logger {
+	if r.Logger == nil {
+		return slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+
+	return r.Logger
+}
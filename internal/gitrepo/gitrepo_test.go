@@ -0,0 +1,431 @@
+package gitrepo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// exitError is the error of a fake command that exited with a status.
+type exitError int
+
+func (e exitError) Error() string { return fmt.Sprintf("exit status %d", int(e)) }
+func (e exitError) ExitCode() int { return int(e) }
+
+// fakeResult is what a fake command outputs, and the status it exits
+// with.
+type fakeResult struct {
+	out  string
+	code int
+}
+
+// fakeRunner runs commands by looking up their arguments in results,
+// and records the commands it is asked to run. Commands without a
+// result succeed, without output.
+type fakeRunner struct {
+	results map[string]fakeResult
+	calls   []string
+}
+
+func (f *fakeRunner) Run(ctx context.Context, c *Command) error {
+	call := strings.Join(append([]string{c.Name}, c.Args...), " ")
+	f.calls = append(f.calls, call)
+
+	r := f.results[call]
+	if c.Stdout != nil {
+		fmt.Fprint(c.Stdout, r.out)
+	}
+
+	if r.code != 0 {
+		return exitError(r.code)
+	}
+
+	return nil
+}
+
+func newFakeRepo(results map[string]fakeResult) (*Repo, *fakeRunner) {
+	f := &fakeRunner{results: results}
+	return &Repo{Runner: f, Dir: "/repo"}, f
+}
+
+func TestCurrentBranch(t *testing.T) {
+	cases := []struct {
+		name         string
+		results      map[string]fakeResult
+		want         string
+		wantDetached bool
+		wantErr      bool
+		wantCalls    []string
+	}{
+		{
+			name: "branch",
+			results: map[string]fakeResult{
+				"git symbolic-ref -q --short HEAD": {out: "main\n"},
+			},
+			want:      "main",
+			wantCalls: []string{"git symbolic-ref -q --short HEAD"},
+		},
+		{
+			name: "detached",
+			results: map[string]fakeResult{
+				"git symbolic-ref -q --short HEAD": {code: 1},
+				"git rev-parse HEAD":               {out: "0123456789abcdef0123456789abcdef01234567\n"},
+			},
+			want:         "0123456789abcdef0123456789abcdef01234567",
+			wantDetached: true,
+			wantCalls:    []string{"git symbolic-ref -q --short HEAD", "git rev-parse HEAD"},
+		},
+		{
+			name: "not a repository",
+			results: map[string]fakeResult{
+				"git symbolic-ref -q --short HEAD": {code: 128},
+			},
+			wantErr:   true,
+			wantCalls: []string{"git symbolic-ref -q --short HEAD"},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			repo, f := newFakeRepo(tc.results)
+			got, detached, err := repo.CurrentBranch(context.Background())
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("expected error to be %t, got %v", tc.wantErr, err)
+			}
+
+			if got != tc.want {
+				t.Fatalf("expected %q, got %q", tc.want, got)
+			}
+
+			if detached != tc.wantDetached {
+				t.Fatalf("expected detached to be %t, got %t", tc.wantDetached, detached)
+			}
+
+			if !reflect.DeepEqual(f.calls, tc.wantCalls) {
+				t.Fatalf("expected calls %q, got %q", tc.wantCalls, f.calls)
+			}
+		})
+	}
+}
+
+func TestConfig(t *testing.T) {
+	repo, _ := newFakeRepo(map[string]fakeResult{
+		"git config --get user.name":       {out: "A U Thor\n"},
+		"git config --get user.signingkey": {code: 1},
+		"git config --get bad":             {code: 2},
+	})
+
+	if got, err := repo.Config(context.Background(), "user.name"); err != nil || got != "A U Thor" {
+		t.Fatalf("expected %q, got %q (%v)", "A U Thor", got, err)
+	}
+
+	if got, err := repo.Config(context.Background(), "user.signingkey"); err != nil || got != "" {
+		t.Fatalf("expected unset key to be empty, got %q (%v)", got, err)
+	}
+
+	if _, err := repo.Config(context.Background(), "bad"); err == nil {
+		t.Fatal("expected an error for a failing git config")
+	}
+}
+
+func TestBranchExists(t *testing.T) {
+	repo, _ := newFakeRepo(map[string]fakeResult{
+		"git show-ref --verify --quiet refs/heads/missing": {code: 1},
+		"git show-ref --verify --quiet refs/heads/broken":  {code: 128},
+	})
+
+	if ok, err := repo.BranchExists(context.Background(), "main"); err != nil || !ok {
+		t.Fatalf("expected main to exist, got %t (%v)", ok, err)
+	}
+
+	if ok, err := repo.BranchExists(context.Background(), "missing"); err != nil || ok {
+		t.Fatalf("expected missing not to exist, got %t (%v)", ok, err)
+	}
+
+	if _, err := repo.BranchExists(context.Background(), "broken"); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestDefaultBranch(t *testing.T) {
+	cases := []struct {
+		name    string
+		result  fakeResult
+		want    string
+		wantErr bool
+	}{
+		{
+			name:   "main",
+			result: fakeResult{out: "ref: refs/heads/main\tHEAD\n0123456789abcdef0123456789abcdef01234567\tHEAD\n"},
+			want:   "main",
+		},
+		{
+			name:   "slash in name",
+			result: fakeResult{out: "ref: refs/heads/release/1.x\tHEAD\n0123456789abcdef0123456789abcdef01234567\tHEAD\n"},
+			want:   "release/1.x",
+		},
+		{
+			name:    "no symref",
+			result:  fakeResult{out: "0123456789abcdef0123456789abcdef01234567\tHEAD\n"},
+			wantErr: true,
+		},
+		{
+			name:    "failed",
+			result:  fakeResult{code: 128},
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			repo, _ := newFakeRepo(map[string]fakeResult{"git ls-remote --symref origin HEAD": tc.result})
+			got, err := repo.DefaultBranch(context.Background(), "origin")
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("expected error to be %t, got %v", tc.wantErr, err)
+			}
+
+			if got != tc.want {
+				t.Fatalf("expected %q, got %q", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestRemoteHeads(t *testing.T) {
+	repo, _ := newFakeRepo(map[string]fakeResult{
+		"git ls-remote --heads origin": {out: "aaa\trefs/heads/main\nbbb\trefs/heads/update-quote-v1.5.2\n"},
+	})
+
+	got, err := repo.RemoteHeads(context.Background(), "origin", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{"main": "aaa", "update-quote-v1.5.2": "bbb"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %+v, got %+v", want, got)
+	}
+}
+
+func TestEnsureOnBase(t *testing.T) {
+	const (
+		refExists  = "git show-ref --verify --quiet refs/remotes/origin/main"
+		isAncestor = "git merge-base --is-ancestor HEAD refs/remotes/origin/main"
+	)
+
+	cases := []struct {
+		name        string
+		depth       int
+		results     map[string]fakeResult
+		wantShallow *ShallowError
+		wantCalls   []string
+	}{
+		{
+			name:      "on base",
+			wantCalls: []string{refExists, isAncestor},
+		},
+		{
+			name:    "missing base",
+			results: map[string]fakeResult{refExists: {code: 1}},
+			wantShallow: &ShallowError{
+				Remote:  "origin",
+				Base:    "main",
+				Missing: true,
+				Fetch:   []string{"fetch", "-q", "--depth=1", "origin", "+refs/heads/main:refs/remotes/origin/main"},
+			},
+			wantCalls: []string{refExists},
+		},
+		{
+			name:    "missing base fetched",
+			depth:   50,
+			results: map[string]fakeResult{refExists: {code: 1}},
+			wantCalls: []string{
+				refExists,
+				"git fetch -q --depth=50 origin +refs/heads/main:refs/remotes/origin/main",
+				isAncestor,
+			},
+		},
+		{
+			name:        "history missing",
+			results:     map[string]fakeResult{isAncestor: {code: 1}},
+			wantShallow: &ShallowError{Remote: "origin", Base: "main"},
+			wantCalls:   []string{refExists, isAncestor},
+		},
+		{
+			name:        "history not enough",
+			depth:       50,
+			results:     map[string]fakeResult{isAncestor: {code: 1}},
+			wantShallow: &ShallowError{Remote: "origin", Base: "main"},
+			wantCalls: []string{
+				refExists,
+				isAncestor,
+				"git fetch -q --deepen=50 origin +refs/heads/main:refs/remotes/origin/main",
+				isAncestor,
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			repo, f := newFakeRepo(tc.results)
+			err := repo.EnsureOnBase(context.Background(), "origin", "main", tc.depth)
+
+			var shallow *ShallowError
+			errors.As(err, &shallow)
+			if tc.wantShallow == nil && err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(shallow, tc.wantShallow) {
+				t.Fatalf("expected %+v, got %+v", tc.wantShallow, shallow)
+			}
+
+			if !reflect.DeepEqual(f.calls, tc.wantCalls) {
+				t.Fatalf("expected calls:\n%s\ngot:\n%s", strings.Join(tc.wantCalls, "\n"), strings.Join(f.calls, "\n"))
+			}
+		})
+	}
+}
+
+// recordingRunner runs commands with Exec, and records the commands it
+// is asked to run.
+type recordingRunner struct {
+	calls []string
+}
+
+func (r *recordingRunner) Run(ctx context.Context, c *Command) error {
+	r.calls = append(r.calls, strings.Join(append([]string{c.Name}, c.Args...), " "))
+	return Exec{}.Run(ctx, c)
+}
+
+// shallowClone returns a repository cloned with depth 1 from one with
+// five commits on main, with only the release branch, which is at the
+// second commit.
+func shallowClone(t *testing.T) *Repo {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+
+	git := func(dir string, args ...string) {
+		t.Helper()
+		args = append([]string{"-c", "user.name=A U Thor", "-c", "user.email=author@example.com", "-c", "init.defaultBranch=main"}, args...)
+		if out, err := CombinedOutput(context.Background(), Exec{}, &Command{Name: "git", Args: args, Dir: dir}); err != nil {
+			t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+		}
+	}
+
+	origin := filepath.Join(t.TempDir(), "origin")
+	git("", "init", "-q", origin)
+	for i := 1; i <= 5; i++ {
+		git(origin, "commit", "-q", "--allow-empty", "-m", fmt.Sprintf("commit %d", i))
+		if i == 2 {
+			git(origin, "branch", "release")
+		}
+	}
+
+	clone := filepath.Join(t.TempDir(), "clone")
+	git("", "clone", "-q", "--depth", "1", "--single-branch", "--branch", "release", "file://"+origin, clone)
+	return &Repo{Runner: &recordingRunner{}, Dir: clone}
+}
+
+func TestEnsureOnBaseShallowClone(t *testing.T) {
+	const fetched = "refs/remotes/origin/main"
+	cases := []struct {
+		name        string
+		depth       int
+		wantShallow *ShallowError
+		wantFetches []string
+	}{
+		{
+			name: "missing base",
+			wantShallow: &ShallowError{
+				Remote:  "origin",
+				Base:    "main",
+				Missing: true,
+				Fetch:   []string{"fetch", "-q", "--depth=1", "origin", "+refs/heads/main:" + fetched},
+			},
+		},
+		{
+			name:        "history not enough",
+			depth:       1,
+			wantShallow: &ShallowError{Remote: "origin", Base: "main"},
+			wantFetches: []string{
+				"git fetch -q --depth=1 origin +refs/heads/main:" + fetched,
+				"git fetch -q --deepen=1 origin +refs/heads/main:" + fetched,
+			},
+		},
+		{
+			name:  "history deepened",
+			depth: 3,
+			wantFetches: []string{
+				"git fetch -q --depth=3 origin +refs/heads/main:" + fetched,
+				"git fetch -q --deepen=3 origin +refs/heads/main:" + fetched,
+			},
+		},
+		{
+			name:  "base fetched",
+			depth: 10,
+			wantFetches: []string{
+				"git fetch -q --depth=10 origin +refs/heads/main:" + fetched,
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			repo := shallowClone(t)
+			ctx := context.Background()
+			shallow, err := repo.IsShallow(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !shallow {
+				t.Fatal("expected a shallow clone")
+			}
+
+			err = repo.EnsureOnBase(ctx, "origin", "main", tc.depth)
+
+			var shallowErr *ShallowError
+			errors.As(err, &shallowErr)
+			if tc.wantShallow == nil && err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(shallowErr, tc.wantShallow) {
+				t.Fatalf("expected %+v, got %+v", tc.wantShallow, shallowErr)
+			}
+
+			var fetches []string
+			for _, c := range repo.Runner.(*recordingRunner).calls {
+				if strings.HasPrefix(c, "git fetch") {
+					fetches = append(fetches, c)
+				}
+			}
+
+			if !reflect.DeepEqual(fetches, tc.wantFetches) {
+				t.Fatalf("expected fetches:\n%s\ngot:\n%s", strings.Join(tc.wantFetches, "\n"), strings.Join(fetches, "\n"))
+			}
+
+			if tc.wantShallow == nil && !repo.IsAncestor(ctx, "HEAD", fetched) {
+				t.Fatal("expected HEAD to be on the fetched base branch")
+			}
+		})
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	if got := ExitCode(fmt.Errorf("wrapped: %w", exitError(3))); got != 3 {
+		t.Fatalf("expected 3, got %d", got)
+	}
+
+	if got := ExitCode(errors.New("not started")); got != -1 {
+		t.Fatalf("expected -1, got %d", got)
+	}
+}
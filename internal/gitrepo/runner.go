@@ -0,0 +1,78 @@
+package gitrepo
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+)
+
+// Command is a command for a Runner to run.
+type Command struct {
+	Name string
+	Args []string
+
+	// Dir is the directory to run the command in, or the current one if
+	// it is empty. Env is added to the environment the command would
+	// otherwise get.
+	Dir string
+	Env []string
+
+	// Stdin, Stdout, and Stderr are connected to the command, if set.
+	// Output that isn't connected is discarded.
+	Stdin          io.Reader
+	Stdout, Stderr io.Writer
+}
+
+// Runner runs commands. Run returns once the command has finished, with
+// an error if it couldn't be started, or didn't succeed. The exit
+// status of a command that ran, but failed, can be told with ExitCode.
+type Runner interface {
+	Run(ctx context.Context, c *Command) error
+}
+
+// Exec runs commands as subprocesses of depbump, which are killed if
+// the context is canceled.
+type Exec struct{}
+
+// Run implements Runner.
+func (Exec) Run(ctx context.Context, c *Command) error {
+	cmd := exec.CommandContext(ctx, c.Name, c.Args...)
+	cmd.Dir = c.Dir
+	if len(c.Env) > 0 {
+		cmd.Env = append(os.Environ(), c.Env...)
+	}
+
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = c.Stdin, c.Stdout, c.Stderr
+	return cmd.Run()
+}
+
+// ExitCode returns the exit status of the command that failed with err,
+// or -1 if err doesn't come from a command that ran.
+func ExitCode(err error) int {
+	var exitErr interface{ ExitCode() int }
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+
+	return -1
+}
+
+// Output runs c with runner, and returns its standard output.
+func Output(ctx context.Context, runner Runner, c *Command) ([]byte, error) {
+	out := new(bytes.Buffer)
+	c.Stdout = out
+	err := runner.Run(ctx, c)
+	return out.Bytes(), err
+}
+
+// CombinedOutput runs c with runner, and returns its standard output and
+// standard error together.
+func CombinedOutput(ctx context.Context, runner Runner, c *Command) ([]byte, error) {
+	out := new(bytes.Buffer)
+	c.Stdout, c.Stderr = out, out
+	err := runner.Run(ctx, c)
+	return out.Bytes(), err
+}
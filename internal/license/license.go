@@ -0,0 +1,133 @@
+// Package license finds the license file of a module, and identifies
+// common licenses well enough to tell when a module changes license.
+package license
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// License is the license file found in a module.
+type License struct {
+	// File is the name of the license file, relative to the module
+	// root.
+	File string
+
+	// ID is the SPDX identifier of the license, or empty if it isn't
+	// one that is recognized.
+	ID string
+
+	// Hash is a hash of the license text, with whitespace normalized,
+	// so that unrecognized licenses can still be compared.
+	Hash string
+}
+
+// Same returns true if l and other are the same license. Recognized
+// licenses are compared by identifier, so that changes to copyright
+// lines and formatting are ignored, and others by content.
+func (l License) Same(other License) bool {
+	if l.ID != "" && other.ID != "" {
+		return l.ID == other.ID
+	}
+
+	return l.Hash == other.Hash
+}
+
+// String returns the identifier of the license, or a description of
+// the file if it isn't recognized. The zero License describes a module
+// without a license file.
+func (l License) String() string {
+	if l.File == "" {
+		return "no license file"
+	}
+
+	if l.ID != "" {
+		return l.ID
+	}
+
+	return fmt.Sprintf("unrecognized license in %s", l.File)
+}
+
+// fileRe matches the names of license files.
+var fileRe = regexp.MustCompile(`(?i)^(licen[cs]e|copying)([-._].*)?$`)
+
+// Find returns the license in the module rooted at dir. If there are
+// several license files, the first in lexical order is used. If there
+// is no license file, the zero License and false are returned.
+func Find(dir string) (License, bool, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return License{}, false, err
+	}
+
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() && fileRe.MatchString(e.Name()) {
+			names = append(names, e.Name())
+		}
+	}
+
+	if len(names) < 1 {
+		return License{}, false, nil
+	}
+
+	sort.Strings(names)
+	data, err := ioutil.ReadFile(filepath.Join(dir, names[0]))
+	if err != nil {
+		return License{}, false, err
+	}
+
+	text := strings.Join(strings.Fields(string(data)), " ")
+	return License{
+		File: names[0],
+		ID:   Identify(text),
+		Hash: fmt.Sprintf("%x", sha256.Sum256([]byte(text))),
+	}, true, nil
+}
+
+// classifiers identify licenses by phrases that appear in their text,
+// in order: the first one with all of its phrases present wins, so more
+// specific licenses come before those they resemble.
+var classifiers = []struct {
+	id      string
+	phrases []string
+}{
+	{"AGPL-3.0", []string{"gnu affero general public license", "version 3"}},
+	{"LGPL-3.0", []string{"gnu lesser general public license", "version 3"}},
+	{"LGPL-2.1", []string{"gnu lesser general public license", "version 2.1"}},
+	{"GPL-3.0", []string{"gnu general public license", "version 3"}},
+	{"GPL-2.0", []string{"gnu general public license", "version 2"}},
+	{"MPL-2.0", []string{"mozilla public license", "2.0"}},
+	{"Apache-2.0", []string{"apache license", "version 2.0"}},
+	{"BSD-3-Clause", []string{"redistribution and use in source and binary forms", "neither the name"}},
+	{"BSD-2-Clause", []string{"redistribution and use in source and binary forms"}},
+	{"ISC", []string{"permission to use, copy, modify, and/or distribute this software for any purpose"}},
+	{"MIT", []string{"permission is hereby granted, free of charge"}},
+	{"Unlicense", []string{"this is free and unencumbered software released into the public domain"}},
+}
+
+// Identify returns the SPDX identifier of the license text, or an empty
+// string if it isn't recognized.
+func Identify(text string) string {
+	text = strings.ToLower(strings.Join(strings.Fields(text), " "))
+	for _, c := range classifiers {
+		matched := true
+		for _, p := range c.phrases {
+			if !strings.Contains(text, p) {
+				matched = false
+				break
+			}
+		}
+
+		if matched {
+			return c.id
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,335 @@
+// Package modinfo answers questions about the requirements in a go.mod
+// file, without shelling out to the go tool.
+package modinfo
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/mod/modfile"
+)
+
+// ErrNotFound is returned when a module is not required by the go.mod
+// file.
+var ErrNotFound = errors.New("module not found in go.mod")
+
+// Requirement is a single require directive. Tool is set if the
+// module provides packages named in tool directives.
+type Requirement struct {
+	Path     string
+	Version  string
+	Indirect bool
+	Tool     bool
+}
+
+// Replacement is a single replace directive. OldVersion is empty if
+// the replace applies to all versions of OldPath, and NewVersion is
+// empty if the replacement is a local directory.
+type Replacement struct {
+	OldPath    string
+	OldVersion string
+	NewPath    string
+	NewVersion string
+}
+
+// File is a parsed go.mod file.
+type File struct {
+	// Path is the location of the go.mod file on disk.
+	Path string
+
+	f *modfile.File
+}
+
+// Find returns the path to the go.mod file for the module containing
+// dir, searching parent directories in the same way the go tool does.
+func Find(dir string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+
+	for {
+		p := filepath.Join(dir, "go.mod")
+		if fi, err := os.Stat(p); err == nil && !fi.IsDir() {
+			return p, nil
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", errors.New("go.mod file not found in current directory or any parent directory")
+		}
+
+		dir = parent
+	}
+}
+
+// Load finds and parses the go.mod file for the module containing dir.
+func Load(dir string) (*File, error) {
+	p, err := Find(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	return Parse(p)
+}
+
+// Parse parses the go.mod file at path.
+func Parse(path string) (*File, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseData(path, data)
+}
+
+// ParseData parses the contents of a go.mod file. path is used in
+// errors, and as the location of the file.
+func ParseData(path string, data []byte) (*File, error) {
+	f, err := modfile.Parse(path, data, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s: %w", path, err)
+	}
+
+	return &File{Path: path, f: f}, nil
+}
+
+// require returns the require directive for path, or nil if there
+// isn't one.
+func (f *File) require(path string) *modfile.Require {
+	for _, r := range f.f.Require {
+		if r.Mod.Path == path {
+			return r
+		}
+	}
+
+	return nil
+}
+
+// Version returns the required version of path. ErrNotFound is returned
+// if the module is not required.
+func (f *File) Version(path string) (string, error) {
+	r := f.require(path)
+	if r == nil {
+		return "", ErrNotFound
+	}
+
+	return r.Mod.Version, nil
+}
+
+// Indirect returns true if path is required, and marked as an indirect
+// dependency.
+func (f *File) Indirect(path string) bool {
+	r := f.require(path)
+	return r != nil && r.Indirect
+}
+
+// Replaced returns the replace directive that applies to the required
+// version of path, if there is one.
+func (f *File) Replaced(path string) (Replacement, bool) {
+	version, _ := f.Version(path)
+	for _, r := range f.f.Replace {
+		if r.Old.Path != path {
+			continue
+		}
+
+		if r.Old.Version != "" && r.Old.Version != version {
+			continue
+		}
+
+		return Replacement{
+			OldPath:    r.Old.Path,
+			OldVersion: r.Old.Version,
+			NewPath:    r.New.Path,
+			NewVersion: r.New.Version,
+		}, true
+	}
+
+	return Replacement{}, false
+}
+
+// Requirements returns all require directives, in file order.
+func (f *File) Requirements() []Requirement {
+	var result []Requirement
+	for _, r := range f.f.Require {
+		result = append(result, Requirement{
+			Path:     r.Mod.Path,
+			Version:  r.Mod.Version,
+			Indirect: r.Indirect,
+			Tool:     len(f.Tools(r.Mod.Path)) > 0,
+		})
+	}
+
+	return result
+}
+
+// Tools returns the packages named in tool directives that are
+// provided by the module path. A tool package is considered to be
+// provided by the required module with the longest path that prefixes
+// it, since modules can be nested.
+func (f *File) Tools(path string) []string {
+	var result []string
+	for _, t := range f.f.Tool {
+		var owner string
+		for _, r := range f.f.Require {
+			p := r.Mod.Path
+			if (t.Path == p || strings.HasPrefix(t.Path, p+"/")) && len(p) > len(owner) {
+				owner = p
+			}
+		}
+
+		if owner == path {
+			result = append(result, t.Path)
+		}
+	}
+
+	return result
+}
+
+// DirectRequirements returns the require directives that are not
+// marked as indirect, in file order. Tool dependencies are included,
+// and marked with Tool.
+func (f *File) DirectRequirements() []Requirement {
+	var result []Requirement
+	for _, r := range f.Requirements() {
+		if !r.Indirect {
+			result = append(result, r)
+		}
+	}
+
+	return result
+}
+
+// WorkspaceModules returns the directories of the modules used by the
+// go.work file at path. Relative directories are resolved against the
+// directory containing the go.work file.
+func WorkspaceModules(path string) ([]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := modfile.ParseWork(path, data, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s: %w", path, err)
+	}
+
+	var result []string
+	for _, u := range f.Use {
+		dir := u.Path
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(filepath.Dir(path), dir)
+		}
+
+		result = append(result, dir)
+	}
+
+	return result, nil
+}
+
+// FindAll walks the tree under root and returns the directory of every
+// go.mod file found, in lexical order. vendor and testdata directories
+// are skipped, as are directories the go tool ignores (those beginning
+// with "." or "_").
+func FindAll(root string) ([]string, error) {
+	var result []string
+	err := filepath.Walk(root, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if fi.IsDir() {
+			name := fi.Name()
+			if p != root && (name == "vendor" || name == "testdata" || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_")) {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		if fi.Name() == "go.mod" {
+			result = append(result, filepath.Dir(p))
+		}
+
+		return nil
+	})
+
+	return result, err
+}
+
+// GoVersion returns the version in the go directive, or an empty
+// string if there isn't one.
+func (f *File) GoVersion() string {
+	if f.f.Go == nil {
+		return ""
+	}
+
+	return f.f.Go.Version
+}
+
+// Toolchain returns the name in the toolchain directive, or an empty
+// string if there isn't one.
+func (f *File) Toolchain() string {
+	if f.f.Toolchain == nil {
+		return ""
+	}
+
+	return f.f.Toolchain.Name
+}
+
+// FoldMatches returns the required module paths that are equal to path
+// when compared case-insensitively.
+func (f *File) FoldMatches(path string) []string {
+	var result []string
+	for _, r := range f.f.Require {
+		if strings.EqualFold(r.Mod.Path, path) {
+			result = append(result, r.Mod.Path)
+		}
+	}
+
+	return result
+}
+
+// Similar returns required module paths that are close to path: those
+// a small number of edits away, or sharing the same last element.
+func (f *File) Similar(path string) []string {
+	base := strings.ToLower(path[strings.LastIndex(path, "/")+1:])
+	var result []string
+	for _, r := range f.f.Require {
+		p := r.Mod.Path
+		if editDistance(strings.ToLower(p), strings.ToLower(path)) <= 3 || strings.ToLower(p[strings.LastIndex(p, "/")+1:]) == base {
+			result = append(result, p)
+		}
+	}
+
+	return result
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+
+		prev, cur = cur, prev
+	}
+
+	return prev[len(b)]
+}
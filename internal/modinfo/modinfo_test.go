@@ -0,0 +1,239 @@
+package modinfo
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const testGoMod = `module example.com/app
+
+go 1.22
+
+require (
+	example.com/direct v1.2.0
+	example.com/indirect v0.3.1 // indirect
+	example.com/replaced v1.0.0
+	example.com/pinned v1.1.0
+	example.com/tools v1.4.0
+	example.com/tools/nested v0.1.0
+)
+
+replace example.com/replaced => ../replaced
+
+replace example.com/pinned v1.0.0 => example.com/fork v1.0.1
+
+tool (
+	example.com/tools/cmd/gen
+	example.com/tools/nested/cmd/lint
+)
+`
+
+func parseTestGoMod(t *testing.T) *File {
+	t.Helper()
+	f, err := ParseData("go.mod", []byte(testGoMod))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return f
+}
+
+func TestVersion(t *testing.T) {
+	f := parseTestGoMod(t)
+	cases := []struct {
+		path    string
+		want    string
+		wantErr error
+	}{
+		{path: "example.com/direct", want: "v1.2.0"},
+		{path: "example.com/indirect", want: "v0.3.1"},
+		{path: "example.com/missing", wantErr: ErrNotFound},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.path, func(t *testing.T) {
+			got, err := f.Version(tc.path)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("expected error %v, got %v", tc.wantErr, err)
+			}
+
+			if got != tc.want {
+				t.Fatalf("expected %q, got %q", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestIndirect(t *testing.T) {
+	f := parseTestGoMod(t)
+	cases := map[string]bool{
+		"example.com/direct":   false,
+		"example.com/indirect": true,
+		"example.com/missing":  false,
+	}
+
+	for path, want := range cases {
+		if got := f.Indirect(path); got != want {
+			t.Errorf("Indirect(%q): expected %t, got %t", path, want, got)
+		}
+	}
+}
+
+func TestReplaced(t *testing.T) {
+	cases := []struct {
+		name   string
+		gomod  string
+		path   string
+		want   Replacement
+		wantOK bool
+	}{
+		{
+			name:   "all versions",
+			gomod:  testGoMod,
+			path:   "example.com/replaced",
+			want:   Replacement{OldPath: "example.com/replaced", NewPath: "../replaced"},
+			wantOK: true,
+		},
+		{
+			name:  "other version",
+			gomod: testGoMod,
+			path:  "example.com/pinned",
+		},
+		{
+			name: "required version",
+			gomod: `module example.com/app
+
+require example.com/pinned v1.0.0
+
+replace example.com/pinned v1.0.0 => example.com/fork v1.0.1
+`,
+			path: "example.com/pinned",
+			want: Replacement{
+				OldPath:    "example.com/pinned",
+				OldVersion: "v1.0.0",
+				NewPath:    "example.com/fork",
+				NewVersion: "v1.0.1",
+			},
+			wantOK: true,
+		},
+		{
+			name:  "not replaced",
+			gomod: testGoMod,
+			path:  "example.com/direct",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			f, err := ParseData("go.mod", []byte(tc.gomod))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, ok := f.Replaced(tc.path)
+			if ok != tc.wantOK {
+				t.Fatalf("expected ok to be %t, got %t", tc.wantOK, ok)
+			}
+
+			if got != tc.want {
+				t.Fatalf("expected %+v, got %+v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestDirectRequirements(t *testing.T) {
+	f := parseTestGoMod(t)
+	var got []string
+	for _, r := range f.DirectRequirements() {
+		got = append(got, r.Path)
+	}
+
+	want := []string{
+		"example.com/direct",
+		"example.com/replaced",
+		"example.com/pinned",
+		"example.com/tools",
+		"example.com/tools/nested",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
+
+func TestTools(t *testing.T) {
+	f := parseTestGoMod(t)
+	cases := map[string][]string{
+		"example.com/tools":        {"example.com/tools/cmd/gen"},
+		"example.com/tools/nested": {"example.com/tools/nested/cmd/lint"},
+		"example.com/direct":       nil,
+	}
+
+	for path, want := range cases {
+		if got := f.Tools(path); !reflect.DeepEqual(got, want) {
+			t.Errorf("Tools(%q): expected %v, got %v", path, want, got)
+		}
+	}
+}
+
+func TestGoVersion(t *testing.T) {
+	if got := parseTestGoMod(t).GoVersion(); got != "1.22" {
+		t.Fatalf("expected 1.22, got %q", got)
+	}
+}
+
+func TestWorkspaceModules(t *testing.T) {
+	dir := t.TempDir()
+	gowork := filepath.Join(dir, "go.work")
+	data := "go 1.22\n\nuse (\n\t.\n\t./a\n\t../b\n)\n"
+	if err := os.WriteFile(gowork, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := WorkspaceModules(gowork)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{dir, filepath.Join(dir, "a"), filepath.Join(filepath.Dir(dir), "b")}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
+
+func TestFindAll(t *testing.T) {
+	root := t.TempDir()
+	for _, d := range []string{
+		".",
+		"a",
+		"a/b",
+		"vendor/example.com/dep",
+		"testdata/mod",
+		".hidden",
+		"_skipped",
+		"c/testdata",
+	} {
+		if err := os.MkdirAll(filepath.Join(root, d), 0o755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(filepath.Join(root, d, "go.mod"), []byte("module example.com/x\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := FindAll(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The order is that of the go.mod files, so a/b/go.mod sorts before
+	// a/go.mod.
+	want := []string{filepath.Join(root, "a", "b"), filepath.Join(root, "a"), root}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
@@ -0,0 +1,262 @@
+// Package modrepo maps module paths to the source repositories that
+// host them, so that links to releases and comparisons can be built.
+package modrepo
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Repo is a source repository on a recognized host.
+type Repo struct {
+	// Host is the repository host, for example github.com.
+	Host string
+
+	// Owner and Name identify the repository on the host.
+	Owner string
+	Name  string
+
+	// Subdir is the directory of the module within the repository,
+	// without any major version suffix. It is empty for modules at the
+	// repository root.
+	Subdir string
+}
+
+// URL returns the web URL of the repository.
+func (r Repo) URL() string {
+	return "https://" + r.Host + "/" + r.Owner + "/" + r.Name
+}
+
+// TagPrefix returns the prefix of the module's version tags, which is
+// the subdirectory of the module within the repository, if any.
+func (r Repo) TagPrefix() string {
+	if r.Subdir == "" {
+		return ""
+	}
+
+	return r.Subdir + "/"
+}
+
+// TreeURL returns the web URL for browsing the repository at ref.
+func (r Repo) TreeURL(ref string) string {
+	if r.Host == "gitlab.com" {
+		return r.URL() + "/-/tree/" + ref
+	}
+
+	return r.URL() + "/tree/" + ref
+}
+
+// CompareURL returns the web URL for comparing oldRef to newRef.
+func (r Repo) CompareURL(oldRef, newRef string) string {
+	if r.Host == "gitlab.com" {
+		return r.URL() + "/-/compare/" + oldRef + "..." + newRef
+	}
+
+	return r.URL() + "/compare/" + oldRef + "..." + newRef
+}
+
+// recognizedHosts are the hosts that links can be built for.
+var recognizedHosts = map[string]bool{
+	"github.com": true,
+	"gitlab.com": true,
+}
+
+// majorSuffixRe matches a major version suffix element.
+var majorSuffixRe = regexp.MustCompile(`^v[0-9]+$`)
+
+// fromRepoPath builds a Repo for a module path, given the path of the
+// repository root on a recognized host (HOST/OWNER/NAME), and the
+// module path prefix it corresponds to.
+func fromRepoPath(repoPath, prefix, modPath string) (Repo, bool) {
+	parts := strings.Split(strings.TrimSuffix(repoPath, ".git"), "/")
+	if len(parts) != 3 || !recognizedHosts[parts[0]] || parts[1] == "" || parts[2] == "" {
+		return Repo{}, false
+	}
+
+	r := Repo{Host: parts[0], Owner: parts[1], Name: parts[2]}
+	if modPath != prefix && strings.HasPrefix(modPath, prefix+"/") {
+		sub := strings.Split(strings.TrimPrefix(modPath, prefix+"/"), "/")
+		if majorSuffixRe.MatchString(sub[len(sub)-1]) {
+			sub = sub[:len(sub)-1]
+		}
+
+		r.Subdir = strings.Join(sub, "/")
+	}
+
+	return r, true
+}
+
+// Resolver resolves module paths to repositories. Results are cached
+// for the lifetime of the Resolver.
+type Resolver struct {
+	// Client is used for go-import meta tag lookups. If nil, a client
+	// with a short timeout is used.
+	Client *http.Client
+
+	cache map[string]resolved
+}
+
+type resolved struct {
+	repo Repo
+	ok   bool
+}
+
+// defaultClient is used when a Resolver has no Client.
+var defaultClient = &http.Client{Timeout: 10 * time.Second}
+
+// Resolve returns the repository hosting the module path, and whether
+// one could be found on a recognized host. Failures of any kind are
+// reported as not found.
+func (r *Resolver) Resolve(modPath string) (Repo, bool) {
+	if r.cache == nil {
+		r.cache = make(map[string]resolved)
+	}
+
+	if res, ok := r.cache[modPath]; ok {
+		return res.repo, res.ok
+	}
+
+	repo, ok := r.resolve(modPath)
+	r.cache[modPath] = resolved{repo, ok}
+	return repo, ok
+}
+
+func (r *Resolver) resolve(modPath string) (Repo, bool) {
+	parts := strings.Split(modPath, "/")
+	if recognizedHosts[parts[0]] {
+		if len(parts) < 3 {
+			return Repo{}, false
+		}
+
+		prefix := strings.Join(parts[:3], "/")
+		return fromRepoPath(prefix, prefix, modPath)
+	}
+
+	if parts[0] == "gopkg.in" {
+		return resolveGopkgIn(modPath)
+	}
+
+	if repo, ok := resolveKnown(modPath); ok {
+		return repo, true
+	}
+
+	return r.resolveVanity(modPath)
+}
+
+// knownMirrors maps custom import path prefixes to the recognized host
+// and owner that mirror their repositories, where each element directly
+// under the prefix is a repository of the same name. These are common
+// enough that a go-import lookup isn't worth the round trip, and their
+// go-import tags point to hosts that links can't be built for.
+var knownMirrors = map[string]string{
+	"golang.org/x": "github.com/golang",
+}
+
+// resolveKnown resolves module paths under the prefixes in
+// knownMirrors.
+func resolveKnown(modPath string) (Repo, bool) {
+	for prefix, mirror := range knownMirrors {
+		if !strings.HasPrefix(modPath, prefix+"/") {
+			continue
+		}
+
+		name := strings.Split(strings.TrimPrefix(modPath, prefix+"/"), "/")[0]
+		return fromRepoPath(mirror+"/"+name, prefix+"/"+name, modPath)
+	}
+
+	return Repo{}, false
+}
+
+// gopkgInRe matches gopkg.in module paths, in both the gopkg.in/pkg.vN
+// and gopkg.in/user/pkg.vN forms.
+var gopkgInRe = regexp.MustCompile(`^gopkg\.in/(?:([a-zA-Z0-9][-a-zA-Z0-9]*)/)?([a-zA-Z][-.a-zA-Z0-9]*)\.(v0|v[1-9][0-9]*)$`)
+
+// resolveGopkgIn translates a gopkg.in module path to its GitHub
+// repository, following the gopkg.in rules: gopkg.in/pkg.vN is
+// github.com/go-pkg/pkg, and gopkg.in/user/pkg.vN is
+// github.com/user/pkg. The major version selects tags in the
+// repository, so it doesn't correspond to a subdirectory.
+func resolveGopkgIn(modPath string) (Repo, bool) {
+	m := gopkgInRe.FindStringSubmatch(modPath)
+	if m == nil {
+		return Repo{}, false
+	}
+
+	owner := m[1]
+	if owner == "" {
+		owner = "go-" + m[2]
+	}
+
+	return Repo{Host: "github.com", Owner: owner, Name: m[2]}, true
+}
+
+// metaTagRe matches a meta tag, and attrRe its attributes.
+var (
+	metaTagRe = regexp.MustCompile(`(?is)<meta\s[^>]*>`)
+	attrRe    = regexp.MustCompile(`(?is)([a-z-]+)\s*=\s*("[^"]*"|'[^']*')`)
+)
+
+// resolveVanity resolves a custom import path using the go-import
+// meta tag, in the same way the go tool does.
+func (r *Resolver) resolveVanity(modPath string) (Repo, bool) {
+	client := r.Client
+	if client == nil {
+		client = defaultClient
+	}
+
+	resp, err := client.Get("https://" + modPath + "?go-get=1")
+	if err != nil {
+		return Repo{}, false
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return Repo{}, false
+	}
+
+	// The tags are in the document head, which shouldn't be large.
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return Repo{}, false
+	}
+
+	return parseGoImport(string(body), modPath)
+}
+
+// parseGoImport finds the go-import meta tag for modPath in an HTML
+// document, and returns the repository it refers to.
+func parseGoImport(doc, modPath string) (Repo, bool) {
+	for _, tag := range metaTagRe.FindAllString(doc, -1) {
+		attrs := make(map[string]string)
+		for _, m := range attrRe.FindAllStringSubmatch(tag, -1) {
+			attrs[strings.ToLower(m[1])] = m[2][1 : len(m[2])-1]
+		}
+
+		if attrs["name"] != "go-import" {
+			continue
+		}
+
+		// content is "import-prefix vcs repo-root".
+		f := strings.Fields(attrs["content"])
+		if len(f) != 3 || f[1] != "git" {
+			continue
+		}
+
+		if modPath != f[0] && !strings.HasPrefix(modPath, f[0]+"/") {
+			continue
+		}
+
+		u, err := url.Parse(f[2])
+		if err != nil {
+			continue
+		}
+
+		return fromRepoPath(strings.ToLower(u.Host)+strings.TrimSuffix(u.Path, "/"), f[0], modPath)
+	}
+
+	return Repo{}, false
+}
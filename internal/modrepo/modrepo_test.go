@@ -0,0 +1,229 @@
+package modrepo
+
+import "testing"
+
+func TestResolveGopkgIn(t *testing.T) {
+	cases := []struct {
+		path   string
+		want   Repo
+		wantOK bool
+	}{
+		{
+			path:   "gopkg.in/yaml.v3",
+			want:   Repo{Host: "github.com", Owner: "go-yaml", Name: "yaml"},
+			wantOK: true,
+		},
+		{
+			path:   "gopkg.in/pkg.v1",
+			want:   Repo{Host: "github.com", Owner: "go-pkg", Name: "pkg"},
+			wantOK: true,
+		},
+		{
+			path:   "gopkg.in/pkg.v2",
+			want:   Repo{Host: "github.com", Owner: "go-pkg", Name: "pkg"},
+			wantOK: true,
+		},
+		{
+			path:   "gopkg.in/user/pkg.v0",
+			want:   Repo{Host: "github.com", Owner: "user", Name: "pkg"},
+			wantOK: true,
+		},
+		{
+			path:   "gopkg.in/check.v1",
+			want:   Repo{Host: "github.com", Owner: "go-check", Name: "check"},
+			wantOK: true,
+		},
+		{path: "gopkg.in/pkg"},
+		{path: "gopkg.in/pkg.v01"},
+		{path: "gopkg.in/user/pkg.v1/sub"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.path, func(t *testing.T) {
+			got, ok := resolveGopkgIn(tc.path)
+			if ok != tc.wantOK {
+				t.Fatalf("expected ok to be %t, got %t", tc.wantOK, ok)
+			}
+
+			if got != tc.want {
+				t.Fatalf("expected %+v, got %+v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestFromRepoPath(t *testing.T) {
+	cases := []struct {
+		repoPath string
+		prefix   string
+		modPath  string
+		want     Repo
+		wantOK   bool
+	}{
+		{
+			repoPath: "github.com/o/r",
+			prefix:   "github.com/o/r",
+			modPath:  "github.com/o/r",
+			want:     Repo{Host: "github.com", Owner: "o", Name: "r"},
+			wantOK:   true,
+		},
+		{
+			repoPath: "github.com/o/r",
+			prefix:   "github.com/o/r",
+			modPath:  "github.com/o/r/v2",
+			want:     Repo{Host: "github.com", Owner: "o", Name: "r"},
+			wantOK:   true,
+		},
+		{
+			repoPath: "github.com/o/r",
+			prefix:   "github.com/o/r",
+			modPath:  "github.com/o/r/sub/v3",
+			want:     Repo{Host: "github.com", Owner: "o", Name: "r", Subdir: "sub"},
+			wantOK:   true,
+		},
+		{
+			repoPath: "gitlab.com/o/r.git",
+			prefix:   "example.com/r",
+			modPath:  "example.com/r/a/b",
+			want:     Repo{Host: "gitlab.com", Owner: "o", Name: "r", Subdir: "a/b"},
+			wantOK:   true,
+		},
+		{
+			repoPath: "bitbucket.org/o/r",
+			prefix:   "bitbucket.org/o/r",
+			modPath:  "bitbucket.org/o/r",
+		},
+		{
+			repoPath: "github.com/o",
+			prefix:   "github.com/o",
+			modPath:  "github.com/o",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.modPath, func(t *testing.T) {
+			got, ok := fromRepoPath(tc.repoPath, tc.prefix, tc.modPath)
+			if ok != tc.wantOK {
+				t.Fatalf("expected ok to be %t, got %t", tc.wantOK, ok)
+			}
+
+			if got != tc.want {
+				t.Fatalf("expected %+v, got %+v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestParseGoImport(t *testing.T) {
+	cases := []struct {
+		name    string
+		doc     string
+		modPath string
+		want    Repo
+		wantOK  bool
+	}{
+		{
+			name: "vanity",
+			doc: `<html><head>
+<meta name="go-import" content="go.uber.org/zap git https://github.com/uber-go/zap">
+<meta name="go-source" content="go.uber.org/zap https://github.com/uber-go/zap https://github.com/uber-go/zap/tree/master{/dir} https://github.com/uber-go/zap/tree/master{/dir}/{file}#L{line}">
+</head></html>`,
+			modPath: "go.uber.org/zap",
+			want:    Repo{Host: "github.com", Owner: "uber-go", Name: "zap"},
+			wantOK:  true,
+		},
+		{
+			name:    "submodule",
+			doc:     `<meta content='example.com/mono git https://GitHub.com/o/mono.git/' name='go-import'>`,
+			modPath: "example.com/mono/tools/v2",
+			want:    Repo{Host: "github.com", Owner: "o", Name: "mono", Subdir: "tools"},
+			wantOK:  true,
+		},
+		{
+			name:    "other prefix",
+			doc:     `<meta name="go-import" content="example.com/other git https://github.com/o/other">`,
+			modPath: "example.com/mono",
+		},
+		{
+			name:    "not git",
+			doc:     `<meta name="go-import" content="example.com/hg hg https://github.com/o/hg">`,
+			modPath: "example.com/hg",
+		},
+		{
+			name:    "unrecognized host",
+			doc:     `<meta name="go-import" content="example.com/self git https://git.example.com/o/self">`,
+			modPath: "example.com/self",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, ok := parseGoImport(tc.doc, tc.modPath)
+			if ok != tc.wantOK {
+				t.Fatalf("expected ok to be %t, got %t", tc.wantOK, ok)
+			}
+
+			if got != tc.want {
+				t.Fatalf("expected %+v, got %+v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestURLs(t *testing.T) {
+	cases := []struct {
+		name        string
+		repo        Repo
+		oldTag      string
+		newTag      string
+		wantTree    string
+		wantCompare string
+	}{
+		{
+			name:        "github",
+			repo:        Repo{Host: "github.com", Owner: "o", Name: "r"},
+			oldTag:      "v1.2.0",
+			newTag:      "v1.3.0-rc.1",
+			wantTree:    "https://github.com/o/r/tree/v1.3.0-rc.1",
+			wantCompare: "https://github.com/o/r/compare/v1.2.0...v1.3.0-rc.1",
+		},
+		{
+			name:        "github subdirectory",
+			repo:        Repo{Host: "github.com", Owner: "o", Name: "r", Subdir: "sub/mod"},
+			oldTag:      "v2.0.4",
+			newTag:      "v2.0.5",
+			wantTree:    "https://github.com/o/r/tree/sub/mod/v2.0.5",
+			wantCompare: "https://github.com/o/r/compare/sub/mod/v2.0.4...sub/mod/v2.0.5",
+		},
+		{
+			name:        "gitlab",
+			repo:        Repo{Host: "gitlab.com", Owner: "o", Name: "r"},
+			oldTag:      "v0.1.0",
+			newTag:      "v0.2.0",
+			wantTree:    "https://gitlab.com/o/r/-/tree/v0.2.0",
+			wantCompare: "https://gitlab.com/o/r/-/compare/v0.1.0...v0.2.0",
+		},
+		{
+			name:        "gitlab subdirectory",
+			repo:        Repo{Host: "gitlab.com", Owner: "o", Name: "r", Subdir: "api"},
+			oldTag:      "v1.0.0",
+			newTag:      "v1.1.0",
+			wantTree:    "https://gitlab.com/o/r/-/tree/api/v1.1.0",
+			wantCompare: "https://gitlab.com/o/r/-/compare/api/v1.0.0...api/v1.1.0",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			oldRef := tc.repo.TagPrefix() + tc.oldTag
+			newRef := tc.repo.TagPrefix() + tc.newTag
+			if got := tc.repo.TreeURL(newRef); got != tc.wantTree {
+				t.Errorf("TreeURL: expected %q, got %q", tc.wantTree, got)
+			}
+
+			if got := tc.repo.CompareURL(oldRef, newRef); got != tc.wantCompare {
+				t.Errorf("CompareURL: expected %q, got %q", tc.wantCompare, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+// Package trailer formats and parses the git trailers that identify
+// the update a depbump commit makes, so that other tools don't need to
+// parse the commit message itself.
+//
+// The trailers are in the last paragraph of the message, one per line:
+//
+//	Depbump-Module: github.com/foo/bar
+//	Depbump-Old-Version: v1.2.0
+//	Depbump-New-Version: v1.3.0
+//	Depbump-Target: github.com/foo/bar@v1.3.0
+//	Depbump-Deprecated: true
+//
+// Trailers with no value are left out, and Depbump-Deprecated is only
+// there if the module is deprecated. They can be read with git alone,
+// using "git log --format='%(trailers:key=Depbump-Module,valueonly)'".
+package trailer
+
+import (
+	"regexp"
+	"strings"
+)
+
+// The trailer keys.
+const (
+	ModuleKey     = "Depbump-Module"
+	OldVersionKey = "Depbump-Old-Version"
+	NewVersionKey = "Depbump-New-Version"
+	TargetKey     = "Depbump-Target"
+	DeprecatedKey = "Depbump-Deprecated"
+)
+
+// Trailers describe an update. Module is the module path, or "go" or
+// "tidy" for those updates, or the group prefix for a group; Target is
+// what was passed to the go tool. Deprecated is set if the module is
+// deprecated.
+type Trailers struct {
+	Module     string
+	OldVersion string
+	NewVersion string
+	Target     string
+	Deprecated bool
+}
+
+// String returns the trailer lines, each ending in a newline.
+func (t Trailers) String() string {
+	var deprecated string
+	if t.Deprecated {
+		deprecated = "true"
+	}
+
+	var b strings.Builder
+	for _, f := range []struct{ key, value string }{
+		{ModuleKey, t.Module},
+		{OldVersionKey, t.OldVersion},
+		{NewVersionKey, t.NewVersion},
+		{TargetKey, t.Target},
+		{DeprecatedKey, deprecated},
+	} {
+		if f.value != "" {
+			b.WriteString(f.key + ": " + f.value + "\n")
+		}
+	}
+
+	return b.String()
+}
+
+// lineRe matches a trailer line, in the form git recognizes.
+var lineRe = regexp.MustCompile(`^([A-Za-z0-9-]+):\s*(.*)$`)
+
+// isTrailerBlock returns true if every line of the paragraph p is a
+// trailer.
+func isTrailerBlock(p string) bool {
+	for _, l := range strings.Split(p, "\n") {
+		if !lineRe.MatchString(l) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Append adds the trailers to message. They join the trailers already
+// at the end of the message, such as Signed-off-by, if there are any,
+// or are added as a new paragraph.
+func Append(message string, t Trailers) string {
+	lines := t.String()
+	if lines == "" {
+		return message
+	}
+
+	message = strings.TrimRight(message, " \t\n")
+	paragraphs := strings.Split(message, "\n\n")
+	if len(paragraphs) > 1 && isTrailerBlock(paragraphs[len(paragraphs)-1]) {
+		return message + "\n" + lines
+	}
+
+	return message + "\n\n" + lines
+}
+
+// Parse returns the trailers in a commit message, such as one from
+// "git log --format=%B", and whether it has any; only the last
+// paragraph is searched, as git does. A commit that wasn't made by
+// depbump has none.
+func Parse(message string) (Trailers, bool) {
+	paragraphs := strings.Split(strings.TrimRight(message, " \t\n"), "\n\n")
+	last := paragraphs[len(paragraphs)-1]
+	if len(paragraphs) < 2 || !isTrailerBlock(last) {
+		return Trailers{}, false
+	}
+
+	var t Trailers
+	for _, l := range strings.Split(last, "\n") {
+		m := lineRe.FindStringSubmatch(l)
+		value := strings.TrimSpace(m[2])
+		switch {
+		case strings.EqualFold(m[1], ModuleKey):
+			t.Module = value
+		case strings.EqualFold(m[1], OldVersionKey):
+			t.OldVersion = value
+		case strings.EqualFold(m[1], NewVersionKey):
+			t.NewVersion = value
+		case strings.EqualFold(m[1], TargetKey):
+			t.Target = value
+		case strings.EqualFold(m[1], DeprecatedKey):
+			t.Deprecated = value == "true"
+		}
+	}
+
+	return t, t.Module != ""
+}
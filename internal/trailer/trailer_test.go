@@ -0,0 +1,141 @@
+package trailer
+
+import "testing"
+
+var testTrailers = Trailers{
+	Module:     "rsc.io/quote",
+	OldVersion: "v1.5.1",
+	NewVersion: "v1.5.2",
+	Target:     "rsc.io/quote@v1.5.2",
+}
+
+func TestAppend(t *testing.T) {
+	cases := []struct {
+		name     string
+		message  string
+		trailers Trailers
+		want     string
+	}{
+		{
+			name:     "no trailers",
+			message:  "modules: upgrade quote to 1.5.2\n\nThis commit message was auto-generated.\n",
+			trailers: testTrailers,
+			want: `modules: upgrade quote to 1.5.2
+
+This commit message was auto-generated.
+
+Depbump-Module: rsc.io/quote
+Depbump-Old-Version: v1.5.1
+Depbump-New-Version: v1.5.2
+Depbump-Target: rsc.io/quote@v1.5.2
+`,
+		},
+		{
+			name:     "existing trailers",
+			message:  "modules: upgrade quote to 1.5.2\n\nSome details.\n\nSigned-off-by: A U Thor <author@example.com>\n\n",
+			trailers: Trailers{Module: "rsc.io/quote", Deprecated: true},
+			want: `modules: upgrade quote to 1.5.2
+
+Some details.
+
+Signed-off-by: A U Thor <author@example.com>
+Depbump-Module: rsc.io/quote
+Depbump-Deprecated: true
+`,
+		},
+		{
+			name:     "subject only",
+			message:  "Reviewed-by: someone",
+			trailers: Trailers{Module: "go", NewVersion: "1.23.0"},
+			want:     "Reviewed-by: someone\n\nDepbump-Module: go\nDepbump-New-Version: 1.23.0\n",
+		},
+		{
+			name:    "empty",
+			message: "modules: upgrade quote\n",
+			want:    "modules: upgrade quote\n",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := Append(tc.message, tc.trailers)
+			if got != tc.want {
+				t.Fatalf("expected:\n%s\ngot:\n%s", tc.want, got)
+			}
+
+			// What is appended can be read back.
+			if tc.trailers == (Trailers{}) {
+				return
+			}
+
+			parsed, ok := Parse(got)
+			if !ok {
+				t.Fatal("expected trailers to be found")
+			}
+
+			if parsed != tc.trailers {
+				t.Fatalf("expected %+v, got %+v", tc.trailers, parsed)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	cases := []struct {
+		name    string
+		message string
+		want    Trailers
+		wantOK  bool
+	}{
+		{
+			name: "final paragraph",
+			message: `modules: upgrade quote to 1.5.2
+
+Depbump-Module: rsc.io/quote
+depbump-new-version: v1.5.2
+Signed-off-by: A U Thor <author@example.com>
+`,
+			want:   Trailers{Module: "rsc.io/quote", NewVersion: "v1.5.2"},
+			wantOK: true,
+		},
+		{
+			name: "not the final paragraph",
+			message: `modules: upgrade quote to 1.5.2
+
+Depbump-Module: rsc.io/quote
+Depbump-New-Version: v1.5.2
+
+This commit message was auto-generated.
+`,
+		},
+		{
+			name: "mixed with prose",
+			message: `modules: upgrade quote to 1.5.2
+
+For details, see:
+Depbump-Module: rsc.io/quote
+`,
+		},
+		{
+			name:    "subject only",
+			message: "Depbump-Module: rsc.io/quote\n",
+		},
+		{
+			name:    "other trailers",
+			message: "fix a bug\n\nSigned-off-by: A U Thor <author@example.com>\n",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, ok := Parse(tc.message)
+			if ok != tc.wantOK {
+				t.Fatalf("expected ok to be %t, got %t", tc.wantOK, ok)
+			}
+
+			if got != tc.want {
+				t.Fatalf("expected %+v, got %+v", tc.want, got)
+			}
+		})
+	}
+}